@@ -1,25 +1,62 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"electronic_kanban_backend/utils"
+
+	"github.com/lib/pq" // PostgreSQL driver
 )
 
-// ConnectDB establishes a database connection
-func ConnectDB(connStr string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", connStr)
+// ConnectDB establishes a database connection, with the pool sized by cfg. Every connection of the pool
+// gets cfg.QueryTimeout as statement_timeout, so PostgreSQL cancels a query that runs longer, and runs each
+// query with the tenant of its context (see tenantConnector).
+func ConnectDB(ctx context.Context, cfg utils.DBConfig) (*sql.DB, error) {
+	connector, err := pq.NewConnector(withStatementTimeout(cfg.ConnectionString, cfg.QueryTimeout))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
+	db := sql.OpenDB(tenantConnector{connector})
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	err = db.Ping()
+	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Println("Successfully connected to database")
+	warnIfBypassingTenants(ctx, db)
+
+	slog.Debug("database connection established", "max_open_conns", cfg.MaxOpenConns, "max_idle_conns", cfg.MaxIdleConns, "query_timeout", cfg.QueryTimeout)
 	return db, nil
 }
+
+// withStatementTimeout adds the statement_timeout run-time parameter to a connection string in URL or
+// key=value form, unless it is zero or the connection string already sets one
+func withStatementTimeout(connStr string, timeout time.Duration) string {
+	if timeout <= 0 || strings.Contains(connStr, "statement_timeout") {
+		return connStr
+	}
+	ms := strconv.FormatInt(timeout.Milliseconds(), 10)
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return connStr // sql.Open reports it
+		}
+		query := u.Query()
+		query.Set("statement_timeout", ms)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return connStr + " statement_timeout=" + ms
+}
@@ -0,0 +1,387 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"electronic_kanban_backend/utils"
+)
+
+// migration is a single, ordered schema change applied on startup
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations lists the schema changes on top of the base tables, in order.
+// Never edit an entry that has been released; append a new one instead.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "unique vat_number on accounts for import upserts",
+		SQL: `
+			-- Normalise the VAT numbers as the API does: trimmed, upper case, without spaces, dots and dashes
+			UPDATE accounts
+			SET vat_number = UPPER(REPLACE(REPLACE(REPLACE(BTRIM(vat_number, E' \t\r\n'), ' ', ''), '.', ''), '-', ''))
+			WHERE vat_number <> UPPER(REPLACE(REPLACE(REPLACE(BTRIM(vat_number, E' \t\r\n'), ' ', ''), '.', ''), '-', ''));
+
+			-- Accounts sharing a VAT number must be merged by hand: name them rather than fail on the index
+			DO $$
+			DECLARE
+				duplicates TEXT;
+			BEGIN
+				SELECT string_agg(format('%s: %s', vat_number, accounts), '; ' ORDER BY vat_number)
+				INTO duplicates
+				FROM (
+					SELECT vat_number, string_agg(format('account %s %L', id, name), ', ' ORDER BY id) AS accounts
+					FROM accounts
+					WHERE vat_number <> ''
+					GROUP BY vat_number
+					HAVING COUNT(*) > 1
+				) d;
+				IF duplicates IS NOT NULL THEN
+					RAISE EXCEPTION 'accounts share a VAT number, merge them or correct their VAT numbers before upgrading: %', duplicates;
+				END IF;
+			END $$;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS accounts_vat_number_key
+				ON accounts (vat_number)
+				WHERE vat_number <> '';
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "indexes for list sorting and filtering",
+		SQL: `
+			CREATE INDEX IF NOT EXISTS accounts_name_idx ON accounts (name);
+			CREATE INDEX IF NOT EXISTS products_name_idx ON products (name);
+			CREATE INDEX IF NOT EXISTS statuses_name_idx ON statuses (name);
+			CREATE INDEX IF NOT EXISTS status_chains_name_idx ON status_chains (name);
+			CREATE INDEX IF NOT EXISTS kanban_chains_cliente_id_idx ON kanban_chains (cliente_id);
+			CREATE INDEX IF NOT EXISTS kanban_chains_fornitore_id_idx ON kanban_chains (fornitore_id);
+			CREATE INDEX IF NOT EXISTS kanban_chains_prodotto_codice_idx ON kanban_chains (prodotto_codice);
+			CREATE INDEX IF NOT EXISTS kanban_chains_status_chain_id_idx ON kanban_chains (status_chain_id);
+			CREATE INDEX IF NOT EXISTS kanbans_active_kanban_chain_id_idx ON kanbans (kanban_chain_id) WHERE is_active;
+			CREATE INDEX IF NOT EXISTS kanbans_active_status_current_idx ON kanbans (status_current) WHERE is_active;
+			CREATE INDEX IF NOT EXISTS kanbans_active_data_aggiornamento_idx ON kanbans (data_aggiornamento) WHERE is_active;
+		`,
+	},
+	{
+		Version: 3,
+		Name:    "kanban chain change audit trail and deferred propagation to cards",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS kanban_chain_changes (
+				id                   SERIAL PRIMARY KEY,
+				kanban_chain_id      INTEGER NOT NULL REFERENCES kanban_chains (id) ON DELETE CASCADE,
+				changed_at           TIMESTAMP NOT NULL DEFAULT NOW(),
+				propagation          TEXT NOT NULL,
+				old_leadtime_days    INTEGER NOT NULL,
+				new_leadtime_days    INTEGER NOT NULL,
+				old_quantity         DOUBLE PRECISION NOT NULL,
+				new_quantity         DOUBLE PRECISION NOT NULL,
+				old_tipo_contenitore TEXT NOT NULL,
+				new_tipo_contenitore TEXT NOT NULL,
+				old_status_chain_id  INTEGER NOT NULL,
+				new_status_chain_id  INTEGER NOT NULL,
+				cards_updated        INTEGER NOT NULL DEFAULT 0,
+				request_id           TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX IF NOT EXISTS kanban_chain_changes_kanban_chain_id_idx ON kanban_chain_changes (kanban_chain_id, changed_at);
+			ALTER TABLE kanbans ADD COLUMN IF NOT EXISTS pending_chain_change_id INTEGER
+				REFERENCES kanban_chain_changes (id) ON DELETE SET NULL;
+			CREATE INDEX IF NOT EXISTS kanbans_pending_chain_change_id_idx ON kanbans (pending_chain_change_id)
+				WHERE pending_chain_change_id IS NOT NULL;
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "archive master data instead of deleting it",
+		SQL: `
+			ALTER TABLE accounts ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+			ALTER TABLE statuses ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+			ALTER TABLE status_chains ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+			ALTER TABLE kanban_chains ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+			CREATE INDEX IF NOT EXISTS kanban_chains_not_archived_idx ON kanban_chains (id) WHERE archived_at IS NULL;
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "hash-chained audit log of write operations",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS audit_log (
+				id          BIGSERIAL PRIMARY KEY,
+				occurred_at TIMESTAMP NOT NULL,
+				actor       TEXT NOT NULL,
+				request_id  TEXT NOT NULL DEFAULT '',
+				action      TEXT NOT NULL,
+				entity      TEXT NOT NULL,
+				entity_id   TEXT NOT NULL DEFAULT '',
+				before      JSONB,
+				after       JSONB,
+				changes     JSONB,
+				prev_hash   TEXT NOT NULL,
+				hash        TEXT NOT NULL UNIQUE
+			);
+			CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id);
+			CREATE INDEX IF NOT EXISTS audit_log_occurred_at_idx ON audit_log (occurred_at);
+			CREATE INDEX IF NOT EXISTS audit_log_request_id_idx ON audit_log (request_id);
+
+			CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_log is append-only';
+			END;
+			$$ LANGUAGE plpgsql;
+			DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+			CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+				FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "stage of the kanban loop of each status chain status",
+		SQL: `
+			ALTER TABLE status_chains_statuses ADD COLUMN IF NOT EXISTS stage TEXT
+				CHECK (stage IN ('to_produce', 'in_production', 'in_transit', 'at_customer'));
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "saved dashboard views per user",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS dashboard_views (
+				id         SERIAL PRIMARY KEY,
+				owner      TEXT NOT NULL,
+				dashboard  TEXT NOT NULL CHECK (dashboard IN ('supplier', 'customer')),
+				name       TEXT NOT NULL,
+				query      TEXT NOT NULL DEFAULT '',
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				UNIQUE (owner, dashboard, name)
+			);
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "retirement time of inactive kanbans",
+		SQL: `
+			ALTER TABLE kanbans ADD COLUMN IF NOT EXISTS retired_at TIMESTAMP;
+			UPDATE kanbans SET retired_at = data_aggiornamento WHERE is_active = false AND retired_at IS NULL;
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "sites of accounts referenced by kanban chains",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS sites (
+				id          SERIAL PRIMARY KEY,
+				account_id  INTEGER NOT NULL REFERENCES accounts (id),
+				name        TEXT NOT NULL,
+				address     TEXT NOT NULL DEFAULT '',
+				archived_at TIMESTAMP,
+				UNIQUE (account_id, name)
+			);
+			ALTER TABLE kanban_chains ADD COLUMN IF NOT EXISTS customer_site_id INTEGER REFERENCES sites (id);
+			ALTER TABLE kanban_chains ADD COLUMN IF NOT EXISTS supplier_site_id INTEGER REFERENCES sites (id);
+			CREATE INDEX IF NOT EXISTS kanban_chains_customer_site_id_idx ON kanban_chains (customer_site_id);
+			CREATE INDEX IF NOT EXISTS kanban_chains_supplier_site_id_idx ON kanban_chains (supplier_site_id);
+		`,
+	},
+	{
+		Version: 10,
+		Name:    "contacts of accounts with their notification preferences",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS contacts (
+				id             SERIAL PRIMARY KEY,
+				account_id     INTEGER NOT NULL REFERENCES accounts (id),
+				name           TEXT NOT NULL,
+				email          TEXT NOT NULL DEFAULT '',
+				phone          TEXT NOT NULL DEFAULT '',
+				role           TEXT NOT NULL CHECK (role IN ('planner', 'logistics', 'quality')),
+				notify_channel TEXT NOT NULL DEFAULT 'none' CHECK (notify_channel IN ('none', 'email', 'sms')),
+				notify_events  TEXT[] NOT NULL DEFAULT '{}'
+			);
+			CREATE INDEX IF NOT EXISTS contacts_account_id_idx ON contacts (account_id);
+			CREATE UNIQUE INDEX IF NOT EXISTS contacts_account_id_email_key ON contacts (account_id, lower(email)) WHERE email <> '';
+		`,
+	},
+	{
+		Version: 11,
+		Name:    "internal work centres as endpoints of kanban chains",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS work_centres (
+				id          SERIAL PRIMARY KEY,
+				code        TEXT NOT NULL UNIQUE,
+				name        TEXT NOT NULL,
+				archived_at TIMESTAMP
+			);
+			ALTER TABLE kanban_chains ALTER COLUMN cliente_id DROP NOT NULL;
+			ALTER TABLE kanban_chains ALTER COLUMN fornitore_id DROP NOT NULL;
+			ALTER TABLE kanban_chains ADD COLUMN IF NOT EXISTS customer_work_centre_id INTEGER REFERENCES work_centres (id);
+			ALTER TABLE kanban_chains ADD COLUMN IF NOT EXISTS supplier_work_centre_id INTEGER REFERENCES work_centres (id);
+			ALTER TABLE kanban_chains ADD COLUMN IF NOT EXISTS kanban_type TEXT NOT NULL DEFAULT 'withdrawal'
+				CHECK (kanban_type IN ('withdrawal', 'production'));
+			ALTER TABLE kanban_chains ADD CONSTRAINT kanban_chains_customer_check
+				CHECK ((cliente_id IS NULL) <> (customer_work_centre_id IS NULL));
+			ALTER TABLE kanban_chains ADD CONSTRAINT kanban_chains_supplier_check
+				CHECK ((fornitore_id IS NULL) <> (supplier_work_centre_id IS NULL));
+			CREATE INDEX IF NOT EXISTS kanban_chains_customer_work_centre_id_idx ON kanban_chains (customer_work_centre_id);
+			CREATE INDEX IF NOT EXISTS kanban_chains_supplier_work_centre_id_idx ON kanban_chains (supplier_work_centre_id);
+		`,
+	},
+	{
+		Version: 12,
+		Name:    "tenants, with row-level security on their data",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS tenants (
+				id         SERIAL PRIMARY KEY,
+				slug       TEXT NOT NULL UNIQUE CHECK (slug ~ '^[a-z0-9][a-z0-9-]*$'),
+				name       TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			);
+			INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'Default') ON CONFLICT DO NOTHING;
+			SELECT setval('tenants_id_seq', (SELECT MAX(id) FROM tenants));
+
+			-- The tenant the connection works for, NULL when it has none or works for every tenant
+			CREATE OR REPLACE FUNCTION current_tenant_id() RETURNS INTEGER AS $$
+				SELECT CASE WHEN current_setting('app.tenant_id', true) ~ '^[0-9]+$'
+					THEN current_setting('app.tenant_id', true)::INTEGER END
+			$$ LANGUAGE sql STABLE;
+			CREATE OR REPLACE FUNCTION tenant_visible(row_tenant_id INTEGER) RETURNS BOOLEAN AS $$
+				SELECT row_tenant_id = current_tenant_id() OR current_setting('app.tenant_id', true) = 'all'
+			$$ LANGUAGE sql STABLE;
+
+			-- The existing rows belong to the default tenant, new rows to the tenant of the connection. FORCE
+			-- applies the policies to the owner of the tables too; only superusers still bypass them.
+			DO $$
+			DECLARE
+				t TEXT;
+			BEGIN
+				FOREACH t IN ARRAY ARRAY['accounts', 'sites', 'contacts', 'work_centres', 'products', 'statuses',
+					'status_chains', 'status_chains_statuses', 'kanban_chains', 'kanban_chain_changes', 'kanbans',
+					'kanban_histories', 'audit_log', 'dashboard_views']
+				LOOP
+					EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id)', t);
+					EXECUTE format('ALTER TABLE %I ALTER COLUMN tenant_id SET DEFAULT current_tenant_id()', t);
+					EXECUTE format('CREATE INDEX IF NOT EXISTS %I ON %I (tenant_id)', t || '_tenant_id_idx', t);
+					EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+					EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+					EXECUTE format('DROP POLICY IF EXISTS tenant_isolation ON %I', t);
+					EXECUTE format('CREATE POLICY tenant_isolation ON %I USING (tenant_visible(tenant_id)) WITH CHECK (tenant_visible(tenant_id))', t);
+				END LOOP;
+			END $$;
+
+			-- Keys that were unique across the database are unique per tenant
+			DROP INDEX IF EXISTS accounts_vat_number_key;
+			CREATE UNIQUE INDEX IF NOT EXISTS accounts_tenant_id_vat_number_key
+				ON accounts (tenant_id, vat_number)
+				WHERE vat_number <> '';
+			ALTER TABLE work_centres DROP CONSTRAINT IF EXISTS work_centres_code_key;
+			ALTER TABLE work_centres ADD CONSTRAINT work_centres_tenant_id_code_key UNIQUE (tenant_id, code);
+			ALTER TABLE dashboard_views DROP CONSTRAINT IF EXISTS dashboard_views_owner_dashboard_name_key;
+			ALTER TABLE dashboard_views ADD CONSTRAINT dashboard_views_tenant_id_owner_dashboard_name_key
+				UNIQUE (tenant_id, owner, dashboard, name);
+
+			-- Product codes too, with the foreign keys to them, whatever the base schema named them
+			DO $$
+			DECLARE
+				c RECORD;
+				foreign_keys TEXT[] := '{}';
+				fk TEXT;
+			BEGIN
+				FOR c IN SELECT conrelid::regclass AS tbl, conname, pg_get_constraintdef(oid) AS def
+					FROM pg_constraint WHERE contype = 'f' AND confrelid = 'products'::regclass
+				LOOP
+					EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', c.tbl, c.conname);
+					foreign_keys := foreign_keys || format('ALTER TABLE %s ADD CONSTRAINT %I %s', c.tbl, c.conname,
+						regexp_replace(c.def, 'FOREIGN KEY \((\w+)\) REFERENCES products\(product_id\)',
+							'FOREIGN KEY (tenant_id, \1) REFERENCES products(tenant_id, product_id)'));
+				END LOOP;
+				FOR c IN SELECT conname FROM pg_constraint WHERE conrelid = 'products'::regclass
+					AND pg_get_constraintdef(oid) IN ('PRIMARY KEY (product_id)', 'UNIQUE (product_id)')
+				LOOP
+					EXECUTE format('ALTER TABLE products DROP CONSTRAINT %I', c.conname);
+				END LOOP;
+				IF EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'products'::regclass AND contype = 'p') THEN
+					ALTER TABLE products ADD CONSTRAINT products_tenant_id_product_id_key UNIQUE (tenant_id, product_id);
+				ELSE
+					ALTER TABLE products ADD CONSTRAINT products_pkey PRIMARY KEY (tenant_id, product_id);
+				END IF;
+				FOREACH fk IN ARRAY foreign_keys LOOP
+					EXECUTE fk;
+				END LOOP;
+			END $$;
+		`,
+	},
+	{
+		Version: 13,
+		Name:    "units of measure, weights, part numbers and packaging of products",
+		SQL: `
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS unit_of_measure TEXT NOT NULL DEFAULT 'pcs'
+				CHECK (unit_of_measure IN ('pcs', 'kg', 'g', 't', 'lb', 'm', 'l'));
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS net_weight_kg DOUBLE PRECISION CHECK (net_weight_kg > 0);
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS gross_weight_kg DOUBLE PRECISION CHECK (gross_weight_kg > 0);
+			ALTER TABLE products ADD CONSTRAINT products_weight_check CHECK (gross_weight_kg >= net_weight_kg);
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS customer_part_number TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS supplier_part_number TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS default_container_type TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN IF NOT EXISTS units_per_container DOUBLE PRECISION CHECK (units_per_container > 0);
+			ALTER TABLE kanban_chains ADD COLUMN IF NOT EXISTS quantity_unit TEXT NOT NULL DEFAULT 'pcs'
+				CHECK (quantity_unit IN ('pcs', 'kg', 'g', 't', 'lb', 'm', 'l'));
+		`,
+	},
+}
+
+// Migrate applies every migration that has not been recorded in schema_migrations yet. Migrations work
+// on the rows of every tenant.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	ctx = utils.WithAllTenants(ctx)
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	for _, m := range migrations {
+		var applied bool
+		err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.Version).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %d: %w", m.Version, err)
+		}
+		if applied {
+			continue
+		}
+
+		if err := applyMigration(ctx, db, m); err != nil {
+			return err
+		}
+		slog.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start migration %d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	// Schema changes on large tables may take longer than the statement timeout of the API queries
+	if _, err := tx.ExecContext(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+		return fmt.Errorf("failed to start migration %d: %w", m.Version, err)
+	}
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+	return tx.Commit()
+}
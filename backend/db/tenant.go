@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"electronic_kanban_backend/utils"
+)
+
+// The row-level security policies of the tenant tables compare tenant_id with the tenantSetting of the
+// connection. tenantConnector sets it from the context of each query, so no query can see another tenant's
+// rows: without a tenant in the context the setting is empty and the tenant tables look empty.
+const (
+	tenantSetting = "app.tenant_id"
+	allTenants    = "all" // Every tenant, see utils.WithAllTenants
+)
+
+// errTenantInTx is returned for a query whose tenant differs from the tenant its transaction began with
+var errTenantInTx = errors.New("the tenant cannot change within a transaction")
+
+// tenantSettingFor returns the value of tenantSetting for the queries run with ctx
+func tenantSettingFor(ctx context.Context) string {
+	if utils.AllTenants(ctx) {
+		return allTenants
+	}
+	if tenant, ok := utils.TenantFromContext(ctx); ok {
+		return strconv.FormatInt(tenant.ID, 10)
+	}
+	return ""
+}
+
+// pqConn is what database/sql uses of a lib/pq connection
+type pqConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+// tenantConnector opens lib/pq connections that run every query with the tenant of its context
+type tenantConnector struct {
+	driver.Connector
+}
+
+func (c tenantConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pqc, ok := conn.(pqConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported database driver connection %T", conn)
+	}
+	return &tenantConn{pqConn: pqc}, nil
+}
+
+// tenantConn is a connection that remembers its tenantSetting, so it only sets it when the tenant changes
+type tenantConn struct {
+	pqConn
+	setting string // Unset on a new connection, which is the same as empty
+	unknown bool   // Setting it failed, so it must be set again
+	inTx    bool
+}
+
+// useTenant sets tenantSetting for the tenant of ctx, unless the connection has it already
+func (c *tenantConn) useTenant(ctx context.Context) error {
+	setting := tenantSettingFor(ctx)
+	if setting == c.setting && !c.unknown {
+		return nil
+	}
+	if c.inTx {
+		return errTenantInTx
+	}
+	_, err := c.pqConn.ExecContext(ctx, `SELECT set_config('`+tenantSetting+`', $1, false)`,
+		[]driver.NamedValue{{Ordinal: 1, Value: setting}})
+	if err != nil {
+		c.unknown = true
+		return fmt.Errorf("error setting the tenant of the connection: %w", err)
+	}
+	c.setting, c.unknown = setting, false
+	return nil
+}
+
+func (c *tenantConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.useTenant(ctx); err != nil {
+		return nil, err
+	}
+	return c.pqConn.QueryContext(ctx, query, args)
+}
+
+func (c *tenantConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.useTenant(ctx); err != nil {
+		return nil, err
+	}
+	return c.pqConn.ExecContext(ctx, query, args)
+}
+
+func (c *tenantConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.pqConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := stmt.(pqStmt)
+	if !ok {
+		stmt.Close()
+		return nil, fmt.Errorf("unsupported database driver statement %T", stmt)
+	}
+	return &tenantStmt{pqStmt: s, conn: c}, nil
+}
+
+// BeginTx sets the tenant before the transaction starts, so a rollback cannot undo it
+func (c *tenantConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.useTenant(ctx); err != nil {
+		return nil, err
+	}
+	tx, err := c.pqConn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.inTx = true
+	return &tenantTx{Tx: tx, conn: c}, nil
+}
+
+// tenantTx lets its connection change tenant again once it ends
+type tenantTx struct {
+	driver.Tx
+	conn *tenantConn
+}
+
+func (tx *tenantTx) Commit() error {
+	tx.conn.inTx = false
+	return tx.Tx.Commit()
+}
+
+func (tx *tenantTx) Rollback() error {
+	tx.conn.inTx = false
+	return tx.Tx.Rollback()
+}
+
+// pqStmt is what database/sql uses of a lib/pq prepared statement
+type pqStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+}
+
+// tenantStmt runs a prepared statement with the tenant of each execution's context
+type tenantStmt struct {
+	pqStmt
+	conn *tenantConn
+}
+
+func (s *tenantStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.conn.useTenant(ctx); err != nil {
+		return nil, err
+	}
+	return s.pqStmt.QueryContext(ctx, args)
+}
+
+func (s *tenantStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.conn.useTenant(ctx); err != nil {
+		return nil, err
+	}
+	return s.pqStmt.ExecContext(ctx, args)
+}
+
+// warnIfBypassingTenants logs a warning when the database role is a superuser or has BYPASSRLS, since
+// PostgreSQL doesn't apply row-level security to it and every tenant would see the others' rows
+func warnIfBypassingTenants(ctx context.Context, db *sql.DB) {
+	var bypass bool
+	err := db.QueryRowContext(ctx, `SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`).Scan(&bypass)
+	if err != nil {
+		slog.Warn("cannot check whether the database role bypasses row-level security", "error", err)
+		return
+	}
+	if bypass {
+		slog.Warn("the database role is a superuser or has BYPASSRLS, so tenants are not isolated; connect as an ordinary role owning the tables")
+	}
+}
@@ -1,9 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -15,14 +15,28 @@ import (
 // GetAccountsHandler returns a handler for GET /api/accounts
 func GetAccountsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("GetAccountsHandler: Starting")
-		accounts, err := getAccounts(db)
+		ctx := r.Context()
+		lq, err := parseListQuery(r, accountListSpec)
 		if err != nil {
-			log.Printf("GetAccountsHandler: Error fetching accounts: %v", err)
-			http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
 			return
 		}
-		log.Println("GetAccountsHandler: Finished successfully")
+		accounts, err := getAccounts(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch accounts")
+			return
+		}
+		total, err := countRows(ctx, db, accountsFrom, lq, accountListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch accounts")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, accountsFrom, lq, accountListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch accounts")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(accounts)
 	}
@@ -31,24 +45,30 @@ func GetAccountsHandler(db *sql.DB) http.HandlerFunc {
 // CreateAccountHandler returns a handler for POST /api/accounts
 func CreateAccountHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("CreateAccountHandler: Starting")
+		ctx := r.Context()
 		var account models.Account
 		err := json.NewDecoder(r.Body).Decode(&account)
 		if err != nil {
-			log.Printf("CreateAccountHandler: Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateAccount(&account); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 
-		log.Printf("CreateAccountHandler: Creating account with data: %+v", account)
-
-		newAccount, err := createAccount(db, account)
+		var newAccount *models.Account
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if newAccount, err = createAccount(ctx, tx, account); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditCreate, "account", newAccount.ID, nil, newAccount)
+		})
 		if err != nil {
-			log.Printf("CreateAccountHandler: Failed to create account: %v", err)
-			http.Error(w, "Failed to create account", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to create account")
 			return
 		}
-		log.Printf("CreateAccountHandler: Account created successfully with data: %+v", newAccount)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -59,35 +79,25 @@ func CreateAccountHandler(db *sql.DB) http.HandlerFunc {
 // GetAccountHandler returns a handler for GET /api/accounts/{id}
 func GetAccountHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("GetAccountHandler: Starting")
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			log.Println("GetAccountHandler: Account ID is required")
-			http.Error(w, "Account ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Account ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			log.Printf("GetAccountHandler: Invalid account ID: %v", err)
-			http.Error(w, "Invalid account ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid account ID")
 			return
 		}
 
-		log.Printf("GetAccountHandler: Getting account with ID: %d", id)
-
-		account, err := getAccountByID(db, id)
+		account, err := getAccountByID(ctx, db, id)
 		if err != nil {
-			log.Printf("GetAccountHandler: Error fetching account with ID %d: %v", id, err)
-			if err == sql.ErrNoRows {
-				http.NotFound(w, r)
-			} else {
-				http.Error(w, "Failed to fetch account", http.StatusInternalServerError)
-			}
+			writeDBError(w, r, err, "Failed to fetch account")
 			return
 		}
 
-		log.Printf("GetAccountHandler: Successfully retrieved account: %+v", account)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(account)
 	}
@@ -96,163 +106,226 @@ func GetAccountHandler(db *sql.DB) http.HandlerFunc {
 // UpdateAccountHandler returns a handler for PUT/PATCH /api/accounts/{id}
 func UpdateAccountHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("UpdateAccountHandler: Starting")
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			log.Println("UpdateAccountHandler: Account ID is required")
-			http.Error(w, "Account ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Account ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			log.Printf("UpdateAccountHandler: Invalid account ID: %v", err)
-			http.Error(w, "Invalid account ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid account ID")
 			return
 		}
 
 		var accountUpdates models.Account
 		err = json.NewDecoder(r.Body).Decode(&accountUpdates)
 		if err != nil {
-			log.Printf("UpdateAccountHandler: Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateAccount(&accountUpdates); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 		accountUpdates.ID = id // Ensure ID from URL is used
-		log.Printf("UpdateAccountHandler: Updating account with data: %+v", accountUpdates)
 
-		updatedAccount, err := updateAccount(db, accountUpdates)
+		var updatedAccount *models.Account
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousAccount, err := getAccountByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if updatedAccount, err = updateAccount(ctx, tx, accountUpdates); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditUpdate, "account", id, previousAccount, updatedAccount)
+		})
 		if err != nil {
-			log.Printf("UpdateAccountHandler: Failed to update account: %v", err)
-			http.Error(w, "Failed to update account", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update account")
 			return
 		}
-		log.Printf("UpdateAccountHandler: Successfully updated account: %+v", updatedAccount)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(updatedAccount)
 	}
 }
 
-// DeleteAccountHandler returns a handler for DELETE /api/accounts/{id}
+// DeleteAccountHandler returns a handler for DELETE /api/accounts/{id}, which archives the account
 func DeleteAccountHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("DeleteAccountHandler: Starting")
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			log.Println("DeleteAccountHandler: Account ID is required")
-			http.Error(w, "Account ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Account ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			log.Printf("DeleteAccountHandler: Invalid account ID: %v", err)
-			http.Error(w, "Invalid account ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid account ID")
+			return
+		}
+
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousAccount, err := getAccountByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := archiveAccount(ctx, tx, id); err != nil {
+				return err
+			}
+			archivedAccount, err := getAccountByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditArchive, "account", id, previousAccount, archivedAccount)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to archive account")
 			return
 		}
 
-		log.Printf("DeleteAccountHandler: Deleting account with ID: %d", id)
+		w.WriteHeader(http.StatusOK) // Respond with 200 OK for successful archiving
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Account archived"})
+	}
+}
+
+// RestoreAccountHandler returns a handler for POST /api/accounts/{id}/restore
+func RestoreAccountHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid account ID")
+			return
+		}
 
-		err = deleteAccount(db, id)
+		var account *models.Account
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousAccount, err := getAccountByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if account, err = restoreAccount(ctx, tx, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditRestore, "account", id, previousAccount, account)
+		})
 		if err != nil {
-			log.Printf("DeleteAccountHandler: Failed to delete account: %v", err)
-			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to restore account")
 			return
 		}
-		log.Println("DeleteAccountHandler: Account deleted successfully")
 
-		w.WriteHeader(http.StatusOK) // Respond with 200 OK for successful deletion
-		json.NewEncoder(w).Encode(map[string]string{"message": "Account deleted"})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(account)
 	}
 }
 
 // Database interaction functions (private)
 
-func getAccounts(db *sql.DB) ([]models.Account, error) {
-	log.Println("getAccounts: Starting")
-	rows, err := db.Query("SELECT id, name, vat_number, address FROM accounts")
+// accountListSpec defines what GET /api/accounts can sort, filter and search on
+var accountListSpec = listSpec{
+	Fields: map[string]listField{
+		"id":         {Column: "id", Kind: fieldInt},
+		"name":       {Column: "name", Kind: fieldText},
+		"vat_number": {Column: "vat_number", Kind: fieldText},
+		"address":    {Column: "address", Kind: fieldText},
+	},
+	Search:      []string{"name", "vat_number", "address"},
+	DefaultSort: "name",
+	TieBreaker:  "id",
+	Archived:    "archived_at",
+}
+
+const accountsFrom = `FROM accounts WHERE TRUE`
+
+func getAccounts(ctx context.Context, db dbtx, lq *listQuery) ([]models.Account, error) {
+	var args []interface{}
+	query := "SELECT id, name, vat_number, address, archived_at " + accountsFrom +
+		lq.where(accountListSpec, &args) + lq.after(accountListSpec, &args) + lq.orderBy(accountListSpec) + lq.page()
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("getAccounts: Error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var accounts []models.Account
+	accounts := []models.Account{}
 	for rows.Next() {
 		var account models.Account
-		if err := rows.Scan(&account.ID, &account.Name, &account.VATNumber, &account.Address); err != nil {
-			log.Printf("getAccounts: Error scanning rows: %v", err)
+		if err := rows.Scan(&account.ID, &account.Name, &account.VATNumber, &account.Address, &account.ArchivedAt); err != nil {
 			return nil, err
 		}
 		accounts = append(accounts, account)
 	}
-	log.Printf("getAccounts: Successfully retrieved %d accounts", len(accounts))
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return accounts, nil
 }
 
-func createAccount(db *sql.DB, account models.Account) (*models.Account, error) {
-	log.Printf("createAccount: Starting with account data: %+v", account)
+func createAccount(ctx context.Context, db dbtx, account models.Account) (*models.Account, error) {
 	sqlStatement := `
 		INSERT INTO accounts (name, vat_number, address)
 		VALUES ($1, $2, $3)
-		RETURNING id, name, vat_number, address`
+		RETURNING id, name, vat_number, address, archived_at`
 	var newAccount models.Account
-	err := db.QueryRow(sqlStatement, account.Name, account.VATNumber, account.Address).Scan(
-		&newAccount.ID, &newAccount.Name, &newAccount.VATNumber, &newAccount.Address,
+	err := db.QueryRowContext(ctx, sqlStatement, account.Name, account.VATNumber, account.Address).Scan(
+		&newAccount.ID, &newAccount.Name, &newAccount.VATNumber, &newAccount.Address, &newAccount.ArchivedAt,
 	)
 	if err != nil {
-		log.Printf("createAccount: Error creating account: %v", err)
 		return nil, err
 	}
-	log.Printf("createAccount: Successfully created account with data: %+v", newAccount)
 
 	return &newAccount, nil
 }
 
-func getAccountByID(db *sql.DB, id int64) (*models.Account, error) {
-	log.Printf("getAccountByID: Starting with ID: %d", id)
-	sqlStatement := `SELECT id, name, vat_number, address FROM accounts WHERE id = $1`
+func getAccountByID(ctx context.Context, db dbtx, id int64) (*models.Account, error) {
+	sqlStatement := `SELECT id, name, vat_number, address, archived_at FROM accounts WHERE id = $1`
 	var account models.Account
-	err := db.QueryRow(sqlStatement, id).Scan(&account.ID, &account.Name, &account.VATNumber, &account.Address)
+	err := db.QueryRowContext(ctx, sqlStatement, id).Scan(&account.ID, &account.Name, &account.VATNumber, &account.Address, &account.ArchivedAt)
 	if err != nil {
-		log.Printf("getAccountByID: Error querying database with ID %d: %v", id, err)
 		return nil, err
 	}
-	log.Printf("getAccountByID: Successfully retrieved account: %+v", account)
 	return &account, nil
 }
 
-func updateAccount(db *sql.DB, account models.Account) (*models.Account, error) {
-	log.Printf("updateAccount: Starting with account data: %+v", account)
+func updateAccount(ctx context.Context, db dbtx, account models.Account) (*models.Account, error) {
 	sqlStatement := `
 		UPDATE accounts
 		SET name = $2, vat_number = $3, address = $4
 		WHERE id = $1
-		RETURNING id, name, vat_number, address`
+		RETURNING id, name, vat_number, address, archived_at`
 	var updatedAccount models.Account
-	err := db.QueryRow(sqlStatement, account.ID, account.Name, account.VATNumber, account.Address).Scan(
-		&updatedAccount.ID, &updatedAccount.Name, &updatedAccount.VATNumber, &updatedAccount.Address,
+	err := db.QueryRowContext(ctx, sqlStatement, account.ID, account.Name, account.VATNumber, account.Address).Scan(
+		&updatedAccount.ID, &updatedAccount.Name, &updatedAccount.VATNumber, &updatedAccount.Address, &updatedAccount.ArchivedAt,
 	)
 	if err != nil {
-		log.Printf("updateAccount: Error updating account: %v", err)
 		return nil, err
 	}
-	log.Printf("updateAccount: Successfully updated account with data: %+v", updatedAccount)
 	return &updatedAccount, nil
 }
 
-func deleteAccount(db *sql.DB, id int64) error {
-	log.Printf("deleteAccount: Starting with ID: %d", id)
-	sqlStatement := `DELETE FROM accounts WHERE id = $1`
-	_, err := db.Exec(sqlStatement, id)
+// archiveAccount archives an account, refusing while kanban chains that are not archived use it.
+// Run it in a transaction, which a refusal must roll back.
+func archiveAccount(ctx context.Context, tx dbtx, id int64) error {
+	err := archiveRow(ctx, tx, "accounts", "id", id)
+	if err == nil {
+		err = refuseIfAny(ctx, tx, "The account is used by %d kanban chains that are not archived",
+			`SELECT COUNT(*) FROM kanban_chains WHERE (cliente_id = $1 OR fornitore_id = $1) AND archived_at IS NULL`, id)
+	}
 	if err != nil {
-		log.Printf("deleteAccount: Error deleting account: %v", err)
 		return err
 	}
 
-	log.Printf("deleteAccount: Successfully deleted account")
-
 	return nil
 }
+
+func restoreAccount(ctx context.Context, db dbtx, id int64) (*models.Account, error) {
+	if err := restoreRow(ctx, db, "accounts", "id", id); err != nil {
+		return nil, err
+	}
+	return getAccountByID(ctx, db, id)
+}
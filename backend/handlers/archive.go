@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Accounts, products, statuses, status chains and kanban chains are archived instead of deleted:
+// DELETE stamps archived_at and POST .../restore clears it. Archived records keep their history and
+// references, are hidden from lists unless include_archived=true, and cannot be used by new records.
+// A record cannot be archived while records that are not archived still use it.
+
+// Database interaction functions (private)
+
+// archiveRow stamps archived_at on the row of table whose keyColumn is key. Archiving an archived
+// row keeps the time it was first archived. A missing row is sql.ErrNoRows.
+func archiveRow(ctx context.Context, db dbtx, table, keyColumn string, key interface{}) error {
+	return execAffectingRows(ctx, db, `UPDATE `+table+` SET archived_at = COALESCE(archived_at, NOW()) WHERE `+keyColumn+` = $1`, key)
+}
+
+// restoreRow clears archived_at on the row of table whose keyColumn is key. A missing row is sql.ErrNoRows.
+func restoreRow(ctx context.Context, db dbtx, table, keyColumn string, key interface{}) error {
+	return execAffectingRows(ctx, db, `UPDATE `+table+` SET archived_at = NULL WHERE `+keyColumn+` = $1`, key)
+}
+
+// refuseIfAny runs countQuery and returns a conflictError with message (formatted with the count)
+// when it counts any row
+func refuseIfAny(ctx context.Context, db dbtx, message string, countQuery string, args ...interface{}) error {
+	var count int64
+	if err := db.QueryRowContext(ctx, countQuery, args...).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return &conflictError{message: fmt.Sprintf(message, count)}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committed when fn succeeds
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
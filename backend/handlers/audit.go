@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"electronic_kanban_backend/models"
+)
+
+// ActorHeader names who makes a request, for the audit log. It is set by the authenticating proxy
+// in front of the API, or by the client; requests without it are recorded as anonymousActor.
+const ActorHeader = "X-Actor"
+
+const (
+	anonymousActor = "anonymous"
+	maxActorLength = 128
+)
+
+// Audit actions
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditArchive = "archive"
+	auditRestore = "restore"
+	auditDelete  = "delete"
+	auditMove    = "move" // A kanban moved to the next status of its chain
+	auditImport  = "import"
+)
+
+// auditLockKey is the advisory lock serialising the audit log writes of a tenant, with the tenant id as second
+// key, so that each entry chains to the last one of its tenant without holding up the other tenants
+const auditLockKey = 7402035
+
+// actor returns who makes r, as told by ActorHeader
+func actor(r *http.Request) string {
+	name := strings.TrimSpace(r.Header.Get(ActorHeader))
+	if name == "" || len(name) > maxActorLength {
+		return anonymousActor
+	}
+	return name
+}
+
+// auditQueryParams are the query parameters of GET /api/audit on top of the list parameters
+var auditQueryParams = []apiParam{
+	{"from", "string", "Only entries at or after this RFC 3339 time"},
+	{"to", "string", "Only entries before this RFC 3339 time"},
+}
+
+// GetAuditLogHandler returns a handler for GET /api/audit
+func GetAuditLogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		lq, err := parseListQuery(r, auditListSpec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		var period auditPeriod
+		for name, target := range map[string]*time.Time{"from": &period.From, "to": &period.To} {
+			value := r.URL.Query().Get(name)
+			if value == "" {
+				continue
+			}
+			if *target, err = time.Parse(time.RFC3339, value); err != nil {
+				writeError(w, r, http.StatusBadRequest, codeInvalidRequest, name+" must be an RFC 3339 time like 2024-05-01T00:00:00Z")
+				return
+			}
+		}
+
+		entries, err := getAuditEntries(ctx, db, lq, period)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch the audit log")
+			return
+		}
+		total, err := countAuditEntries(ctx, db, lq, period)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch the audit log")
+			return
+		}
+		cursor, err := nextAuditCursor(ctx, db, lq, period)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch the audit log")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// VerifyAuditLogHandler returns a handler for GET /api/audit/verify
+func VerifyAuditLogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		verification, err := verifyAuditChain(ctx, db)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to verify the audit log")
+			return
+		}
+		if !verification.Valid {
+			requestLogger(r).Warn("audit log verification failed", "broken_at_id", *verification.BrokenAtID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(verification)
+	}
+}
+
+// Database interaction functions (private)
+
+// recordAudit appends an entry for a write operation on entity to the audit log, inside the transaction
+// of the operation so that the entry exists if and only if the change does. before is nil on create.
+// It also announces the change on the push channel, when the transaction commits.
+func recordAudit(tx *sql.Tx, r *http.Request, action, entity string, entityID interface{}, before, after interface{}) error {
+	ctx := r.Context()
+	entry := models.AuditEntry{
+		OccurredAt: time.Now().UTC().Truncate(time.Microsecond), // The precision of a PostgreSQL timestamp
+		Actor:      actor(r),
+		RequestID:  requestID(r),
+		Action:     action,
+		Entity:     entity,
+		EntityID:   fmt.Sprint(entityID),
+	}
+	var err error
+	if entry.Before, err = auditValue(before); err != nil {
+		return fmt.Errorf("recordAudit: error encoding %s %v: %w", entity, entityID, err)
+	}
+	if entry.After, err = auditValue(after); err != nil {
+		return fmt.Errorf("recordAudit: error encoding %s %v: %w", entity, entityID, err)
+	}
+	entry.Changes = auditChanges(entry.Before, entry.After)
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, COALESCE(current_tenant_id(), 0))`, auditLockKey); err != nil {
+		return fmt.Errorf("recordAudit: error locking the audit log: %w", err)
+	}
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&entry.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("recordAudit: error reading the last audit entry: %w", err)
+	}
+	if entry.Hash, err = auditHash(entry); err != nil {
+		return fmt.Errorf("recordAudit: error hashing audit entry: %w", err)
+	}
+
+	beforeJSON, afterJSON, changesJSON, err := auditJSONColumns(entry)
+	if err != nil {
+		return fmt.Errorf("recordAudit: error encoding audit entry: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO audit_log (occurred_at, actor, request_id, action, entity, entity_id, before, after, changes, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		entry.OccurredAt, entry.Actor, entry.RequestID, entry.Action, entry.Entity, entry.EntityID,
+		beforeJSON, afterJSON, changesJSON, entry.PrevHash, entry.Hash,
+	)
+	if err != nil {
+		return fmt.Errorf("recordAudit: error writing audit entry: %w", err)
+	}
+	return notifyChange(ctx, tx, models.ChangeEvent{Action: action, Entity: entity, EntityID: entry.EntityID})
+}
+
+// auditValue converts a record to its generic JSON form (maps, slices, float64...), which is what
+// the JSONB columns give back, so that an entry hashes the same when written and when verified
+func auditValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(b, &generic)
+	return generic, err
+}
+
+// auditChanges lists the fields that differ between two records, when both are JSON objects
+func auditChanges(before, after interface{}) map[string]models.AuditChange {
+	beforeFields, _ := before.(map[string]interface{})
+	afterFields, _ := after.(map[string]interface{})
+	changes := map[string]models.AuditChange{}
+	for name, to := range afterFields {
+		if from, ok := beforeFields[name]; !ok || !reflect.DeepEqual(from, to) {
+			changes[name] = models.AuditChange{From: beforeFields[name], To: to}
+		}
+	}
+	for name, from := range beforeFields {
+		if _, ok := afterFields[name]; !ok {
+			changes[name] = models.AuditChange{From: from, To: nil}
+		}
+	}
+	return changes
+}
+
+// auditHash returns the hash of an entry: SHA-256 of the previous entry's hash and of the entry's
+// content, encoded as JSON with fields in a fixed order and object keys sorted
+func auditHash(entry models.AuditEntry) (string, error) {
+	content, err := json.Marshal(struct {
+		PrevHash   string                        `json:"prev_hash"`
+		OccurredAt string                        `json:"occurred_at"`
+		Actor      string                        `json:"actor"`
+		RequestID  string                        `json:"request_id"`
+		Action     string                        `json:"action"`
+		Entity     string                        `json:"entity"`
+		EntityID   string                        `json:"entity_id"`
+		Before     interface{}                   `json:"before"`
+		After      interface{}                   `json:"after"`
+		Changes    map[string]models.AuditChange `json:"changes"`
+	}{
+		entry.PrevHash, entry.OccurredAt.UTC().Format(time.RFC3339Nano), entry.Actor, entry.RequestID,
+		entry.Action, entry.Entity, entry.EntityID, entry.Before, entry.After, entry.Changes,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditJSONColumns encodes the JSONB columns of an entry, NULL for a nil record
+func auditJSONColumns(entry models.AuditEntry) (before, after, changes []byte, err error) {
+	if entry.Before != nil {
+		if before, err = json.Marshal(entry.Before); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if entry.After != nil {
+		if after, err = json.Marshal(entry.After); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	changes, err = json.Marshal(entry.Changes)
+	return before, after, changes, err
+}
+
+// auditListSpec defines what GET /api/audit can sort, filter and search on
+var auditListSpec = listSpec{
+	Fields: map[string]listField{
+		"id":         {Column: "id", Kind: fieldInt},
+		"actor":      {Column: "actor", Kind: fieldText},
+		"request_id": {Column: "request_id", Kind: fieldText},
+		"action":     {Column: "action", Kind: fieldText},
+		"entity":     {Column: "entity", Kind: fieldText},
+		"entity_id":  {Column: "entity_id", Kind: fieldText},
+	},
+	Search:      []string{"actor", "entity_id", "request_id"},
+	DefaultSort: "-id",
+	TieBreaker:  "id",
+}
+
+const auditFrom = `FROM audit_log WHERE TRUE`
+
+// auditPeriod restricts the audit log to entries at or after From and before To, when set
+type auditPeriod struct {
+	From time.Time
+	To   time.Time
+}
+
+// where returns the list conditions of lq and the period as " AND ...", appending their parameters to args
+func (p auditPeriod) where(lq *listQuery, args *[]interface{}) string {
+	conditions := lq.where(auditListSpec, args)
+	if !p.From.IsZero() {
+		*args = append(*args, p.From.UTC())
+		conditions += fmt.Sprintf(" AND occurred_at >= $%d", len(*args))
+	}
+	if !p.To.IsZero() {
+		*args = append(*args, p.To.UTC())
+		conditions += fmt.Sprintf(" AND occurred_at < $%d", len(*args))
+	}
+	return conditions
+}
+
+const auditColumns = `id, occurred_at, actor, request_id, action, entity, entity_id, before, after, changes, prev_hash, hash`
+
+func getAuditEntries(ctx context.Context, db dbtx, lq *listQuery, period auditPeriod) ([]models.AuditEntry, error) {
+	var args []interface{}
+	rows, err := db.QueryContext(ctx, `SELECT `+auditColumns+` `+auditFrom+
+		period.where(lq, &args)+lq.after(auditListSpec, &args)+lq.orderBy(auditListSpec)+lq.page(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+// nextAuditCursor returns the cursor of the audit log page after the one lq selects, or "" on the last page
+func nextAuditCursor(ctx context.Context, db dbtx, lq *listQuery, period auditPeriod) (string, error) {
+	var args []interface{}
+	return lq.cursorAfterPage(ctx, db, auditListSpec, auditFrom+period.where(lq, &args), args)
+}
+
+func countAuditEntries(ctx context.Context, db dbtx, lq *listQuery, period auditPeriod) (int64, error) {
+	var args []interface{}
+	var total int64
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) `+auditFrom+period.where(lq, &args), args...).Scan(&total)
+	return total, err
+}
+
+func scanAuditEntry(rows *sql.Rows) (*models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var before, after, changes []byte
+	err := rows.Scan(
+		&entry.ID, &entry.OccurredAt, &entry.Actor, &entry.RequestID, &entry.Action, &entry.Entity, &entry.EntityID,
+		&before, &after, &changes, &entry.PrevHash, &entry.Hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entry.OccurredAt = entry.OccurredAt.UTC()
+	for _, column := range []struct {
+		raw    []byte
+		target interface{}
+	}{{before, &entry.Before}, {after, &entry.After}, {changes, &entry.Changes}} {
+		if column.raw == nil {
+			continue
+		}
+		if err := json.Unmarshal(column.raw, column.target); err != nil {
+			return nil, fmt.Errorf("scanAuditEntry: error decoding audit entry %d: %w", entry.ID, err)
+		}
+	}
+	return &entry, nil
+}
+
+// verifyAuditChain recomputes the hash of every audit entry, oldest first, and reports the first one
+// that doesn't match its content or doesn't chain to the entry before it
+func verifyAuditChain(ctx context.Context, db *sql.DB) (*models.AuditVerification, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verification := &models.AuditVerification{Valid: true}
+	prevHash := ""
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		expected, err := auditHash(models.AuditEntry{
+			PrevHash: prevHash, OccurredAt: entry.OccurredAt, Actor: entry.Actor, RequestID: entry.RequestID,
+			Action: entry.Action, Entity: entry.Entity, EntityID: entry.EntityID, Before: entry.Before, After: entry.After,
+			Changes: entry.Changes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if entry.PrevHash != prevHash || entry.Hash != expected {
+			verification.Valid = false
+			verification.BrokenAtID = &entry.ID
+			verification.Message = fmt.Sprintf("Audit entry %d does not match its hash: it or an earlier entry was altered or removed", entry.ID)
+			return verification, nil
+		}
+		prevHash = entry.Hash
+		verification.Checked++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	verification.Message = fmt.Sprintf("All %d audit entries match their hashes", verification.Checked)
+	return verification, nil
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"electronic_kanban_backend/models"
+	"electronic_kanban_backend/utils"
+
+	"github.com/lib/pq"
+)
+
+// changeChannel is the PostgreSQL notification channel of record changes. recordAudit notifies it in the
+// transaction of the change, so every instance hears about every committed write, whichever served it.
+const changeChannel = "electronic_kanban_changes"
+
+// changeResync is the action of the event sent when notifications may have been missed
+const changeResync = "resync"
+
+const (
+	changeListenerMinReconnect = 10 * time.Second
+	changeListenerMaxReconnect = time.Minute
+	changeListenerPing         = 90 * time.Second // Checks the listening connection while nothing happens
+	changeSubscriberBuffer     = 16
+	changeStreamHeartbeat      = 25 * time.Second // Keeps proxies from closing idle streams
+	changeStreamRetry          = 5 * time.Second  // How long browsers wait before reconnecting a dropped stream
+)
+
+// changeNotification is the payload of changeChannel: an event and the tenant whose clients it concerns
+type changeNotification struct {
+	TenantID int64 `json:"tenant_id"`
+	models.ChangeEvent
+}
+
+// notifyChange announces a committed change of entity on changeChannel, to the clients of the tenant of ctx;
+// run it in the transaction of the change
+func notifyChange(ctx context.Context, tx dbtx, event models.ChangeEvent) error {
+	tenant, _ := utils.TenantFromContext(ctx)
+	payload, err := json.Marshal(changeNotification{TenantID: tenant.ID, ChangeEvent: event})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, changeChannel, string(payload)); err != nil {
+		return fmt.Errorf("error notifying change of %s %s: %w", event.Entity, event.EntityID, err)
+	}
+	return nil
+}
+
+// ChangeHub is the push channel: it listens to changeChannel and fans the events out to the subscribed streams
+// of their tenant
+type ChangeHub struct {
+	mu          sync.Mutex
+	subscribers map[chan models.ChangeEvent]int64 // The tenant of each subscriber
+	closed      bool
+}
+
+// NewChangeHub returns a hub without subscribers; Run feeds it
+func NewChangeHub() *ChangeHub {
+	return &ChangeHub{subscribers: map[chan models.ChangeEvent]int64{}}
+}
+
+// Subscribe returns a channel of the events of tenantID to come and the function that stops them. The channel is
+// closed when the hub stops. A subscriber that doesn't keep up loses events, so events only ask for a refresh.
+func (h *ChangeHub) Subscribe(tenantID int64) (<-chan models.ChangeEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := make(chan models.ChangeEvent, changeSubscriberBuffer)
+	if h.closed {
+		close(events)
+		return events, func() {}
+	}
+	h.subscribers[events] = tenantID
+	return events, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[events]; ok {
+			delete(h.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// publish sends event to the subscribers of tenantID, or to every subscriber when tenantID is 0
+func (h *ChangeHub) publish(tenantID int64, event models.ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events, subscriberTenantID := range h.subscribers {
+		if tenantID != 0 && tenantID != subscriberTenantID {
+			continue
+		}
+		select {
+		case events <- event:
+		default: // The subscriber has refreshes pending already
+		}
+	}
+}
+
+// close ends every subscription
+func (h *ChangeHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for events := range h.subscribers {
+		delete(h.subscribers, events)
+		close(events)
+	}
+}
+
+// Run listens to changeChannel on a connection of its own until ctx is cancelled, then ends the subscriptions,
+// so the streams return and the server can shut down. Reconnections are announced as a resync event.
+func (h *ChangeHub) Run(ctx context.Context, connectionString string) {
+	defer h.close()
+
+	listener := pq.NewListener(connectionString, changeListenerMinReconnect, changeListenerMaxReconnect,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				slog.Warn("change listener connection problem", "error", err)
+			}
+		})
+	defer listener.Close()
+	if err := listener.Listen(changeChannel); err != nil {
+		slog.Error("listening to database changes failed, pushed updates are off", "error", err)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-listener.Notify:
+			if notification == nil { // Reconnected: what happened meanwhile is lost
+				h.publish(0, models.ChangeEvent{Action: changeResync})
+				continue
+			}
+			var change changeNotification
+			if err := json.Unmarshal([]byte(notification.Extra), &change); err != nil || change.TenantID == 0 {
+				slog.Warn("ignoring malformed change notification", "payload", notification.Extra, "error", err)
+				continue
+			}
+			h.publish(change.TenantID, change.ChangeEvent)
+		case <-time.After(changeListenerPing):
+			go listener.Ping()
+		}
+	}
+}
+
+// streamChanges sends the events of hub for the tenant of r to the client as Server-Sent Events until the client
+// leaves or the hub stops
+func streamChanges(w http.ResponseWriter, r *http.Request, hub *ChangeHub) {
+	events, unsubscribe := hub.Subscribe(tenantFromRequest(r).ID)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil { // The stream outlives the server's write timeout
+		requestLogger(r).Warn("cannot lift the write deadline of the change stream", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Don't let nginx buffer the stream
+	fmt.Fprintf(w, "retry: %d\n\n", changeStreamRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		requestLogger(r).Error("change stream cannot be flushed", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(changeStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,993 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"electronic_kanban_backend/models"
+)
+
+// Conflict policies for POST /api/config/import?on_conflict=
+const (
+	onConflictFail      = "fail"      // Report conflicts and import nothing (default)
+	onConflictSkip      = "skip"      // Keep the target's version of conflicting records
+	onConflictOverwrite = "overwrite" // Replace conflicting records with the bundle's version
+)
+
+// configConflict is a record that exists in both the bundle and the target with different values
+type configConflict struct {
+	Entity   string      `json:"entity"`
+	Ref      string      `json:"ref"`
+	Field    string      `json:"field"`
+	Existing interface{} `json:"existing"`
+	Incoming interface{} `json:"incoming"`
+}
+
+// configImportError is a bundle record that cannot be imported at all
+type configImportError struct {
+	Entity  string `json:"entity"`
+	Ref     string `json:"ref"`
+	Message string `json:"message"`
+}
+
+// configImportReport is the response body of POST /api/config/import
+type configImportReport struct {
+	DryRun     bool                `json:"dry_run"`
+	Applied    bool                `json:"applied"`
+	OnConflict string              `json:"on_conflict"`
+	Created    map[string]int      `json:"created"`
+	Updated    map[string]int      `json:"updated"`
+	Unchanged  map[string]int      `json:"unchanged"`
+	Skipped    map[string]int      `json:"skipped"`
+	Conflicts  []configConflict    `json:"conflicts"`
+	Errors     []configImportError `json:"errors"`
+}
+
+// bundleRefError is returned when the source data can't be expressed with stable references
+type bundleRefError struct {
+	Entity string
+	Ref    string
+}
+
+func (e *bundleRefError) Error() string {
+	return fmt.Sprintf("%s %q is not unique, rename the duplicates before exporting", e.Entity, e.Ref)
+}
+
+// ExportConfigHandler returns a handler for GET /api/config/export
+func ExportConfigHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		bundle, err := buildConfigBundle(ctx, db)
+		if err != nil {
+			if refErr, ok := err.(*bundleRefError); ok {
+				writeError(w, r, http.StatusConflict, codeConflict, refErr.Error())
+			} else {
+				writeDBError(w, r, err, "Failed to export configuration")
+			}
+			return
+		}
+
+		filename := fmt.Sprintf("kanban-config-%s.json", bundle.ExportedAt.Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(bundle)
+	}
+}
+
+// ImportConfigHandler returns a handler for POST /api/config/import
+func ImportConfigHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		onConflict := r.URL.Query().Get("on_conflict")
+		switch onConflict {
+		case "":
+			onConflict = onConflictFail
+		case onConflictFail, onConflictSkip, onConflictOverwrite:
+		default:
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid on_conflict, use fail, skip or overwrite")
+			return
+		}
+
+		var bundle models.ConfigBundle
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if bundle.FormatVersion < 1 || bundle.FormatVersion > models.ConfigBundleFormatVersion {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Unsupported bundle format_version %d, this server reads versions 1 to %d",
+				bundle.FormatVersion, models.ConfigBundleFormatVersion))
+			return
+		}
+
+		report, err := importConfigBundle(db, r, bundle, onConflict, dryRun)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to import configuration")
+			return
+		}
+		requestLogger(r).Info("configuration import",
+			"dry_run", report.DryRun, "applied", report.Applied, "conflicts", len(report.Conflicts), "errors", len(report.Errors))
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case len(report.Errors) > 0:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		case len(report.Conflicts) > 0 && onConflict == onConflictFail:
+			w.WriteHeader(http.StatusConflict)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// Database interaction functions (private)
+
+// accountRef is the stable reference of an account inside a configuration bundle
+func accountRef(name string, vatNumber string) string {
+	if vatNumber != "" {
+		return vatNumber
+	}
+	return "name:" + name
+}
+
+// buildConfigBundle reads the configuration to export, leaving archived records out
+func buildConfigBundle(ctx context.Context, db *sql.DB) (*models.ConfigBundle, error) {
+	bundle := &models.ConfigBundle{
+		FormatVersion: models.ConfigBundleFormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		Statuses:      []models.BundleStatus{},
+		StatusChains:  []models.BundleStatusChain{},
+		Accounts:      []models.BundleAccount{},
+		WorkCentres:   []models.BundleWorkCentre{},
+		Products:      []models.Product{},
+		KanbanChains:  []models.BundleKanbanChain{},
+	}
+
+	statuses, err := getStatuses(ctx, db, &listQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error fetching statuses: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, status := range statuses {
+		if seen[status.Name] {
+			return nil, &bundleRefError{Entity: "status", Ref: status.Name}
+		}
+		seen[status.Name] = true
+		bundle.Statuses = append(bundle.Statuses, models.BundleStatus{Name: status.Name, Color: status.Color})
+	}
+
+	statusChains, err := getStatusChains(ctx, db, &listQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error fetching status chains: %w", err)
+	}
+	seen = make(map[string]bool)
+	for _, statusChain := range statusChains {
+		if seen[statusChain.Name] {
+			return nil, &bundleRefError{Entity: "status chain", Ref: statusChain.Name}
+		}
+		seen[statusChain.Name] = true
+		links, err := bundleStatusChainStatuses(ctx, db, statusChain.StatusChainID)
+		if err != nil {
+			return nil, err
+		}
+		bundle.StatusChains = append(bundle.StatusChains, models.BundleStatusChain{Name: statusChain.Name, Statuses: links})
+	}
+
+	accounts, err := getAccounts(ctx, db, &listQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error fetching accounts: %w", err)
+	}
+	sites, err := getSites(ctx, db, &listQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error fetching sites: %w", err)
+	}
+	accountSites := make(map[int64][]models.BundleSite)
+	for _, site := range sites {
+		accountSites[site.AccountID] = append(accountSites[site.AccountID], models.BundleSite{Name: site.Name, Address: site.Address})
+	}
+	seen = make(map[string]bool)
+	for _, account := range accounts {
+		ref := accountRef(account.Name, account.VATNumber)
+		if seen[ref] {
+			return nil, &bundleRefError{Entity: "account", Ref: ref}
+		}
+		seen[ref] = true
+		bundle.Accounts = append(bundle.Accounts, models.BundleAccount{
+			Ref: ref, Name: account.Name, VATNumber: account.VATNumber, Address: account.Address, Sites: accountSites[account.ID],
+		})
+	}
+
+	workCentres, err := getWorkCentres(ctx, db, &listQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error fetching work centres: %w", err)
+	}
+	for _, workCentre := range workCentres {
+		bundle.WorkCentres = append(bundle.WorkCentres, models.BundleWorkCentre{Code: workCentre.Code, Name: workCentre.Name})
+	}
+
+	products, err := getProducts(ctx, db, &listQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error fetching products: %w", err)
+	}
+	bundle.Products = append(bundle.Products, products...)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			COALESCE(c.name, ''), COALESCE(c.vat_number, ''), COALESCE(cw.code, ''), COALESCE(cs.name, ''), kc.prodotto_codice,
+			COALESCE(s.name, ''), COALESCE(s.vat_number, ''), COALESCE(sw.code, ''), COALESCE(ss.name, ''), sc.name,
+			kc.kanban_type, kc.leadtime_days, kc.quantity, kc.quantity_unit, kc.tipo_contenitore, kc.no_of_active_kanbans
+		FROM kanban_chains kc
+		LEFT JOIN accounts c ON kc.cliente_id = c.id
+		LEFT JOIN accounts s ON kc.fornitore_id = s.id
+		LEFT JOIN work_centres cw ON kc.customer_work_centre_id = cw.id
+		LEFT JOIN work_centres sw ON kc.supplier_work_centre_id = sw.id
+		JOIN status_chains sc ON kc.status_chain_id = sc.status_chain_id
+		LEFT JOIN sites cs ON kc.customer_site_id = cs.id
+		LEFT JOIN sites ss ON kc.supplier_site_id = ss.id
+		WHERE kc.archived_at IS NULL
+		ORDER BY kc.id`)
+	if err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error querying kanban chains: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kc models.BundleKanbanChain
+		var customerName, customerVAT, supplierName, supplierVAT string
+		if err := rows.Scan(
+			&customerName, &customerVAT, &kc.CustomerWorkCentre, &kc.CustomerSite, &kc.ProductID,
+			&supplierName, &supplierVAT, &kc.SupplierWorkCentre, &kc.SupplierSite, &kc.StatusChain,
+			&kc.KanbanType, &kc.LeadtimeDays, &kc.Quantity, &kc.QuantityUnit, &kc.TipoContenitore, &kc.NoOfActiveKanbans,
+		); err != nil {
+			return nil, fmt.Errorf("buildConfigBundle: error scanning kanban chain: %w", err)
+		}
+		if kc.CustomerWorkCentre == "" {
+			kc.Customer = accountRef(customerName, customerVAT)
+		}
+		if kc.SupplierWorkCentre == "" {
+			kc.Supplier = accountRef(supplierName, supplierVAT)
+		}
+		bundle.KanbanChains = append(bundle.KanbanChains, kc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("buildConfigBundle: error iterating kanban chains: %w", err)
+	}
+
+	return bundle, nil
+}
+
+// bundleStatusChainStatuses returns the statuses of a status chain referenced by status name
+func bundleStatusChainStatuses(ctx context.Context, q dbtx, statusChainID int64) ([]models.BundleStatusChainStatus, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT s.name, scs."order", scs.customer_supplier, COALESCE(scs.stage, '')
+		FROM status_chains_statuses scs
+		JOIN statuses s ON scs.status_id = s.status_id
+		WHERE scs.status_chain_id = $1
+		ORDER BY scs."order" ASC`, statusChainID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying statuses of status chain %d: %w", statusChainID, err)
+	}
+	defer rows.Close()
+
+	links := []models.BundleStatusChainStatus{}
+	for rows.Next() {
+		var link models.BundleStatusChainStatus
+		if err := rows.Scan(&link.Status, &link.Order, &link.CustomerSupplier, &link.Stage); err != nil {
+			return nil, fmt.Errorf("error scanning statuses of status chain %d: %w", statusChainID, err)
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// configImporter applies a bundle inside a single transaction, resolving references
+// against both the bundle and the records already in the target database.
+type configImporter struct {
+	ctx        context.Context // Of the import request, for every query of the import
+	tx         *sql.Tx
+	onConflict string
+	report     *configImportReport
+
+	statusIDs      map[string]int64            // status name -> status_id
+	statusChainIDs map[string]int64            // status chain name -> status_chain_id
+	accountIDs     map[string]int64            // account ref -> id
+	siteIDs        map[string]map[string]int64 // account ref -> site name -> id, of the sites that are not archived
+	workCentreIDs  map[string]int64            // work centre code -> id, of the work centres that are not archived
+	products       map[string]models.Product   // product_id -> product, as imported
+	matchSites     bool                        // The bundle has sites, which then identify kanban chains
+	productDetails bool                        // The bundle has the units, weights and packaging of products
+}
+
+func importConfigBundle(db *sql.DB, r *http.Request, bundle models.ConfigBundle, onConflict string, dryRun bool) (*configImportReport, error) {
+	ctx := r.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("importConfigBundle: error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	im := &configImporter{
+		ctx:        ctx,
+		tx:         tx,
+		onConflict: onConflict,
+		report: &configImportReport{
+			DryRun:     dryRun,
+			OnConflict: onConflict,
+			Created:    map[string]int{},
+			Updated:    map[string]int{},
+			Unchanged:  map[string]int{},
+			Skipped:    map[string]int{},
+			Conflicts:  []configConflict{},
+			Errors:     []configImportError{},
+		},
+		statusIDs:      map[string]int64{},
+		statusChainIDs: map[string]int64{},
+		accountIDs:     map[string]int64{},
+		siteIDs:        map[string]map[string]int64{},
+		workCentreIDs:  map[string]int64{},
+		products:       map[string]models.Product{},
+		matchSites:     bundle.FormatVersion >= 2,
+		productDetails: bundle.FormatVersion >= 4,
+	}
+
+	// Dependencies first, so every reference is resolvable when it's needed
+	steps := []func(models.ConfigBundle) error{
+		im.importStatuses,
+		im.importStatusChains,
+		im.importAccounts,
+		im.importSites,
+		im.importWorkCentres,
+		im.importProducts,
+		im.importKanbanChains,
+	}
+	for _, step := range steps {
+		if err := step(bundle); err != nil {
+			return nil, err
+		}
+	}
+
+	report := im.report
+	blocked := len(report.Errors) > 0 || (len(report.Conflicts) > 0 && onConflict == onConflictFail)
+	if dryRun || blocked {
+		return report, nil // Deferred rollback discards the changes
+	}
+	report.Applied = true
+	if err := recordAudit(tx, r, auditImport, "config", "", nil, report); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("importConfigBundle: error committing transaction: %w", err)
+	}
+	return report, nil
+}
+
+func (im *configImporter) addError(entity, ref, format string, args ...interface{}) {
+	im.report.Errors = append(im.report.Errors, configImportError{Entity: entity, Ref: ref, Message: fmt.Sprintf(format, args...)})
+}
+
+// addFieldErrors reports the errors of a validator and tells whether there were any
+func (im *configImporter) addFieldErrors(entity, ref string, fieldErrs []models.FieldError) bool {
+	for _, e := range fieldErrs {
+		im.addError(entity, ref, "%s", e.Message)
+	}
+	return len(fieldErrs) > 0
+}
+
+// resolveConflicts compares existing and incoming field values and records the differences.
+// It returns true when the caller should overwrite the existing record.
+func (im *configImporter) resolveConflicts(entity, ref string, fields []string, existing, incoming []interface{}) (overwrite bool) {
+	var conflicts []configConflict
+	for i, field := range fields {
+		if !reflect.DeepEqual(existing[i], incoming[i]) {
+			conflicts = append(conflicts, configConflict{Entity: entity, Ref: ref, Field: field, Existing: existing[i], Incoming: incoming[i]})
+		}
+	}
+	if len(conflicts) == 0 {
+		im.report.Unchanged[entity]++
+		return false
+	}
+	im.report.Conflicts = append(im.report.Conflicts, conflicts...)
+	switch im.onConflict {
+	case onConflictOverwrite:
+		im.report.Updated[entity]++
+		return true
+	case onConflictSkip:
+		im.report.Skipped[entity]++
+	}
+	return false
+}
+
+func (im *configImporter) importStatuses(bundle models.ConfigBundle) error {
+	type existingStatus struct {
+		id    int64
+		color string
+	}
+	existing := map[string]existingStatus{}
+	duplicates := map[string]bool{}
+	rows, err := im.tx.QueryContext(im.ctx, `SELECT status_id, name, color FROM statuses`)
+	if err != nil {
+		return fmt.Errorf("importStatuses: error querying statuses: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name, color string
+		if err := rows.Scan(&id, &name, &color); err != nil {
+			return fmt.Errorf("importStatuses: error scanning status: %w", err)
+		}
+		if _, ok := existing[name]; ok {
+			duplicates[name] = true
+		}
+		existing[name] = existingStatus{id: id, color: color}
+		im.statusIDs[name] = id
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("importStatuses: error iterating statuses: %w", err)
+	}
+
+	for _, bundleStatus := range bundle.Statuses {
+		status := models.Status{Name: bundleStatus.Name, Color: bundleStatus.Color}
+		fieldErrs := validateStatus(&status) // Normalises the status, which is stored and compared as validated
+		if status.Name == "" {
+			im.addError("status", "", "status name is required")
+			continue
+		}
+		if im.addFieldErrors("status", status.Name, fieldErrs) {
+			continue
+		}
+		if duplicates[status.Name] {
+			im.addError("status", status.Name, "several statuses named %q exist in the target, rename them first", status.Name)
+			continue
+		}
+		current, ok := existing[status.Name]
+		if !ok {
+			var id int64
+			err := im.tx.QueryRowContext(im.ctx, `INSERT INTO statuses (name, color) VALUES ($1, $2) RETURNING status_id`, status.Name, status.Color).Scan(&id)
+			if err != nil {
+				return fmt.Errorf("importStatuses: error inserting status %q: %w", status.Name, err)
+			}
+			im.statusIDs[status.Name] = id
+			existing[status.Name] = existingStatus{id: id, color: status.Color}
+			im.report.Created["statuses"]++
+			continue
+		}
+		if im.resolveConflicts("statuses", status.Name, []string{"color"}, []interface{}{current.color}, []interface{}{status.Color}) {
+			if _, err := im.tx.ExecContext(im.ctx, `UPDATE statuses SET color = $2 WHERE status_id = $1`, current.id, status.Color); err != nil {
+				return fmt.Errorf("importStatuses: error updating status %q: %w", status.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (im *configImporter) importStatusChains(bundle models.ConfigBundle) error {
+	duplicates := map[string]bool{}
+	rows, err := im.tx.QueryContext(im.ctx, `SELECT status_chain_id, name FROM status_chains`)
+	if err != nil {
+		return fmt.Errorf("importStatusChains: error querying status chains: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return fmt.Errorf("importStatusChains: error scanning status chain: %w", err)
+		}
+		if _, ok := im.statusChainIDs[name]; ok {
+			duplicates[name] = true
+		}
+		im.statusChainIDs[name] = id
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("importStatusChains: error iterating status chains: %w", err)
+	}
+	rows.Close()
+
+	for _, statusChain := range bundle.StatusChains {
+		if statusChain.Name == "" {
+			im.addError("status_chains", "", "status chain name is required")
+			continue
+		}
+		if duplicates[statusChain.Name] {
+			im.addError("status_chains", statusChain.Name, "several status chains named %q exist in the target, rename them first", statusChain.Name)
+			continue
+		}
+		resolved := true
+		for _, link := range statusChain.Statuses {
+			if _, ok := im.statusIDs[link.Status]; !ok {
+				im.addError("status_chains", statusChain.Name, "unknown status %q", link.Status)
+				resolved = false
+			}
+			if link.Stage != "" && !validStage(link.Stage) {
+				im.addError("status_chains", statusChain.Name, "status %q has an unknown stage %q", link.Status, link.Stage)
+				resolved = false
+			}
+		}
+		if !resolved {
+			continue
+		}
+
+		id, ok := im.statusChainIDs[statusChain.Name]
+		if !ok {
+			err := im.tx.QueryRowContext(im.ctx, `INSERT INTO status_chains (name) VALUES ($1) RETURNING status_chain_id`, statusChain.Name).Scan(&id)
+			if err != nil {
+				return fmt.Errorf("importStatusChains: error inserting status chain %q: %w", statusChain.Name, err)
+			}
+			im.statusChainIDs[statusChain.Name] = id
+			if err := im.insertStatusChainLinks(id, statusChain.Statuses); err != nil {
+				return err
+			}
+			im.report.Created["status_chains"]++
+			continue
+		}
+
+		current, err := bundleStatusChainStatuses(im.ctx, im.tx, id)
+		if err != nil {
+			return fmt.Errorf("importStatusChains: %w", err)
+		}
+		incoming := statusChain.Statuses
+		if incoming == nil {
+			incoming = []models.BundleStatusChainStatus{}
+		}
+		if im.resolveConflicts("status_chains", statusChain.Name, []string{"statuses"}, []interface{}{current}, []interface{}{incoming}) {
+			if _, err := im.tx.ExecContext(im.ctx, `DELETE FROM status_chains_statuses WHERE status_chain_id = $1`, id); err != nil {
+				return fmt.Errorf("importStatusChains: error clearing statuses of %q: %w", statusChain.Name, err)
+			}
+			if err := im.insertStatusChainLinks(id, incoming); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (im *configImporter) insertStatusChainLinks(statusChainID int64, links []models.BundleStatusChainStatus) error {
+	for _, link := range links {
+		_, err := im.tx.ExecContext(im.ctx, `
+			INSERT INTO status_chains_statuses (status_chain_id, status_id, "order", customer_supplier, stage)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''))`, statusChainID, im.statusIDs[link.Status], link.Order, link.CustomerSupplier, link.Stage)
+		if err != nil {
+			return fmt.Errorf("error linking status %q to status chain %d: %w", link.Status, statusChainID, err)
+		}
+	}
+	return nil
+}
+
+func (im *configImporter) importAccounts(bundle models.ConfigBundle) error {
+	existing := map[string]models.Account{}
+	duplicates := map[string]bool{}
+	// Archived accounts are matched too, so that importing doesn't duplicate them; they stay archived
+	accounts, err := getAccounts(im.ctx, im.tx, &listQuery{IncludeArchived: true})
+	if err != nil {
+		return fmt.Errorf("importAccounts: error fetching accounts: %w", err)
+	}
+	for _, account := range accounts {
+		ref := accountRef(account.Name, account.VATNumber)
+		if _, ok := existing[ref]; ok {
+			duplicates[ref] = true
+		}
+		existing[ref] = account
+		im.accountIDs[ref] = account.ID
+	}
+
+	for _, bundleAccount := range bundle.Accounts {
+		account := models.Account{Name: bundleAccount.Name, VATNumber: bundleAccount.VATNumber, Address: bundleAccount.Address}
+		fieldErrs := validateAccount(&account) // Normalises the account, which is stored and compared as validated
+		if account.Name == "" {
+			im.addError("accounts", bundleAccount.Ref, "account name is required")
+			continue
+		}
+		if im.addFieldErrors("accounts", bundleAccount.Ref, fieldErrs) {
+			continue
+		}
+		ref := accountRef(account.Name, account.VATNumber)
+		if bundleAccount.Ref != "" && bundleAccount.Ref != ref {
+			im.addError("accounts", bundleAccount.Ref, "ref must be %q, derived from the VAT number or name", ref)
+			continue
+		}
+		if duplicates[ref] {
+			im.addError("accounts", ref, "several accounts match %q in the target, merge them first", ref)
+			continue
+		}
+		current, ok := existing[ref]
+		if !ok {
+			var id int64
+			err := im.tx.QueryRowContext(im.ctx, `INSERT INTO accounts (name, vat_number, address) VALUES ($1, $2, $3) RETURNING id`,
+				account.Name, account.VATNumber, account.Address).Scan(&id)
+			if err != nil {
+				return fmt.Errorf("importAccounts: error inserting account %q: %w", ref, err)
+			}
+			im.accountIDs[ref] = id
+			existing[ref] = models.Account{ID: id, Name: account.Name, VATNumber: account.VATNumber, Address: account.Address}
+			im.report.Created["accounts"]++
+			continue
+		}
+		if im.resolveConflicts("accounts", ref, []string{"name", "address"},
+			[]interface{}{current.Name, current.Address}, []interface{}{account.Name, account.Address}) {
+			if _, err := im.tx.ExecContext(im.ctx, `UPDATE accounts SET name = $2, address = $3 WHERE id = $1`, current.ID, account.Name, account.Address); err != nil {
+				return fmt.Errorf("importAccounts: error updating account %q: %w", ref, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (im *configImporter) importSites(bundle models.ConfigBundle) error {
+	type existingSite struct {
+		id       int64
+		address  string
+		archived bool
+	}
+	existing := map[int64]map[string]existingSite{} // account id -> site name -> site
+	// Archived sites are matched too, so that importing doesn't duplicate them; they stay archived
+	sites, err := getSites(im.ctx, im.tx, &listQuery{IncludeArchived: true})
+	if err != nil {
+		return fmt.Errorf("importSites: error fetching sites: %w", err)
+	}
+	for _, site := range sites {
+		if existing[site.AccountID] == nil {
+			existing[site.AccountID] = map[string]existingSite{}
+		}
+		existing[site.AccountID][site.Name] = existingSite{id: site.ID, address: site.Address, archived: site.ArchivedAt != nil}
+	}
+	for ref, accountID := range im.accountIDs {
+		im.siteIDs[ref] = map[string]int64{}
+		for name, site := range existing[accountID] {
+			if !site.archived {
+				im.siteIDs[ref][name] = site.id
+			}
+		}
+	}
+
+	for _, account := range bundle.Accounts {
+		ref := accountRef(account.Name, account.VATNumber)
+		accountID, ok := im.accountIDs[ref]
+		if !ok {
+			continue // The account was rejected, with an error already
+		}
+		for _, bundleSite := range account.Sites {
+			site := models.Site{AccountID: accountID, Name: bundleSite.Name, Address: bundleSite.Address}
+			siteRef := ref + "/" + site.Name
+			fieldErrs, err := validateSite(im.ctx, im.tx, &site)
+			if err != nil {
+				return fmt.Errorf("importSites: error validating site %q: %w", siteRef, err)
+			}
+			if im.addFieldErrors("sites", siteRef, fieldErrs) {
+				continue
+			}
+			current, ok := existing[accountID][site.Name]
+			if !ok {
+				newSite, err := createSite(im.ctx, im.tx, site)
+				if err != nil {
+					return fmt.Errorf("importSites: error inserting site %q: %w", siteRef, err)
+				}
+				if existing[accountID] == nil {
+					existing[accountID] = map[string]existingSite{}
+				}
+				existing[accountID][site.Name] = existingSite{id: newSite.ID, address: newSite.Address}
+				im.siteIDs[ref][site.Name] = newSite.ID
+				im.report.Created["sites"]++
+				continue
+			}
+			if im.resolveConflicts("sites", siteRef, []string{"address"}, []interface{}{current.address}, []interface{}{site.Address}) {
+				site.ID = current.id
+				if _, err := updateSite(im.ctx, im.tx, site); err != nil {
+					return fmt.Errorf("importSites: error updating site %q: %w", siteRef, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (im *configImporter) importWorkCentres(bundle models.ConfigBundle) error {
+	existing := map[string]models.WorkCentre{}
+	// Archived work centres are matched too, so that importing doesn't duplicate them; they stay archived
+	workCentres, err := getWorkCentres(im.ctx, im.tx, &listQuery{IncludeArchived: true})
+	if err != nil {
+		return fmt.Errorf("importWorkCentres: error fetching work centres: %w", err)
+	}
+	for _, workCentre := range workCentres {
+		existing[workCentre.Code] = workCentre
+		if workCentre.ArchivedAt == nil {
+			im.workCentreIDs[workCentre.Code] = workCentre.ID
+		}
+	}
+
+	for _, bundleWorkCentre := range bundle.WorkCentres {
+		workCentre := models.WorkCentre{Code: bundleWorkCentre.Code, Name: bundleWorkCentre.Name}
+		if im.addFieldErrors("work_centres", bundleWorkCentre.Code, validateWorkCentre(&workCentre)) {
+			continue
+		}
+		current, ok := existing[workCentre.Code]
+		if !ok {
+			newWorkCentre, err := createWorkCentre(im.ctx, im.tx, workCentre)
+			if err != nil {
+				return fmt.Errorf("importWorkCentres: error inserting work centre %q: %w", workCentre.Code, err)
+			}
+			existing[workCentre.Code] = *newWorkCentre
+			im.workCentreIDs[workCentre.Code] = newWorkCentre.ID
+			im.report.Created["work_centres"]++
+			continue
+		}
+		if im.resolveConflicts("work_centres", workCentre.Code, []string{"name"}, []interface{}{current.Name}, []interface{}{workCentre.Name}) {
+			workCentre.ID = current.ID
+			if _, err := updateWorkCentre(im.ctx, im.tx, workCentre); err != nil {
+				return fmt.Errorf("importWorkCentres: error updating work centre %q: %w", workCentre.Code, err)
+			}
+		}
+	}
+	return nil
+}
+
+// importProducts creates and updates the products. Bundles older than version 4 only have their names, so only
+// the names are compared, and new products get the defaults of the other fields.
+func (im *configImporter) importProducts(bundle models.ConfigBundle) error {
+	products, err := getProducts(im.ctx, im.tx, &listQuery{IncludeArchived: true})
+	if err != nil {
+		return fmt.Errorf("importProducts: error fetching products: %w", err)
+	}
+	for _, product := range products {
+		im.products[product.ProductID] = product
+	}
+
+	for _, product := range bundle.Products {
+		if product.ProductID == "" {
+			im.addError("products", "", "product_id is required")
+			continue
+		}
+		product.ArchivedAt = nil
+		if im.addFieldErrors("products", product.ProductID, validateProduct(&product)) {
+			continue
+		}
+		current, ok := im.products[product.ProductID]
+		if !ok {
+			if _, err := createProduct(im.ctx, im.tx, product); err != nil {
+				return fmt.Errorf("importProducts: error inserting product %q: %w", product.ProductID, err)
+			}
+			im.products[product.ProductID] = product
+			im.report.Created["products"]++
+			continue
+		}
+
+		fields := []string{"name"}
+		existing := []interface{}{current.Name}
+		incoming := []interface{}{product.Name}
+		if im.productDetails {
+			fields = append(fields, "unit_of_measure", "net_weight_kg", "gross_weight_kg", "customer_part_number",
+				"supplier_part_number", "default_container_type", "units_per_container")
+			existing = append(existing, current.UnitOfMeasure, current.NetWeightKg, current.GrossWeightKg, current.CustomerPartNumber,
+				current.SupplierPartNumber, current.DefaultContainerType, current.UnitsPerContainer)
+			incoming = append(incoming, product.UnitOfMeasure, product.NetWeightKg, product.GrossWeightKg, product.CustomerPartNumber,
+				product.SupplierPartNumber, product.DefaultContainerType, product.UnitsPerContainer)
+		} else {
+			name := product.Name
+			product = current
+			product.Name = name
+		}
+		if !im.resolveConflicts("products", product.ProductID, fields, existing, incoming) {
+			continue
+		}
+		updated, err := updateProduct(im.ctx, im.tx, product)
+		if err != nil {
+			return fmt.Errorf("importProducts: error updating product %q: %w", product.ProductID, err)
+		}
+		if err := refuseUnconvertibleChains(im.ctx, im.tx, *updated); err != nil {
+			var conflict *conflictError
+			if !errors.As(err, &conflict) {
+				return fmt.Errorf("importProducts: error checking the kanban chains of product %q: %w", product.ProductID, err)
+			}
+			im.addError("products", product.ProductID, "%s", conflict.message)
+			continue
+		}
+		im.products[product.ProductID] = *updated
+	}
+	return nil
+}
+
+func (im *configImporter) importKanbanChains(bundle models.ConfigBundle) error {
+	for _, bundleChain := range bundle.KanbanChains {
+		ref := fmt.Sprintf("%s/%s/%s", bundleEndpointRef(bundleChain.Customer, bundleChain.CustomerWorkCentre, bundleChain.CustomerSite),
+			bundleChain.ProductID, bundleEndpointRef(bundleChain.Supplier, bundleChain.SupplierWorkCentre, bundleChain.SupplierSite))
+		statusChainID, statusChainOK := im.statusChainIDs[bundleChain.StatusChain]
+		customerID, customerWorkCentreID, customerSiteID, customerOK := im.chainEndpoint(ref, "customer", bundleChain.Customer, bundleChain.CustomerWorkCentre, bundleChain.CustomerSite)
+		supplierID, supplierWorkCentreID, supplierSiteID, supplierOK := im.chainEndpoint(ref, "supplier", bundleChain.Supplier, bundleChain.SupplierWorkCentre, bundleChain.SupplierSite)
+		resolved := customerOK && supplierOK
+		if _, ok := im.products[bundleChain.ProductID]; !ok {
+			im.addError("kanban_chains", ref, "unknown product %q", bundleChain.ProductID)
+			resolved = false
+		}
+		if !statusChainOK {
+			im.addError("kanban_chains", ref, "unknown status chain %q", bundleChain.StatusChain)
+			resolved = false
+		}
+		if !resolved {
+			continue
+		}
+
+		kc := models.KanbanChain{
+			ClienteID:            customerID,
+			ProdottoCodice:       bundleChain.ProductID,
+			FornitoreID:          supplierID,
+			CustomerSiteID:       customerSiteID,
+			SupplierSiteID:       supplierSiteID,
+			CustomerWorkCentreID: customerWorkCentreID,
+			SupplierWorkCentreID: supplierWorkCentreID,
+			KanbanType:           bundleChain.KanbanType,
+			LeadtimeDays:         bundleChain.LeadtimeDays,
+			Quantity:             bundleChain.Quantity,
+			QuantityUnit:         bundleChain.QuantityUnit,
+			TipoContenitore:      bundleChain.TipoContenitore,
+			StatusChainID:        statusChainID,
+			NoOfActiveKanbans:    bundleChain.NoOfActiveKanbans,
+		}
+		var current models.BundleKanbanChain
+		var currentStatusChainID int64
+		var archived bool
+		err := im.tx.QueryRowContext(im.ctx, `
+			SELECT id, status_chain_id, kanban_type, leadtime_days, quantity, quantity_unit, tipo_contenitore, no_of_active_kanbans, archived_at IS NOT NULL
+			FROM kanban_chains
+			WHERE COALESCE(cliente_id, 0) = $1 AND prodotto_codice = $2 AND COALESCE(fornitore_id, 0) = $3
+				AND (NOT $4 OR (customer_site_id IS NOT DISTINCT FROM $5 AND supplier_site_id IS NOT DISTINCT FROM $6))
+				AND customer_work_centre_id IS NOT DISTINCT FROM $7 AND supplier_work_centre_id IS NOT DISTINCT FROM $8
+			ORDER BY id
+			LIMIT 1`, customerID, kc.ProdottoCodice, supplierID, im.matchSites, customerSiteID, supplierSiteID,
+			customerWorkCentreID, supplierWorkCentreID).Scan(
+			&kc.ID, &currentStatusChainID, &current.KanbanType, &current.LeadtimeDays, &current.Quantity, &current.QuantityUnit,
+			&current.TipoContenitore, &current.NoOfActiveKanbans, &archived,
+		)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("importKanbanChains: error looking up kanban chain %s: %w", ref, err)
+		}
+		if archived {
+			im.addError("kanban_chains", ref, "the kanban chain is archived in the target, restore it before importing it")
+			continue
+		}
+		created := err == sql.ErrNoRows
+		if !created && kc.QuantityUnit == "" {
+			kc.QuantityUnit = current.QuantityUnit // Bundles older than version 4 leave it unchanged
+		}
+		fieldErrs, err := validateKanbanChain(im.ctx, im.tx, &kc)
+		if err != nil {
+			return fmt.Errorf("importKanbanChains: error validating kanban chain %s: %w", ref, err)
+		}
+		if im.addFieldErrors("kanban_chains", ref, fieldErrs) {
+			continue
+		}
+
+		if created {
+			err = im.tx.QueryRowContext(im.ctx, `
+				INSERT INTO kanban_chains (
+					cliente_id, prodotto_codice, fornitore_id, leadtime_days,
+					quantity, tipo_contenitore, status_chain_id, no_of_active_kanbans,
+					customer_site_id, supplier_site_id, customer_work_centre_id, supplier_work_centre_id, kanban_type, quantity_unit
+				)
+				VALUES (NULLIF($1, 0), $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+				RETURNING id`,
+				kc.ClienteID, kc.ProdottoCodice, kc.FornitoreID, kc.LeadtimeDays, kc.Quantity, kc.TipoContenitore, kc.StatusChainID,
+				kc.NoOfActiveKanbans, kc.CustomerSiteID, kc.SupplierSiteID, kc.CustomerWorkCentreID, kc.SupplierWorkCentreID,
+				kc.KanbanType, kc.QuantityUnit,
+			).Scan(&kc.ID)
+			if err != nil {
+				return fmt.Errorf("importKanbanChains: error inserting kanban chain %s: %w", ref, err)
+			}
+			if kc.NoOfActiveKanbans > 0 {
+				if err := createInitialKanbans(im.ctx, im.tx, kc.ID, kc.NoOfActiveKanbans, kc.StatusChainID, kc.LeadtimeDays, kc.TipoContenitore, kc.Quantity); err != nil {
+					im.addError("kanban_chains", ref, "%v", err)
+					continue
+				}
+			}
+			im.report.Created["kanban_chains"]++
+			continue
+		}
+
+		current.StatusChain = bundleChain.StatusChain
+		for name, chainID := range im.statusChainIDs {
+			if chainID == currentStatusChainID {
+				current.StatusChain = name
+			}
+		}
+		if kc.NoOfActiveKanbans < current.NoOfActiveKanbans && im.onConflict == onConflictOverwrite {
+			im.addError("kanban_chains", ref, "cannot reduce the chain from %d to %d cards by import, retire cards from the kanban list instead",
+				current.NoOfActiveKanbans, kc.NoOfActiveKanbans)
+			continue
+		}
+		overwrite := im.resolveConflicts("kanban_chains", ref,
+			[]string{"status_chain", "kanban_type", "leadtime_days", "quantity", "quantity_unit", "tipo_contenitore", "no_of_active_kanbans"},
+			[]interface{}{current.StatusChain, current.KanbanType, current.LeadtimeDays, current.Quantity, current.QuantityUnit, current.TipoContenitore, current.NoOfActiveKanbans},
+			[]interface{}{bundleChain.StatusChain, kc.KanbanType, kc.LeadtimeDays, kc.Quantity, kc.QuantityUnit, kc.TipoContenitore, kc.NoOfActiveKanbans},
+		)
+		if !overwrite {
+			continue
+		}
+		previous, err := getKanbanChainForUpdate(im.ctx, im.tx, kc.ID)
+		if err != nil {
+			return fmt.Errorf("importKanbanChains: error locking kanban chain %s: %w", ref, err)
+		}
+		if err := refuseQuantityUnitChange(im.ctx, im.tx, previous, &kc, defaultPropagation); err != nil {
+			var conflict *conflictError
+			if !errors.As(err, &conflict) {
+				return fmt.Errorf("importKanbanChains: error counting the kanbans of kanban chain %s: %w", ref, err)
+			}
+			im.addError("kanban_chains", ref, "%s", conflict.message)
+			continue
+		}
+		_, err = im.tx.ExecContext(im.ctx, `
+			UPDATE kanban_chains
+			SET leadtime_days = $2, quantity = $3, tipo_contenitore = $4, status_chain_id = $5, no_of_active_kanbans = $6, kanban_type = $7,
+				quantity_unit = $8
+			WHERE id = $1`, kc.ID, kc.LeadtimeDays, kc.Quantity, kc.TipoContenitore, kc.StatusChainID, kc.NoOfActiveKanbans, kc.KanbanType, kc.QuantityUnit)
+		if err != nil {
+			return fmt.Errorf("importKanbanChains: error updating kanban chain %s: %w", ref, err)
+		}
+		if _, err := propagateKanbanChainChange(im.ctx, im.tx, previous, &kc, defaultPropagation, ""); err != nil {
+			return fmt.Errorf("importKanbanChains: error propagating the change of kanban chain %s: %w", ref, err)
+		}
+		if missing := kc.NoOfActiveKanbans - current.NoOfActiveKanbans; missing > 0 {
+			if err := createInitialKanbans(im.ctx, im.tx, kc.ID, missing, kc.StatusChainID, kc.LeadtimeDays, kc.TipoContenitore, kc.Quantity); err != nil {
+				im.addError("kanban_chains", ref, "%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// bundleEndpointRef names the account, with its site when it has one, or the work centre at one end of a kanban
+// chain in reports
+func bundleEndpointRef(account, workCentre, site string) string {
+	switch {
+	case workCentre != "":
+		return "work_centre:" + workCentre
+	case site == "":
+		return account
+	}
+	return account + "@" + site
+}
+
+// chainEndpoint resolves the customer or supplier (role) of a bundle kanban chain: an account with an optional
+// site, or a work centre. It reports the errors and returns false when it can't.
+func (im *configImporter) chainEndpoint(ref, role, account, workCentre, site string) (accountID int64, workCentreID, siteID *int64, ok bool) {
+	if workCentre != "" {
+		switch id, found := im.workCentreIDs[workCentre]; {
+		case account != "":
+			im.addError("kanban_chains", ref, "set either %s or %s_work_centre, not both", role, role)
+		case site != "":
+			im.addError("kanban_chains", ref, "only a %s account has sites", role)
+		case !found:
+			im.addError("kanban_chains", ref, "unknown or archived %s work centre %q", role, workCentre)
+		default:
+			return 0, &id, nil, true
+		}
+		return 0, nil, nil, false
+	}
+	accountID, found := im.accountIDs[account]
+	if !found {
+		im.addError("kanban_chains", ref, "unknown %s %q", role, account)
+		return 0, nil, nil, false
+	}
+	siteID, found = im.siteID(account, site)
+	if !found {
+		im.addError("kanban_chains", ref, "unknown or archived site %q of %s %q", site, role, account)
+		return 0, nil, nil, false
+	}
+	return accountID, nil, siteID, true
+}
+
+// siteID resolves a site of a bundle account from its name; an empty name is no site
+func (im *configImporter) siteID(accountRef, name string) (*int64, bool) {
+	if name == "" {
+		return nil, true
+	}
+	id, ok := im.siteIDs[accountRef][name]
+	if !ok {
+		return nil, false
+	}
+	return &id, true
+}
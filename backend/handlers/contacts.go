@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"electronic_kanban_backend/models"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Roles of a contact at their account
+const (
+	contactRolePlanner   = "planner"
+	contactRoleLogistics = "logistics"
+	contactRoleQuality   = "quality"
+)
+
+// Channels a contact is notified on
+const (
+	notifyChannelNone  = "none"
+	notifyChannelEmail = "email"
+	notifyChannelSMS   = "sms"
+)
+
+// Events a contact can be notified of
+const (
+	notifyEventOrder        = "order"          // A card was released to the supplier
+	notifyEventOverdue      = "overdue"        // A card has outstayed its lead time
+	notifyEventStockOutRisk = "stock_out_risk" // A product of the customer dashboard turned red
+)
+
+// GetContactsHandler returns a handler for GET /api/accounts/{id}/contacts
+func GetContactsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		accountID, ok := contactAccountID(w, r)
+		if !ok {
+			return
+		}
+		lq, err := parseListQuery(r, contactListSpec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		if _, err := getAccountByID(ctx, db, accountID); err != nil {
+			writeDBError(w, r, err, "Failed to fetch contacts")
+			return
+		}
+		lq.Filters["account_id"] = []interface{}{accountID}
+
+		contacts, err := getContacts(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch contacts")
+			return
+		}
+		total, err := countRows(ctx, db, contactsFrom, lq, contactListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch contacts")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, contactsFrom, lq, contactListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch contacts")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contacts)
+	}
+}
+
+// CreateContactHandler returns a handler for POST /api/accounts/{id}/contacts
+func CreateContactHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		accountID, ok := contactAccountID(w, r)
+		if !ok {
+			return
+		}
+		var contact models.Contact
+		if err := json.NewDecoder(r.Body).Decode(&contact); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateContact(&contact); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
+			return
+		}
+		contact.AccountID = accountID
+
+		var newContact *models.Contact
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if err := refuseArchivedAccount(ctx, tx, accountID); err != nil {
+				return err
+			}
+			var err error
+			if newContact, err = createContact(ctx, tx, contact); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditCreate, "contact", newContact.ID, nil, newContact)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to create contact")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newContact)
+	}
+}
+
+// GetContactHandler returns a handler for GET /api/accounts/{id}/contacts/{contactId}
+func GetContactHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, id, ok := contactIDs(w, r)
+		if !ok {
+			return
+		}
+
+		contact, err := getContactByID(r.Context(), db, accountID, id)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch contact")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contact)
+	}
+}
+
+// UpdateContactHandler returns a handler for PUT/PATCH /api/accounts/{id}/contacts/{contactId}
+func UpdateContactHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		accountID, id, ok := contactIDs(w, r)
+		if !ok {
+			return
+		}
+		var contactUpdates models.Contact
+		if err := json.NewDecoder(r.Body).Decode(&contactUpdates); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateContact(&contactUpdates); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
+			return
+		}
+		contactUpdates.ID, contactUpdates.AccountID = id, accountID // Ensure IDs from URL are used
+
+		var updatedContact *models.Contact
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			previousContact, err := getContactByID(ctx, tx, accountID, id)
+			if err != nil {
+				return err
+			}
+			if updatedContact, err = updateContact(ctx, tx, contactUpdates); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditUpdate, "contact", id, previousContact, updatedContact)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to update contact")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updatedContact)
+	}
+}
+
+// DeleteContactHandler returns a handler for DELETE /api/accounts/{id}/contacts/{contactId}. Contacts are
+// deleted rather than archived: nothing else refers to them, and people who left shouldn't stay on file.
+func DeleteContactHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		accountID, id, ok := contactIDs(w, r)
+		if !ok {
+			return
+		}
+
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			previousContact, err := getContactByID(ctx, tx, accountID, id)
+			if err != nil {
+				return err
+			}
+			if err := execAffectingRows(ctx, tx, `DELETE FROM contacts WHERE id = $1`, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditDelete, "contact", id, previousContact, nil)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to delete contact")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Contact deleted"})
+	}
+}
+
+// contactAccountID parses the account ID of a contacts route. It writes the error response and returns false
+// when it is invalid.
+func contactAccountID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	accountID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid account ID")
+		return 0, false
+	}
+	return accountID, true
+}
+
+// contactIDs parses the account and contact IDs of a contact route. It writes the error response and returns
+// false when they are invalid.
+func contactIDs(w http.ResponseWriter, r *http.Request) (accountID, id int64, ok bool) {
+	if accountID, ok = contactAccountID(w, r); !ok {
+		return 0, 0, false
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["contactId"], 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid contact ID")
+		return 0, 0, false
+	}
+	return accountID, id, true
+}
+
+// Database interaction functions (private)
+
+// contactListSpec defines what GET /api/accounts/{id}/contacts can sort, filter and search on
+var contactListSpec = listSpec{
+	Fields: map[string]listField{
+		"id":                    {Column: "id", Kind: fieldInt},
+		"account_id":            {Column: "account_id", Kind: fieldInt},
+		"name":                  {Column: "name", Kind: fieldText},
+		"email":                 {Column: "email", Kind: fieldText},
+		"role":                  {Column: "role", Kind: fieldText},
+		"notifications.channel": {Column: "notify_channel", Kind: fieldText},
+	},
+	Search:      []string{"name", "email", "phone"},
+	DefaultSort: "name",
+	TieBreaker:  "id",
+}
+
+const contactsFrom = `FROM contacts WHERE TRUE`
+
+const contactColumns = `id, account_id, name, email, phone, role, notify_channel, notify_events`
+
+// scanContact reads a row of contactColumns
+func scanContact(row interface{ Scan(...interface{}) error }) (*models.Contact, error) {
+	var c models.Contact
+	err := row.Scan(&c.ID, &c.AccountID, &c.Name, &c.Email, &c.Phone, &c.Role, &c.Notifications.Channel, pq.Array(&c.Notifications.Events))
+	if err != nil {
+		return nil, err
+	}
+	if c.Notifications.Events == nil {
+		c.Notifications.Events = []string{}
+	}
+	return &c, nil
+}
+
+func getContacts(ctx context.Context, db dbtx, lq *listQuery) ([]models.Contact, error) {
+	var args []interface{}
+	rows, err := db.QueryContext(ctx, "SELECT "+contactColumns+" "+contactsFrom+
+		lq.where(contactListSpec, &args)+lq.after(contactListSpec, &args)+lq.orderBy(contactListSpec)+lq.page(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []models.Contact{}
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, *contact)
+	}
+	return contacts, rows.Err()
+}
+
+// getContactByID reads a contact of an account; a contact of another account is sql.ErrNoRows
+func getContactByID(ctx context.Context, db dbtx, accountID, id int64) (*models.Contact, error) {
+	return scanContact(db.QueryRowContext(ctx, `SELECT `+contactColumns+` FROM contacts WHERE id = $1 AND account_id = $2`, id, accountID))
+}
+
+func createContact(ctx context.Context, db dbtx, c models.Contact) (*models.Contact, error) {
+	return scanContact(db.QueryRowContext(ctx, `
+		INSERT INTO contacts (account_id, name, email, phone, role, notify_channel, notify_events)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+contactColumns,
+		c.AccountID, c.Name, c.Email, c.Phone, c.Role, c.Notifications.Channel, pq.Array(c.Notifications.Events)))
+}
+
+func updateContact(ctx context.Context, db dbtx, c models.Contact) (*models.Contact, error) {
+	return scanContact(db.QueryRowContext(ctx, `
+		UPDATE contacts
+		SET name = $3, email = $4, phone = $5, role = $6, notify_channel = $7, notify_events = $8
+		WHERE id = $1 AND account_id = $2
+		RETURNING `+contactColumns,
+		c.ID, c.AccountID, c.Name, c.Email, c.Phone, c.Role, c.Notifications.Channel, pq.Array(c.Notifications.Events)))
+}
+
+// refuseArchivedAccount returns sql.ErrNoRows for a missing account and a conflictError for an archived one
+func refuseArchivedAccount(ctx context.Context, db dbtx, accountID int64) error {
+	account, err := getAccountByID(ctx, db, accountID)
+	if err != nil {
+		return err
+	}
+	if account.ArchivedAt != nil {
+		return &conflictError{message: "The account is archived, restore it first"}
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"electronic_kanban_backend/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Dashboards, as named by saved views
+const (
+	dashboardSupplier = "supplier"
+	dashboardCustomer = "customer"
+)
+
+// Groupings of the cards of a dashboard, besides the counterpart (customer or supplier)
+const (
+	groupByProduct = "product"
+	groupByStatus  = "status"
+	groupBySite    = "site" // The dashboard owner's site: ship-from on the supplier dashboard, ship-to on the customer one
+)
+
+// counterpart names the accounts or work centres on the other side of a dashboard: the customers of a supplier,
+// the suppliers of a customer
+func counterpart(dashboard string) string {
+	if dashboard == dashboardSupplier {
+		return "customer"
+	}
+	return "supplier"
+}
+
+// validDashboard reports whether dashboard names a dashboard
+func validDashboard(dashboard string) bool {
+	return dashboard == dashboardSupplier || dashboard == dashboardCustomer
+}
+
+// dashboardQueryParams documents the filtering and grouping parameters of a dashboard
+func dashboardQueryParams(dashboard string) []apiParam {
+	account := counterpart(dashboard)
+	return []apiParam{
+		{"status_id", "integer", "Only cards in this status, repeat the parameter to match any of several"},
+		{"product_id", "string", "Only cards of this product, repeatable"},
+		{account + "_id", "integer", "Only cards of this " + account + " account, repeatable"},
+		{account + "_work_centre_id", "integer", "Only cards of this internal " + account + ", repeatable; combines with " + account + "_id"},
+		{"customer_site_id", "integer", "Only cards of kanban chains shipping to this customer site, repeatable"},
+		{"supplier_site_id", "integer", "Only cards of kanban chains shipping from this supplier site, repeatable"},
+		{"overdue", "boolean", "Only cards that have (true) or haven't (false) outstayed their lead time"},
+		{"group_by", "string", "product (default), " + account + ", status or site"},
+		{"unit", "string", "Show quantities in this unit, such as kg, for the products that convert to it; in each product's unit of measure by default"},
+		{"view", "string", "Start from the caller's saved view of this name; the other parameters override it"},
+	}
+}
+
+// dashboardFilter is the parsed filtering and grouping of a dashboard request. The product, counterpart and site
+// filters narrow the whole dashboard; the status and overdue filters only the cards listed.
+type dashboardFilter struct {
+	dashboard     string
+	statuses      map[int64]bool
+	products      map[string]bool
+	accounts      map[int64]bool
+	workCentres   map[int64]bool
+	customerSites map[int64]bool
+	supplierSites map[int64]bool
+	overdue       *bool
+	groupBy       string
+	unit          string // Unit to show quantities in, empty for each product's unit of measure
+}
+
+// parseDashboardFilter validates the dashboard parameters in values; unknown parameters are ignored
+func parseDashboardFilter(dashboard string, values url.Values) (*dashboardFilter, error) {
+	f := &dashboardFilter{dashboard: dashboard, groupBy: groupByProduct}
+
+	var err error
+	if f.statuses, err = parseIDSet(values, "status_id"); err != nil {
+		return nil, err
+	}
+	if f.accounts, err = parseIDSet(values, counterpart(dashboard)+"_id"); err != nil {
+		return nil, err
+	}
+	if f.workCentres, err = parseIDSet(values, counterpart(dashboard)+"_work_centre_id"); err != nil {
+		return nil, err
+	}
+	if f.customerSites, err = parseIDSet(values, "customer_site_id"); err != nil {
+		return nil, err
+	}
+	if f.supplierSites, err = parseIDSet(values, "supplier_site_id"); err != nil {
+		return nil, err
+	}
+	if products := values["product_id"]; len(products) > 0 {
+		f.products = map[string]bool{}
+		for _, product := range products {
+			f.products[product] = true
+		}
+	}
+
+	if raw := values.Get("overdue"); raw != "" {
+		overdue, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("overdue must be true or false")
+		}
+		f.overdue = &overdue
+	}
+
+	if f.unit = values.Get("unit"); f.unit != "" {
+		if _, ok := unitsOfMeasure[f.unit]; !ok {
+			return nil, fmt.Errorf("unit must be one of %s", unitNames())
+		}
+	}
+
+	switch groupBy := values.Get("group_by"); groupBy {
+	case "":
+	case groupByProduct, groupByStatus, groupBySite, counterpart(dashboard):
+		f.groupBy = groupBy
+	default:
+		return nil, fmt.Errorf("group_by must be %s, %s, %s or %s", groupByProduct, counterpart(dashboard), groupByStatus, groupBySite)
+	}
+	return f, nil
+}
+
+// parseIDSet parses the repeated integer parameter name, nil when it is missing
+func parseIDSet(values url.Values, name string) (map[int64]bool, error) {
+	if len(values[name]) == 0 {
+		return nil, nil
+	}
+	ids := map[int64]bool{}
+	for _, raw := range values[name] {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s", raw, name)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// counterpartOf returns the counterpart of k on the dashboard: an account, or a work centre with account 0
+func (f *dashboardFilter) counterpartOf(k models.DashboardKanban) (accountID int64, workCentreID *int64, name string) {
+	if f.dashboard == dashboardSupplier {
+		return k.CustomerID, k.CustomerWorkCentreID, k.CustomerName
+	}
+	return k.SupplierID, k.SupplierWorkCentreID, k.SupplierName
+}
+
+// site returns the site of the dashboard owner in the kanban chain of k, nil when the chain has none
+func (f *dashboardFilter) site(k models.DashboardKanban) (*int64, string) {
+	if f.dashboard == dashboardSupplier {
+		return k.SupplierSiteID, k.SupplierSiteName
+	}
+	return k.CustomerSiteID, k.CustomerSiteName
+}
+
+// inScope reports whether the product, counterpart and sites of a card pass the filters. The account and work
+// centre filters add up: a card passes either. A site filter leaves out the cards of chains without a site on
+// that side.
+func (f *dashboardFilter) inScope(productID string, accountID int64, workCentreID, customerSiteID, supplierSiteID *int64) bool {
+	counterpartIn := (f.accounts == nil && f.workCentres == nil) || f.accounts[accountID] ||
+		(workCentreID != nil && f.workCentres[*workCentreID])
+	return (f.products == nil || f.products[productID]) && counterpartIn &&
+		idInSet(f.customerSites, customerSiteID) && idInSet(f.supplierSites, supplierSiteID)
+}
+
+// idInSet reports whether id is in set; every id, including none, is in a nil set
+func idInSet(set map[int64]bool, id *int64) bool {
+	return set == nil || (id != nil && set[*id])
+}
+
+// scope returns the kanbans whose product, counterpart and sites pass the filters
+func (f *dashboardFilter) scope(kanbans []models.DashboardKanban) []models.DashboardKanban {
+	scoped := []models.DashboardKanban{}
+	for _, k := range kanbans {
+		if accountID, workCentreID, _ := f.counterpartOf(k); f.inScope(k.ProductID, accountID, workCentreID, k.CustomerSiteID, k.SupplierSiteID) {
+			scoped = append(scoped, k)
+		}
+	}
+	return scoped
+}
+
+// cards returns the kanbans of scoped that also pass the status and overdue filters
+func (f *dashboardFilter) cards(scoped []models.DashboardKanban) []models.DashboardKanban {
+	cards := []models.DashboardKanban{}
+	for _, k := range scoped {
+		if f.statuses != nil && !f.statuses[k.StatusCurrent] {
+			continue
+		}
+		if f.overdue != nil && k.Overdue != *f.overdue {
+			continue
+		}
+		cards = append(cards, k)
+	}
+	return cards
+}
+
+// group splits kanbans by the filter's grouping, keeping their order within each group. Groups are sorted by
+// name, statuses by stage of the kanban loop first. Cards of chains without a site share the site group with
+// an empty key; internal counterparts are keyed work_centre:<id>.
+func (f *dashboardFilter) group(kanbans []models.DashboardKanban) []models.DashboardGroup {
+	index := map[string]int{}
+	groups := []models.DashboardGroup{}
+	stages := map[string]string{} // status group -> stage, for sorting
+	for _, k := range kanbans {
+		var key, name string
+		switch f.groupBy {
+		case groupByProduct:
+			key, name = k.ProductID, k.ProductName
+		case groupByStatus:
+			key, name = strconv.FormatInt(k.StatusCurrent, 10), k.StatusName
+			stages[key] = k.Stage
+		case groupBySite:
+			if siteID, siteName := f.site(k); siteID != nil {
+				key, name = strconv.FormatInt(*siteID, 10), siteName
+			}
+		default:
+			accountID, workCentreID, counterpartName := f.counterpartOf(k)
+			key, name = strconv.FormatInt(accountID, 10), counterpartName
+			if workCentreID != nil {
+				key = "work_centre:" + strconv.FormatInt(*workCentreID, 10)
+			}
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, models.DashboardGroup{Key: key, Name: name})
+		}
+		groups[i].Kanbans = append(groups[i].Kanbans, k)
+	}
+
+	stageRank := map[string]int{stageToProduce: 0, stageInProduction: 1, stageInTransit: 2, stageAtCustomer: 3}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if f.groupBy == groupByStatus && stages[a.Key] != stages[b.Key] {
+			return stageRank[stages[a.Key]] < stageRank[stages[b.Key]]
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Key < b.Key
+	})
+	return groups
+}
+
+// dashboardQuery returns the query parameters of r, on top of those of the caller's saved view of dashboard
+// named by the view parameter. A missing view is sql.ErrNoRows.
+func dashboardQuery(ctx context.Context, db dbtx, r *http.Request, dashboard string) (url.Values, error) {
+	values := r.URL.Query()
+	name := values.Get("view")
+	if name == "" {
+		return values, nil
+	}
+	view, err := getDashboardView(ctx, db, actor(r), dashboard, name)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := url.ParseQuery(view.Query)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the query of dashboard view %q: %w", name, err)
+	}
+	for key, value := range values {
+		if key != "view" {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// dashboardViewRequest is the body of PUT /api/dashboard-views/{dashboard}/{name}
+type dashboardViewRequest struct {
+	Query string `json:"query"` // Dashboard query parameters, URL-encoded, like "status_id=3&group_by=customer"
+}
+
+// GetDashboardViewsHandler returns a handler for GET /api/dashboard-views, listing the caller's saved views
+func GetDashboardViewsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		dashboard := r.URL.Query().Get("dashboard")
+		if dashboard != "" && !validDashboard(dashboard) {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "dashboard must be supplier or customer")
+			return
+		}
+
+		views, err := getDashboardViews(ctx, db, actor(r), dashboard)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch dashboard views")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views)
+	}
+}
+
+// SaveDashboardViewHandler returns a handler for PUT /api/dashboard-views/{dashboard}/{name}, which creates or
+// replaces a saved view of the caller
+func SaveDashboardViewHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		vars := mux.Vars(r)
+		dashboard, name := vars["dashboard"], vars["name"]
+		if !validDashboard(dashboard) {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "dashboard must be supplier or customer")
+			return
+		}
+
+		var req dashboardViewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateDashboardView(dashboard, name, req.Query); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
+			return
+		}
+
+		view, err := saveDashboardView(ctx, db, models.DashboardView{Owner: actor(r), Dashboard: dashboard, Name: name, Query: req.Query})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to save dashboard view")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(view)
+	}
+}
+
+// DeleteDashboardViewHandler returns a handler for DELETE /api/dashboard-views/{dashboard}/{name}
+func DeleteDashboardViewHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		vars := mux.Vars(r)
+		err := execAffectingRows(ctx, db, `DELETE FROM dashboard_views WHERE owner = $1 AND dashboard = $2 AND name = $3`,
+			actor(r), vars["dashboard"], vars["name"])
+		if err != nil {
+			writeDBError(w, r, err, "Failed to delete dashboard view")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Dashboard view deleted"})
+	}
+}
+
+// validateDashboardView checks the name of a view and that its query holds valid dashboard parameters
+func validateDashboardView(dashboard, name, query string) []models.FieldError {
+	var errs fieldErrorList
+	errs.requireName("name", name)
+	values, err := url.ParseQuery(query)
+	switch {
+	case err != nil:
+		errs.add("query", "query must be URL-encoded parameters: %v", err)
+	case values.Has("view"):
+		errs.add("query", "a view cannot refer to another view")
+	default:
+		if _, err := parseDashboardFilter(dashboard, values); err != nil {
+			errs.add("query", "%v", err)
+		}
+	}
+	return errs
+}
+
+// Database interaction functions (private)
+
+const dashboardViewColumns = `id, owner, dashboard, name, query, updated_at`
+
+func scanDashboardView(row interface{ Scan(...interface{}) error }) (*models.DashboardView, error) {
+	var v models.DashboardView
+	if err := row.Scan(&v.ID, &v.Owner, &v.Dashboard, &v.Name, &v.Query, &v.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// getDashboardView reads a saved view of owner
+func getDashboardView(ctx context.Context, db dbtx, owner, dashboard, name string) (*models.DashboardView, error) {
+	return scanDashboardView(db.QueryRowContext(ctx, `
+		SELECT `+dashboardViewColumns+`
+		FROM dashboard_views
+		WHERE owner = $1 AND dashboard = $2 AND name = $3`, owner, dashboard, name))
+}
+
+// getDashboardViews lists the saved views of owner, of every dashboard when dashboard is empty
+func getDashboardViews(ctx context.Context, db dbtx, owner, dashboard string) ([]models.DashboardView, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+dashboardViewColumns+`
+		FROM dashboard_views
+		WHERE owner = $1 AND ($2 = '' OR dashboard = $2)
+		ORDER BY dashboard, name`, owner, dashboard)
+	if err != nil {
+		return nil, fmt.Errorf("error querying dashboard views: %w", err)
+	}
+	defer rows.Close()
+
+	views := []models.DashboardView{}
+	for rows.Next() {
+		view, err := scanDashboardView(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning dashboard view: %w", err)
+		}
+		views = append(views, *view)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dashboard views: %w", err)
+	}
+	return views, nil
+}
+
+// saveDashboardView creates the view, or replaces the query of the owner's view of the same dashboard and name
+func saveDashboardView(ctx context.Context, db dbtx, view models.DashboardView) (*models.DashboardView, error) {
+	return scanDashboardView(db.QueryRowContext(ctx, `
+		INSERT INTO dashboard_views (owner, dashboard, name, query)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, owner, dashboard, name) DO UPDATE SET query = EXCLUDED.query, updated_at = NOW()
+		RETURNING `+dashboardViewColumns, view.Owner, view.Dashboard, view.Name, view.Query))
+}
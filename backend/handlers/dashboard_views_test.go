@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"electronic_kanban_backend/models"
+)
+
+func TestParseDashboardFilter(t *testing.T) {
+	overdue := false
+	tests := []struct {
+		name      string
+		dashboard string
+		query     string
+		want      *dashboardFilter
+		wantErr   string
+	}{
+		{
+			name:      "defaults",
+			dashboard: dashboardSupplier,
+			want:      &dashboardFilter{dashboard: dashboardSupplier, groupBy: groupByProduct},
+		},
+		{
+			name:      "every filter",
+			dashboard: dashboardSupplier,
+			query: "status_id=1&status_id=2&product_id=P1&customer_id=7&customer_work_centre_id=8&customer_site_id=9" +
+				"&supplier_site_id=10&overdue=false&unit=kg&group_by=customer&supplier_id=x",
+			want: &dashboardFilter{
+				dashboard:     dashboardSupplier,
+				statuses:      map[int64]bool{1: true, 2: true},
+				products:      map[string]bool{"P1": true},
+				accounts:      map[int64]bool{7: true},
+				workCentres:   map[int64]bool{8: true},
+				customerSites: map[int64]bool{9: true},
+				supplierSites: map[int64]bool{10: true},
+				overdue:       &overdue,
+				groupBy:       "customer",
+				unit:          "kg",
+			},
+		},
+		{
+			name:      "counterpart of the customer dashboard",
+			dashboard: dashboardCustomer,
+			query:     "supplier_id=3&group_by=supplier",
+			want:      &dashboardFilter{dashboard: dashboardCustomer, accounts: map[int64]bool{3: true}, groupBy: "supplier"},
+		},
+		{name: "status not a number", dashboard: dashboardSupplier, query: "status_id=open", wantErr: `invalid value "open" for status_id`},
+		{name: "site not a number", dashboard: dashboardCustomer, query: "customer_site_id=1.5", wantErr: `invalid value "1.5" for customer_site_id`},
+		{name: "overdue not a boolean", dashboard: dashboardSupplier, query: "overdue=late", wantErr: "overdue must be true or false"},
+		{name: "unknown unit", dashboard: dashboardSupplier, query: "unit=furlong", wantErr: "unit must be one of"},
+		{
+			name:      "grouping by the other dashboard's counterpart",
+			dashboard: dashboardCustomer,
+			query:     "group_by=customer",
+			wantErr:   "group_by must be product, supplier, status or site",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseDashboardFilter(tt.dashboard, values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboardFilterScopeAndCards(t *testing.T) {
+	site, workCentre := int64(9), int64(8)
+	kanbans := []models.DashboardKanban{
+		{ID: 1, ProductID: "P1", CustomerID: 7, CustomerSiteID: &site, StatusCurrent: 1},
+		{ID: 2, ProductID: "P1", CustomerWorkCentreID: &workCentre, CustomerSiteID: &site, StatusCurrent: 2, Overdue: true},
+		{ID: 3, ProductID: "P2", CustomerID: 7, CustomerSiteID: &site, StatusCurrent: 1},
+		{ID: 4, ProductID: "P1", CustomerID: 6, StatusCurrent: 1},
+	}
+	f, err := parseDashboardFilter(dashboardSupplier, url.Values{
+		"product_id": {"P1"}, "customer_id": {"7"}, "customer_work_centre_id": {"8"}, "customer_site_id": {"9"},
+		"overdue": {"true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scoped := f.scope(kanbans)
+	if got := dashboardKanbanIDs(scoped); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("scope = %v, want [1 2]", got)
+	}
+	if got := dashboardKanbanIDs(f.cards(scoped)); !reflect.DeepEqual(got, []int64{2}) {
+		t.Errorf("cards = %v, want [2]", got)
+	}
+}
+
+func TestDashboardFilterGroup(t *testing.T) {
+	siteA, siteB, workCentre := int64(1), int64(2), int64(5)
+	kanbans := []models.DashboardKanban{
+		{ID: 1, ProductID: "P2", ProductName: "Bolts", StatusCurrent: 13, StatusName: "Arrived", Stage: stageAtCustomer,
+			CustomerID: 7, CustomerName: "Zeta", SupplierSiteID: &siteB, SupplierSiteName: "Torino"},
+		{ID: 2, ProductID: "P1", ProductName: "Axles", StatusCurrent: 12, StatusName: "Shipped", Stage: stageInTransit,
+			CustomerWorkCentreID: &workCentre, CustomerName: "Assembly"},
+		{ID: 3, ProductID: "P2", ProductName: "Bolts", StatusCurrent: 10, StatusName: "Waiting", Stage: stageToProduce,
+			CustomerID: 7, CustomerName: "Zeta", SupplierSiteID: &siteA, SupplierSiteName: "Milano"},
+		{ID: 4, ProductID: "P3", ProductName: "Bolts", StatusCurrent: 11, StatusName: "Filling", Stage: stageInProduction,
+			CustomerID: 6, CustomerName: "Alfa", SupplierSiteID: &siteA, SupplierSiteName: "Milano"},
+		{ID: 5, ProductID: "P1", ProductName: "Axles", StatusCurrent: 10, StatusName: "Waiting", Stage: stageToProduce,
+			CustomerID: 6, CustomerName: "Alfa", SupplierSiteID: &siteB, SupplierSiteName: "Torino"},
+	}
+	type group struct {
+		key, name string
+		ids       []int64
+	}
+	tests := []struct {
+		groupBy string
+		want    []group
+	}{
+		{
+			// Same name, told apart by key; order of the cards kept
+			groupBy: groupByProduct,
+			want:    []group{{"P1", "Axles", []int64{2, 5}}, {"P2", "Bolts", []int64{1, 3}}, {"P3", "Bolts", []int64{4}}},
+		},
+		{
+			// Stage order of the kanban loop, not alphabetical
+			groupBy: groupByStatus,
+			want: []group{
+				{"10", "Waiting", []int64{3, 5}}, {"11", "Filling", []int64{4}}, {"12", "Shipped", []int64{2}}, {"13", "Arrived", []int64{1}},
+			},
+		},
+		{
+			groupBy: "customer",
+			want:    []group{{"6", "Alfa", []int64{4, 5}}, {"work_centre:5", "Assembly", []int64{2}}, {"7", "Zeta", []int64{1, 3}}},
+		},
+		{
+			// Chains without a site share the group with an empty key
+			groupBy: groupBySite,
+			want:    []group{{"", "", []int64{2}}, {"1", "Milano", []int64{3, 4}}, {"2", "Torino", []int64{1, 5}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			f := &dashboardFilter{dashboard: dashboardSupplier, groupBy: tt.groupBy}
+			var got []group
+			for _, g := range f.group(kanbans) {
+				got = append(got, group{g.Key, g.Name, dashboardKanbanIDs(g.Kanbans)})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDashboardView(t *testing.T) {
+	if errs := validateDashboardView(dashboardSupplier, "Late", "overdue=true&group_by=status"); len(errs) > 0 {
+		t.Errorf("valid view rejected: %v", errs)
+	}
+	for _, query := range []string{"view=other", "group_by=supplier", "status_id=%zz"} {
+		if errs := validateDashboardView(dashboardSupplier, "Late", query); len(errs) != 1 || errs[0].Field != "query" {
+			t.Errorf("query %q: got %v, want one error on query", query, errs)
+		}
+	}
+}
+
+func dashboardKanbanIDs(kanbans []models.DashboardKanban) []int64 {
+	ids := []int64{}
+	for _, k := range kanbans {
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
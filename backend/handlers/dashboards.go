@@ -1,93 +1,475 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
+
+	"electronic_kanban_backend/models"
 
 	"github.com/gorilla/mux"
 )
 
+// Columns of kanban_chains holding the owner of a dashboard
+const (
+	ownerSupplierAccount    = "kc.fornitore_id"
+	ownerSupplierWorkCentre = "kc.supplier_work_centre_id"
+	ownerCustomerAccount    = "kc.cliente_id"
+	ownerCustomerWorkCentre = "kc.customer_work_centre_id"
+)
+
 // GetSupplierDashboardHandler will handle GET requests to /api/dashboards/supplier/{supplierId}
 func GetSupplierDashboardHandler(db *sql.DB) http.HandlerFunc {
+	return supplierDashboardHandler(db, "supplierId", "Invalid supplier ID", ownerSupplierAccount)
+}
+
+// GetWorkCentreSupplierDashboardHandler will handle GET requests to /api/dashboards/work-centres/{workCentreId}/supplier,
+// the supplier dashboard of a work centre feeding internal customers or accounts
+func GetWorkCentreSupplierDashboardHandler(db *sql.DB) http.HandlerFunc {
+	return supplierDashboardHandler(db, "workCentreId", "Invalid work centre ID", ownerSupplierWorkCentre)
+}
+
+// GetCustomerDashboardHandler will handle GET requests to /api/dashboards/customer/{customerId}
+func GetCustomerDashboardHandler(db *sql.DB) http.HandlerFunc {
+	return customerDashboardHandler(db, "customerId", "Invalid customer ID", ownerCustomerAccount)
+}
+
+// GetWorkCentreCustomerDashboardHandler will handle GET requests to /api/dashboards/work-centres/{workCentreId}/customer,
+// the customer dashboard of a work centre drawing parts from internal suppliers or accounts
+func GetWorkCentreCustomerDashboardHandler(db *sql.DB) http.HandlerFunc {
+	return customerDashboardHandler(db, "workCentreId", "Invalid work centre ID", ownerCustomerWorkCentre)
+}
+
+// supplierDashboardHandler serves the supplier dashboard of the owner named by the route variable idVar, whose
+// kanban chains are those with the owner in ownerColumn
+func supplierDashboardHandler(db *sql.DB, idVar, invalidIDMessage, ownerColumn string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		w.Header().Set("Content-Type", "application/json")
-		vars := mux.Vars(r)
-		supplierIDStr := vars["supplierId"]
-		supplierID, err := strconv.ParseInt(supplierIDStr, 10, 64)
+		ownerID, err := strconv.ParseInt(mux.Vars(r)[idVar], 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid supplier ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, invalidIDMessage)
+			return
+		}
+		filter, ok := dashboardRequestFilter(w, r, db, dashboardSupplier)
+		if !ok {
 			return
 		}
 
-		kanbans, err := getKanbansForSupplierDashboard(db, supplierID)
+		kanbans, err := getKanbansForSupplierDashboard(ctx, db, ownerColumn, ownerID, filter.unit)
 		if err != nil {
-			http.Error(w, "Failed to fetch kanban data for supplier dashboard", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to fetch kanban data for supplier dashboard")
 			return
 		}
+		scoped := filter.scope(kanbans)
+		cards := filter.cards(scoped)
 
-		// Organize kanbans by product (you might want to refine this structure)
-		kanbansByProduct := make(map[string][]map[string]interface{})
-		for _, kanban := range kanbans {
-			productID, ok := kanban["prodotto_codice"].(string)
-			if !ok {
-				productID = "Unknown Product" // Handle case where product_id is not a string
-			}
-			kanbansByProduct[productID] = append(kanbansByProduct[productID], kanban)
+		response := models.SupplierDashboardResponse{
+			Summary:          summarizeSupplierDashboard(scoped, time.Now()),
+			GroupBy:          filter.groupBy,
+			Groups:           filter.group(cards),
+			KanbansByProduct: groupDashboardKanbansByProduct(cards),
 		}
-
-		response := map[string]interface{}{
-			"supplier_id":        supplierID,
-			"kanbans_by_product": kanbansByProduct,
+		if ownerColumn == ownerSupplierWorkCentre {
+			response.WorkCentreID = ownerID
+		} else {
+			response.SupplierID = ownerID
 		}
 
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
-// GetCustomerDashboardHandler will handle GET requests to /api/dashboards/customer/{customerId}
-func GetCustomerDashboardHandler(db *sql.DB) http.HandlerFunc {
+// customerDashboardHandler serves the customer dashboard of the owner named by the route variable idVar, whose
+// kanban chains are those with the owner in ownerColumn
+func customerDashboardHandler(db *sql.DB, idVar, invalidIDMessage, ownerColumn string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		w.Header().Set("Content-Type", "application/json")
-		vars := mux.Vars(r)
-		customerIDStr := vars["customerId"]
-		customerID, err := strconv.ParseInt(customerIDStr, 10, 64)
+		ownerID, err := strconv.ParseInt(mux.Vars(r)[idVar], 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, invalidIDMessage)
+			return
+		}
+		filter, ok := dashboardRequestFilter(w, r, db, dashboardCustomer)
+		if !ok {
 			return
 		}
 
-		kanbans, err := getKanbansForCustomerDashboard(db, customerID)
+		kanbans, err := getKanbansForCustomerDashboard(ctx, db, ownerColumn, ownerID, filter.unit)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch kanban data for customer dashboard")
+			return
+		}
+		now := time.Now()
+		moves, err := getCustomerKanbanMoves(ctx, db, ownerColumn, ownerID, now.Add(-consumptionWindow), filter.unit)
 		if err != nil {
-			http.Error(w, "Failed to fetch kanban data for customer dashboard", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to fetch kanban history for customer dashboard")
 			return
 		}
 
-		// Organize kanbans by product (you might want to refine this structure)
-		kanbansByProduct := make(map[string][]map[string]interface{})
-		for _, kanban := range kanbans {
-			productID, ok := kanban["prodotto_codice"].(string)
-			if !ok {
-				productID = "Unknown Product" // Handle case where product_id is not a string
+		scoped := filter.scope(kanbans)
+		cards := filter.cards(scoped)
+		scopedMoves := []kanbanMove{}
+		for _, m := range moves {
+			if filter.inScope(m.ProductID, m.SupplierID, m.SupplierWorkCentreID, m.CustomerSiteID, m.SupplierSiteID) {
+				scopedMoves = append(scopedMoves, m)
 			}
-			kanbansByProduct[productID] = append(kanbansByProduct[productID], kanban)
 		}
 
-		response := map[string]interface{}{
-			"customer_id":        customerID,
-			"kanbans_by_product": kanbansByProduct,
+		response := models.CustomerDashboardResponse{
+			Coverage:         customerCoverage(scoped, scopedMoves, now),
+			GroupBy:          filter.groupBy,
+			Groups:           filter.group(cards),
+			KanbansByProduct: groupDashboardKanbansByProduct(cards),
+		}
+		if ownerColumn == ownerCustomerWorkCentre {
+			response.WorkCentreID = ownerID
+		} else {
+			response.CustomerID = ownerID
 		}
 
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
+// dashboardRequestFilter parses the filtering and grouping of a dashboard request, with the saved view it names.
+// It writes the error response and returns false when they are invalid.
+func dashboardRequestFilter(w http.ResponseWriter, r *http.Request, db *sql.DB, dashboard string) (*dashboardFilter, bool) {
+	values, err := dashboardQuery(r.Context(), db, r, dashboard)
+	if err != nil {
+		writeDBError(w, r, err, "Failed to fetch dashboard view")
+		return nil, false
+	}
+	filter, err := parseDashboardFilter(dashboard, values)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return nil, false
+	}
+	return filter, true
+}
+
+// groupDashboardKanbansByProduct organizes dashboard kanbans by product code
+func groupDashboardKanbansByProduct(kanbans []models.DashboardKanban) map[string][]models.DashboardKanban {
+	kanbansByProduct := make(map[string][]models.DashboardKanban)
+	for _, kanban := range kanbans {
+		kanbansByProduct[kanban.ProductID] = append(kanbansByProduct[kanban.ProductID], kanban)
+	}
+	return kanbansByProduct
+}
+
+// summarizeSupplierDashboard counts the cards of each product and customer by stage, ranked by priority:
+// fewest full containers at the customer first, then the smallest share of the loop at the customer,
+// then the longest wait. Cards whose quantities are in different units, because they don't convert, are
+// summarised apart so their quantities never add up.
+func summarizeSupplierDashboard(kanbans []models.DashboardKanban, now time.Time) []models.SupplierDashboardSummary {
+	type key struct {
+		productID    string
+		customerID   int64
+		workCentreID int64 // 0 for an account
+		quantityUnit string
+	}
+	index := map[key]int{}
+	summary := []models.SupplierDashboardSummary{}
+	for _, k := range kanbans {
+		kk := key{productID: k.ProductID, customerID: k.CustomerID, quantityUnit: k.QuantityUnit}
+		if k.CustomerWorkCentreID != nil {
+			kk.workCentreID = *k.CustomerWorkCentreID
+		}
+		i, ok := index[kk]
+		if !ok {
+			i = len(summary)
+			index[kk] = i
+			summary = append(summary, models.SupplierDashboardSummary{
+				ProductID: k.ProductID, ProductName: k.ProductName, CustomerID: k.CustomerID,
+				CustomerWorkCentreID: k.CustomerWorkCentreID, CustomerName: k.CustomerName, QuantityUnit: k.QuantityUnit,
+			})
+		}
+		s := &summary[i]
+		switch k.Stage {
+		case stageToProduce:
+			s.ToProduce++
+			s.QuantityToShip += k.Quantity
+			if s.OldestWaitingSince == nil || k.UpdatedAt.Before(*s.OldestWaitingSince) {
+				since := k.UpdatedAt
+				s.OldestWaitingSince = &since
+			}
+		case stageInProduction:
+			s.InProduction++
+			s.QuantityToShip += k.Quantity
+		case stageInTransit:
+			s.InTransit++
+		case stageAtCustomer:
+			s.AtCustomer++
+		}
+	}
+
+	for i := range summary {
+		summary[i].QuantityToShip = roundQuantity(summary[i].QuantityToShip)
+		if since := summary[i].OldestWaitingSince; since != nil {
+			summary[i].OldestWaitingHours = math.Round(now.Sub(*since).Hours()*10) / 10
+		}
+	}
+	sort.SliceStable(summary, func(i, j int) bool {
+		a, b := summary[i], summary[j]
+		if a.AtCustomer != b.AtCustomer {
+			return a.AtCustomer < b.AtCustomer
+		}
+		shareA := float64(a.AtCustomer) / float64(a.ToProduce+a.InProduction+a.InTransit+a.AtCustomer)
+		shareB := float64(b.AtCustomer) / float64(b.ToProduce+b.InProduction+b.InTransit+b.AtCustomer)
+		if shareA != shareB {
+			return shareA < shareB
+		}
+		return a.OldestWaitingHours > b.OldestWaitingHours
+	})
+	for i := range summary {
+		summary[i].Priority = i + 1
+	}
+	return summary
+}
+
+// consumptionWindow is how far back the customer dashboard measures consumption
+const consumptionWindow = 28 * 24 * time.Hour
+
+// Stock-out risk of a product on the customer dashboard
+const (
+	riskRed   = "red"   // No full container left, or the stock runs out before the next container arrives
+	riskAmber = "amber" // Less than one lead time of coverage left
+	riskGreen = "green"
+)
+
+// kanbanMove is a status change of a card, from kanban_histories
+type kanbanMove struct {
+	KanbanID             int64
+	ProductID            string
+	SupplierID           int64
+	SupplierWorkCentreID *int64
+	CustomerSiteID       *int64
+	SupplierSiteID       *int64
+	Quantity             float64
+	QuantityUnit         string
+	Stage                string // Stage of the kanban loop the card moved to
+	At                   time.Time
+}
+
+// customerCoverage works out, for each product of the customer dashboard, the stock on hand, how long it lasts at
+// the consumption measured from the cards released to the supplier in moves, the cards in transit, and the risk of
+// running out. Products most at risk come first. The cards of a product whose quantities are in different units,
+// because they don't convert, are covered apart so their quantities never add up.
+func customerCoverage(kanbans []models.DashboardKanban, moves []kanbanMove, now time.Time) []models.ProductCoverage {
+	type key struct {
+		productID    string
+		quantityUnit string
+	}
+	lastRelease := map[int64]time.Time{}    // kanban -> last release to the supplier
+	released := map[key]float64{}           // product and unit -> quantity released
+	measuredSince := map[string]time.Time{} // product -> first move in the window
+	for _, m := range moves {
+		if since, ok := measuredSince[m.ProductID]; !ok || m.At.Before(since) {
+			measuredSince[m.ProductID] = m.At
+		}
+		if m.Stage != stageToProduce {
+			continue
+		}
+		released[key{m.ProductID, m.QuantityUnit}] += m.Quantity
+		if m.At.After(lastRelease[m.KanbanID]) {
+			lastRelease[m.KanbanID] = m.At
+		}
+	}
+
+	index := map[key]int{}
+	coverage := []models.ProductCoverage{}
+	for _, k := range kanbans {
+		i, ok := index[key{k.ProductID, k.QuantityUnit}]
+		if !ok {
+			i = len(coverage)
+			index[key{k.ProductID, k.QuantityUnit}] = i
+			coverage = append(coverage, models.ProductCoverage{
+				ProductID: k.ProductID, ProductName: k.ProductName, QuantityUnit: k.QuantityUnit, InTransit: []models.IncomingKanban{},
+			})
+		}
+		c := &coverage[i]
+		if k.LeadtimeDays > c.LeadtimeDays {
+			c.LeadtimeDays = k.LeadtimeDays
+		}
+		switch k.Stage {
+		case stageAtCustomer:
+			c.FullContainers++
+			c.QuantityOnHand += k.Quantity
+		case stageInTransit:
+			releasedAt, ok := lastRelease[k.ID]
+			if !ok {
+				releasedAt = k.UpdatedAt // Released before the window, the last move is the best guess left
+			}
+			c.InTransit = append(c.InTransit, models.IncomingKanban{
+				KanbanID: k.ID, SupplierID: k.SupplierID, SupplierWorkCentreID: k.SupplierWorkCentreID, SupplierName: k.SupplierName, Quantity: k.Quantity,
+				ExpectedArrival: releasedAt.AddDate(0, 0, int(k.LeadtimeDays)),
+			})
+		}
+	}
+
+	for i := range coverage {
+		c := &coverage[i]
+		c.QuantityOnHand = roundQuantity(c.QuantityOnHand)
+		sort.Slice(c.InTransit, func(a, b int) bool { return c.InTransit[a].ExpectedArrival.Before(c.InTransit[b].ExpectedArrival) })
+		if len(c.InTransit) > 0 {
+			c.NextArrival = &c.InTransit[0].ExpectedArrival
+		}
+		if quantity := released[key{c.ProductID, c.QuantityUnit}]; quantity > 0 {
+			days := math.Max(now.Sub(measuredSince[c.ProductID]).Hours()/24, 1) // A young loop is measured over its own life
+			daily := math.Round(quantity/days*100) / 100
+			coverageDays := math.Round(c.QuantityOnHand/(quantity/days)*10) / 10
+			c.DailyConsumption, c.CoverageDays = &daily, &coverageDays
+		}
+		c.Risk = stockOutRisk(*c, now)
+	}
+
+	riskRank := map[string]int{riskRed: 0, riskAmber: 1, riskGreen: 2}
+	sort.SliceStable(coverage, func(i, j int) bool {
+		a, b := coverage[i], coverage[j]
+		if a.Risk != b.Risk {
+			return riskRank[a.Risk] < riskRank[b.Risk]
+		}
+		if (a.CoverageDays == nil) != (b.CoverageDays == nil) {
+			return a.CoverageDays != nil
+		}
+		if a.CoverageDays != nil && *a.CoverageDays != *b.CoverageDays {
+			return *a.CoverageDays < *b.CoverageDays
+		}
+		return a.ProductName < b.ProductName
+	})
+	return coverage
+}
+
+// stockOutRisk rates the coverage of a product: red when no full container is left or the stock runs out before
+// the next container arrives (one lead time from now when none is in transit), amber with less than one lead time
+// of coverage, green otherwise, including while no consumption has been measured yet
+func stockOutRisk(c models.ProductCoverage, now time.Time) string {
+	if c.FullContainers == 0 {
+		return riskRed
+	}
+	if c.CoverageDays == nil {
+		return riskGreen
+	}
+	runOut := now.Add(time.Duration(*c.CoverageDays * 24 * float64(time.Hour)))
+	nextArrival := now.AddDate(0, 0, int(c.LeadtimeDays))
+	if c.NextArrival != nil {
+		nextArrival = *c.NextArrival
+	}
+	if runOut.Before(nextArrival) {
+		return riskRed
+	}
+	if *c.CoverageDays < float64(c.LeadtimeDays) {
+		return riskAmber
+	}
+	return riskGreen
+}
+
 // Database interaction functions (private)
 
-// getKanbansForSupplierDashboard retrieves Kanban data for the supplier dashboard.
-func getKanbansForSupplierDashboard(db *sql.DB, supplierID int64) ([]map[string]interface{}, error) {
+// getCustomerKanbanMoves returns the status changes since since of the cards of the kanban chains with the
+// customer ownerID in ownerColumn, with their quantities in unit as dashboardQuantity converts them
+func getCustomerKanbanMoves(ctx context.Context, db dbtx, ownerColumn string, ownerID int64, since time.Time, unit string) ([]kanbanMove, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			h.kanban_id, kc.prodotto_codice, COALESCE(kc.fornitore_id, 0), kc.supplier_work_centre_id, kc.customer_site_id, kc.supplier_site_id,
+			k.quantity, kc.quantity_unit, p.unit_of_measure, p.net_weight_kg, k.status_chain_id, h.next_status, h.data_aggiornamento
+		FROM kanban_histories h
+		JOIN kanbans k ON h.kanban_id = k.id
+		JOIN kanban_chains kc ON k.kanban_chain_id = kc.id
+		JOIN products p ON kc.prodotto_codice = p.product_id
+		WHERE `+ownerColumn+` = $1 AND h.data_aggiornamento >= $2
+		ORDER BY h.data_aggiornamento`, ownerID, since)
+	if err != nil {
+		return nil, fmt.Errorf("error querying kanban moves for customer dashboard: %w", err)
+	}
+	defer rows.Close()
+
+	type move struct {
+		kanbanMove
+		statusChainID, nextStatus int64
+	}
+	var scanned []move
+	for rows.Next() {
+		var m move
+		var chainUnit string
+		var product models.Product
+		if err := rows.Scan(
+			&m.KanbanID, &m.ProductID, &m.SupplierID, &m.SupplierWorkCentreID, &m.CustomerSiteID, &m.SupplierSiteID,
+			&m.Quantity, &chainUnit, &product.UnitOfMeasure, &product.NetWeightKg, &m.statusChainID, &m.nextStatus, &m.At,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning kanban move for customer dashboard: %w", err)
+		}
+		m.Quantity, m.QuantityUnit = dashboardQuantity(m.Quantity, chainUnit, product, unit)
+		scanned = append(scanned, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating kanban moves for customer dashboard: %w", err)
+	}
+	rows.Close() // Release the connection before the stage lookups query again
+
+	stages := newStageLookup(db)
+	moves := make([]kanbanMove, 0, len(scanned))
+	for _, m := range scanned {
+		stage, err := stages.stage(ctx, m.statusChainID, m.nextStatus)
+		if err != nil {
+			return nil, err
+		}
+		m.Stage = stage
+		moves = append(moves, m.kanbanMove)
+	}
+	return moves, nil
+}
+
+// stageLookup tells the stage of the kanban loop of a status in a status chain, loading each chain once
+type stageLookup struct {
+	db     dbtx
+	stages map[int64]map[int64]string // status chain -> status -> stage
+}
+
+func newStageLookup(db dbtx) *stageLookup {
+	return &stageLookup{db: db, stages: map[int64]map[int64]string{}}
+}
+
+// stage returns the stage of statusID in statusChainID, "" when the chain doesn't have the status
+func (l *stageLookup) stage(ctx context.Context, statusChainID, statusID int64) (string, error) {
+	chainStages, ok := l.stages[statusChainID]
+	if !ok {
+		statuses, err := getStatusChainStatuses(ctx, l.db, statusChainID)
+		if err != nil {
+			return "", err
+		}
+		chainStages = map[int64]string{}
+		for _, s := range statuses {
+			chainStages[s.StatusID] = s.Stage
+		}
+		l.stages[statusChainID] = chainStages
+	}
+	return chainStages[statusID], nil
+}
+
+// setDashboardStages sets the stage of the kanban loop of each card, from the statuses of its status chain
+func setDashboardStages(ctx context.Context, db dbtx, kanbans []models.DashboardKanban) error {
+	stages := newStageLookup(db)
+	for i, k := range kanbans {
+		stage, err := stages.stage(ctx, k.StatusChainID, k.StatusCurrent)
+		if err != nil {
+			return err
+		}
+		kanbans[i].Stage = stage
+	}
+	return nil
+}
+
+// getKanbansForSupplierDashboard retrieves the active kanbans of the kanban chains with the supplier ownerID in
+// ownerColumn for the supplier dashboard, with their quantities in unit as dashboardQuantity converts them.
+func getKanbansForSupplierDashboard(ctx context.Context, db *sql.DB, ownerColumn string, ownerID int64, unit string) ([]models.DashboardKanban, error) {
 	query := `
 		SELECT
 			k.id AS kanban_id,
@@ -99,7 +481,21 @@ func getKanbansForSupplierDashboard(db *sql.DB, supplierID int64) ([]map[string]
 			s.color AS status_color,
 			scs.customer_supplier,
             k.status_current,
-			ac.name AS customer_name  -- ADD CUSTOMER NAME HERE - as per user request, but query was for supplier dashboard, so showing customer name here
+			COALESCE(kc.cliente_id, 0),
+			kc.customer_work_centre_id,
+			COALESCE(ac.name, cw.name) AS customer_name,  -- The customer account or work centre
+			kc.kanban_type,
+			kc.customer_site_id,
+			COALESCE(cs.name, '') AS customer_site_name,
+			kc.supplier_site_id,
+			COALESCE(ss.name, '') AS supplier_site_name,
+			k.data_aggiornamento,
+			k.status_chain_id,
+			k.leadtime_days,
+			k.data_aggiornamento + k.leadtime_days * INTERVAL '1 day' < NOW() AS overdue,
+			kc.quantity_unit,
+			p.unit_of_measure,
+			p.net_weight_kg
 		FROM
 			kanbans k
 		JOIN
@@ -110,63 +506,60 @@ func getKanbansForSupplierDashboard(db *sql.DB, supplierID int64) ([]map[string]
 			statuses s ON k.status_current = s.status_id
 		JOIN
 			status_chains_statuses scs ON k.status_chain_id = scs.status_chain_id AND k.status_current = scs.status_id
-		JOIN
+		LEFT JOIN
 			accounts ac ON kc.cliente_id = ac.id  -- JOIN with accounts table for customer name -  corrected JOIN for customer name for supplier dashboard
+		LEFT JOIN
+			work_centres cw ON kc.customer_work_centre_id = cw.id
+		LEFT JOIN
+			sites cs ON kc.customer_site_id = cs.id
+		LEFT JOIN
+			sites ss ON kc.supplier_site_id = ss.id
 		WHERE
-			kc.fornitore_id = $1  -- WHERE clause for supplier dashboard
+			` + ownerColumn + ` = $1  -- WHERE clause for supplier dashboard
+			AND k.is_active = true AND kc.archived_at IS NULL
 		ORDER BY
 			p.name, k.id;
 	`
 
-	rows, err := db.Query(query, supplierID)
+	rows, err := db.QueryContext(ctx, query, ownerID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying kanbans for supplier dashboard: %w", err)
 	}
 	defer rows.Close()
 
-	var kanbans []map[string]interface{}
+	kanbans := []models.DashboardKanban{}
 	for rows.Next() {
-		var kanbanID int64
-		var prodottoCodice string
-		var productName string
-		var tipoContenitore string
-		var quantity float64
-		var statusName string
-		var statusColor string
-		var customerSupplier int
-		var statusCurrent int64
-		var customerName string // Variable for customer name
-
+		var k models.DashboardKanban
+		var chainUnit string
+		var product models.Product
 		if err := rows.Scan(
-			&kanbanID, &prodottoCodice, &productName, &tipoContenitore, &quantity, &statusName, &statusColor, &customerSupplier,
-			&statusCurrent, &customerName, // Scan customerName here
+			&k.KanbanID, &k.ProdottoCodice, &k.ProductName, &k.TipoContenitore, &k.Quantity, &k.StatusName, &k.StatusColor, &k.CustomerSupplier,
+			&k.StatusCurrent, &k.CustomerID, &k.CustomerWorkCentreID, &k.CustomerName, &k.KanbanType,
+			&k.CustomerSiteID, &k.CustomerSiteName, &k.SupplierSiteID, &k.SupplierSiteName,
+			&k.UpdatedAt, &k.StatusChainID, &k.LeadtimeDays, &k.Overdue, &chainUnit, &product.UnitOfMeasure, &product.NetWeightKg,
 		); err != nil {
 			return nil, fmt.Errorf("error scanning kanban row for supplier dashboard: %w", err)
 		}
-
-		kanbans = append(kanbans, map[string]interface{}{
-			"kanban_id":         kanbanID,
-			"prodotto_codice":   prodottoCodice,
-			"product_name":      productName,
-			"tipo_contenitore":  tipoContenitore,
-			"quantity":          quantity,
-			"status_name":       statusName,
-			"status_color":      statusColor,
-			"customer_supplier": customerSupplier,
-			"status_current":    statusCurrent,
-			"customer_name":     customerName, // Add customer_name to the map
-		})
+		k.ID = k.KanbanID
+		k.ProductID = k.ProdottoCodice
+		k.ContainerType = k.TipoContenitore
+		k.Quantity, k.QuantityUnit = dashboardQuantity(k.Quantity, chainUnit, product, unit)
+		kanbans = append(kanbans, k)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating kanban rows for supplier dashboard: %w", err)
 	}
 
+	if err := setDashboardStages(ctx, db, kanbans); err != nil {
+		return nil, err
+	}
 	return kanbans, nil
 }
 
-// getKanbansForCustomerDashboard retrieves Kanban data for the customer dashboard.
-func getKanbansForCustomerDashboard(db *sql.DB, customerID int64) ([]map[string]interface{}, error) {
+// getKanbansForCustomerDashboard retrieves the active kanbans of the kanban chains with the customer ownerID in
+// ownerColumn for the customer dashboard, with their quantities in unit as dashboardQuantity converts them.
+func getKanbansForCustomerDashboard(ctx context.Context, db *sql.DB, ownerColumn string, ownerID int64, unit string) ([]models.DashboardKanban, error) {
 	query := `
 		SELECT
 			k.id AS kanban_id,
@@ -178,7 +571,21 @@ func getKanbansForCustomerDashboard(db *sql.DB, customerID int64) ([]map[string]
 			s.color AS status_color,
 			scs.customer_supplier,
             k.status_current,
-			ac.name AS supplier_name  -- ADD SUPPLIER NAME HERE
+			COALESCE(kc.fornitore_id, 0),
+			kc.supplier_work_centre_id,
+			COALESCE(ac.name, sw.name) AS supplier_name,  -- The supplier account or work centre
+			kc.kanban_type,
+			kc.customer_site_id,
+			COALESCE(cs.name, '') AS customer_site_name,
+			kc.supplier_site_id,
+			COALESCE(ss.name, '') AS supplier_site_name,
+			k.data_aggiornamento,
+			k.status_chain_id,
+			k.leadtime_days,
+			k.data_aggiornamento + k.leadtime_days * INTERVAL '1 day' < NOW() AS overdue,
+			kc.quantity_unit,
+			p.unit_of_measure,
+			p.net_weight_kg
 		FROM
 			kanbans k
 		JOIN
@@ -189,57 +596,53 @@ func getKanbansForCustomerDashboard(db *sql.DB, customerID int64) ([]map[string]
 			statuses s ON k.status_current = s.status_id
 		JOIN
 			status_chains_statuses scs ON k.status_chain_id = scs.status_chain_id AND k.status_current = scs.status_id
-		JOIN
+		LEFT JOIN
 			accounts ac ON kc.fornitore_id = ac.id  -- JOIN with accounts table for supplier name
+		LEFT JOIN
+			work_centres sw ON kc.supplier_work_centre_id = sw.id
+		LEFT JOIN
+			sites cs ON kc.customer_site_id = cs.id
+		LEFT JOIN
+			sites ss ON kc.supplier_site_id = ss.id
 		WHERE
-			kc.cliente_id = $1
+			` + ownerColumn + ` = $1
+			AND k.is_active = true AND kc.archived_at IS NULL
 		ORDER BY
 			p.name, k.id;
 	`
 
-	rows, err := db.Query(query, customerID)
+	rows, err := db.QueryContext(ctx, query, ownerID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying kanbans for customer dashboard: %w", err)
 	}
 	defer rows.Close()
 
-	var kanbans []map[string]interface{}
+	kanbans := []models.DashboardKanban{}
 	for rows.Next() {
-		var kanbanID int64
-		var prodottoCodice string
-		var productName string
-		var tipoContenitore string
-		var quantity float64
-		var statusName string
-		var statusColor string
-		var customerSupplier int
-		var statusCurrent int64
-		var supplierName string // Variable for supplier name
-
+		var k models.DashboardKanban
+		var chainUnit string
+		var product models.Product
 		if err := rows.Scan(
-			&kanbanID, &prodottoCodice, &productName, &tipoContenitore, &quantity, &statusName, &statusColor, &customerSupplier,
-			&statusCurrent, &supplierName, // Scan supplierName here
+			&k.KanbanID, &k.ProdottoCodice, &k.ProductName, &k.TipoContenitore, &k.Quantity, &k.StatusName, &k.StatusColor, &k.CustomerSupplier,
+			&k.StatusCurrent, &k.SupplierID, &k.SupplierWorkCentreID, &k.SupplierName, &k.KanbanType,
+			&k.CustomerSiteID, &k.CustomerSiteName, &k.SupplierSiteID, &k.SupplierSiteName,
+			&k.UpdatedAt, &k.StatusChainID, &k.LeadtimeDays, &k.Overdue, &chainUnit, &product.UnitOfMeasure, &product.NetWeightKg,
 		); err != nil {
 			return nil, fmt.Errorf("error scanning kanban row for customer dashboard: %w", err)
 		}
-
-		kanbans = append(kanbans, map[string]interface{}{
-			"kanban_id":         kanbanID,
-			"prodotto_codice":   prodottoCodice,
-			"product_name":      productName,
-			"tipo_contenitore":  tipoContenitore,
-			"quantity":          quantity,
-			"status_name":       statusName,
-			"status_color":      statusColor,
-			"customer_supplier": customerSupplier,
-			"status_current":    statusCurrent,
-			"supplier_name":     supplierName, // Add supplier_name to the map
-		})
+		k.ID = k.KanbanID
+		k.ProductID = k.ProdottoCodice
+		k.ContainerType = k.TipoContenitore
+		k.Quantity, k.QuantityUnit = dashboardQuantity(k.Quantity, chainUnit, product, unit)
+		kanbans = append(kanbans, k)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating kanban rows for customer dashboard: %w", err)
 	}
 
+	if err := setDashboardStages(ctx, db, kanbans); err != nil {
+		return nil, err
+	}
 	return kanbans, nil
 }
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so the same query helpers
+// can run standalone or as part of a larger transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"electronic_kanban_backend/models"
+
+	"github.com/lib/pq"
+)
+
+// Error codes returned in ErrorBody.Code. Clients branch on these, so never change an existing one.
+const (
+	codeInvalidRequest      = "invalid_request"       // Malformed body, parameter or path
+	codeValidationFailed    = "validation_failed"     // Well-formed request with invalid values, see details
+	codeNotFound            = "not_found"             // The record doesn't exist
+	codeMethodNotAllowed    = "method_not_allowed"    // The path exists, the method doesn't
+	codeConflict            = "conflict"              // Duplicate of an existing record, or a change the record's state doesn't allow
+	codeForeignKeyViolation = "foreign_key_violation" // References a missing record, or is still referenced
+	codeTimeout             = "timeout"               // A database query ran longer than the query timeout
+	codeUnauthorized        = "unauthorized"          // Missing or wrong wallboard token
+	codeForbidden           = "forbidden"             // The user belongs to another tenant than the one addressed
+	codeInternal            = "internal_error"
+)
+
+// statusClientClosedRequest is logged for requests whose client disconnected before the answer, as nginx does
+const statusClientClosedRequest = 499
+
+// PostgreSQL error codes mapped to API errors
+const (
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+	pqNotNullViolation    = "23502"
+	pqCheckViolation      = "23514"
+	pqInvalidText         = "22P02"
+	pqStringTooLong       = "22001"
+	pqNumericOutOfRange   = "22003"
+	pqQueryCanceled       = "57014"
+)
+
+// writeError sends the JSON error envelope
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	writeErrorDetails(w, r, status, code, message, nil)
+}
+
+// writeValidationError sends a 422 listing the invalid fields
+func writeValidationError(w http.ResponseWriter, r *http.Request, details []models.FieldError) {
+	writeErrorDetails(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "The request has invalid fields", details)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code string, message string, details []models.FieldError) {
+	id := requestID(r)
+	requestLogger(r).Info("request failed", "status", status, "code", code, "message", message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{Error: models.ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: id,
+		Details:   details,
+	}})
+}
+
+// conflictError is returned by database helpers refusing a change the current state of the data doesn't allow,
+// like archiving a record still in use. Its message is meant for the client.
+type conflictError struct {
+	message string
+}
+
+func (e *conflictError) Error() string {
+	return e.message
+}
+
+// writeDBError maps an error returned by a database helper to the matching API error:
+// sql.ErrNoRows is a 404, unique violations and conflictErrors a 409, foreign key and constraint violations a 422,
+// a query cancelled by the statement timeout a 503. Anything else is a 500 with fallbackMessage; the cause is only
+// written to the log. Nothing is sent when the client has gone away, which is what cancelled the query.
+func writeDBError(w http.ResponseWriter, r *http.Request, err error, fallbackMessage string) {
+	if r.Context().Err() != nil {
+		requestLogger(r).Info("request cancelled by the client", "error", err)
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "The requested record does not exist")
+		return
+	}
+
+	var conflict *conflictError
+	if errors.As(err, &conflict) {
+		writeError(w, r, http.StatusConflict, codeConflict, conflict.message)
+		return
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		field := pqErrorField(pqErr)
+		switch pqErr.Code {
+		case pqUniqueViolation:
+			writeErrorDetails(w, r, http.StatusConflict, codeConflict, "A record with the same "+field+" already exists",
+				fieldErrors(field, pqErr.Detail))
+			return
+		case pqForeignKeyViolation:
+			writeErrorDetails(w, r, http.StatusUnprocessableEntity, codeForeignKeyViolation, foreignKeyMessage(pqErr),
+				fieldErrors(field, pqErr.Detail))
+			return
+		case pqNotNullViolation, pqCheckViolation:
+			writeErrorDetails(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "The request has invalid fields",
+				fieldErrors(field, pqErr.Message))
+			return
+		case pqInvalidText, pqStringTooLong, pqNumericOutOfRange:
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, pqErr.Message)
+			return
+		case pqQueryCanceled:
+			requestLogger(r).Warn("query timed out", "error", err)
+			writeError(w, r, http.StatusServiceUnavailable, codeTimeout, "The database took too long to answer, try again later")
+			return
+		}
+	}
+
+	requestLogger(r).Error(fallbackMessage, "error", err)
+	writeError(w, r, http.StatusInternalServerError, codeInternal, fallbackMessage)
+}
+
+// pqKeyPattern extracts the column from details like `Key (vat_number)=(IT123) already exists.`
+var pqKeyPattern = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+// pqErrorField returns the column an integrity error is about, as best as PostgreSQL tells it
+func pqErrorField(pqErr *pq.Error) string {
+	if pqErr.Column != "" {
+		return pqErr.Column
+	}
+	if m := pqKeyPattern.FindStringSubmatch(pqErr.Detail); m != nil {
+		return strings.TrimPrefix(m[1], "tenant_id, ") // Keys are unique per tenant, which the client doesn't choose
+	}
+	if pqErr.Constraint != "" {
+		return pqErr.Constraint
+	}
+	return "key"
+}
+
+func foreignKeyMessage(pqErr *pq.Error) string {
+	if strings.Contains(pqErr.Detail, "is still referenced") {
+		return "The record is still referenced by other records"
+	}
+	return "The request references a record that does not exist"
+}
+
+func fieldErrors(field string, message string) []models.FieldError {
+	return []models.FieldError{{Field: field, Message: message}}
+}
+
+// NotFoundHandler answers requests for unknown paths with the JSON error envelope
+func NotFoundHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "No endpoint at "+r.URL.Path)
+	}
+}
+
+// MethodNotAllowedHandler answers requests with an unsupported method with the JSON error envelope
+func MethodNotAllowedHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path)
+	}
+}
+
+// execAffectingRows runs a statement and returns sql.ErrNoRows when it matched nothing,
+// so updates and deletes of missing records come back as 404.
+func execAffectingRows(ctx context.Context, db dbtx, query string, args ...interface{}) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// FeatureDisabledHandler answers the routes of a feature switched off in the configuration, with a 404
+// rather than the 405 the router would send for paths that also match a route with a path parameter
+func FeatureDisabledHandler(feature string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "The "+feature+" feature is disabled on this server")
+	}
+}
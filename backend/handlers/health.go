@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"electronic_kanban_backend/models"
+)
+
+// readyTimeout bounds the database ping of /readyz, so a stuck database fails the probe instead of hanging it
+const readyTimeout = 2 * time.Second
+
+// HealthzHandler returns a handler for GET /healthz, which succeeds as long as the process serves requests
+func HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.HealthResponse{Status: "ok"})
+	}
+}
+
+// ReadyzHandler returns a handler for GET /readyz, which succeeds when the database answers a ping
+// through the connection pool, and fails with 503 otherwise
+func ReadyzHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := db.PingContext(ctx); err != nil {
+			requestLogger(r).Warn("readiness check failed", "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(models.HealthResponse{Status: "unavailable", Database: "unreachable"})
+			return
+		}
+		json.NewEncoder(w).Encode(models.HealthResponse{Status: "ok", Database: "ok"})
+	}
+}
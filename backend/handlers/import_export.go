@@ -0,0 +1,716 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"electronic_kanban_backend/models"
+)
+
+// Column layouts shared by import and export, so an exported file can be re-imported unchanged
+var (
+	accountColumns = []string{"name", "vat_number", "address"}
+	productColumns = []string{
+		"product_id", "name", "unit_of_measure", "net_weight_kg", "gross_weight_kg", "customer_part_number",
+		"supplier_part_number", "default_container_type", "units_per_container",
+	}
+	kanbanChainColumns = []string{
+		"customer_vat_number", "product_id", "supplier_vat_number", "leadtime_days",
+		"quantity", "tipo_contenitore", "status_chain", "no_of_active_kanbans", "customer_site", "supplier_site",
+		"customer_work_centre", "supplier_work_centre", "kanban_type", "quantity_unit",
+	}
+)
+
+// importRowError describes why a single row of an import file was rejected
+type importRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// importResult is the response body of every import endpoint
+type importResult struct {
+	DryRun    bool             `json:"dry_run"`
+	Applied   bool             `json:"applied"`
+	TotalRows int              `json:"total_rows"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Errors    []importRowError `json:"errors"`
+}
+
+// importRowFunc validates and upserts a single row inside the import transaction.
+// It returns whether the row created a new record, or the validation errors for the row.
+type importRowFunc func(ctx context.Context, tx *sql.Tx, row map[string]string) (created bool, rowErrors []importRowError)
+
+// ImportAccountsHandler returns a handler for POST /api/accounts/import
+func ImportAccountsHandler(db *sql.DB) http.HandlerFunc {
+	return importHandler(db, "accounts", []string{"name", "vat_number"}, importAccountRow)
+}
+
+// ImportProductsHandler returns a handler for POST /api/products/import
+func ImportProductsHandler(db *sql.DB) http.HandlerFunc {
+	return importHandler(db, "products", []string{"product_id", "name"}, importProductRow)
+}
+
+// ImportKanbanChainsHandler returns a handler for POST /api/kanban-chains/import
+func ImportKanbanChainsHandler(db *sql.DB) http.HandlerFunc {
+	return importHandler(db, "kanban_chains", []string{
+		"customer_vat_number", "product_id", "supplier_vat_number", "leadtime_days", "quantity",
+	}, importKanbanChainRow)
+}
+
+// ExportAccountsHandler returns a handler for GET /api/accounts/export
+func ExportAccountsHandler(db *sql.DB) http.HandlerFunc {
+	return exportHandler(db, "accounts", exportAccounts)
+}
+
+// ExportProductsHandler returns a handler for GET /api/products/export
+func ExportProductsHandler(db *sql.DB) http.HandlerFunc {
+	return exportHandler(db, "products", exportProducts)
+}
+
+// ExportKanbanChainsHandler returns a handler for GET /api/kanban-chains/export
+func ExportKanbanChainsHandler(db *sql.DB) http.HandlerFunc {
+	return exportHandler(db, "kanban_chains", exportKanbanChains)
+}
+
+// importHandler parses the uploaded file and runs importFn for every row in a single transaction.
+// With ?dry_run=true, or when any row fails, the transaction is rolled back and nothing is written.
+// An applied import is recorded as a single entry for entity in the audit log.
+func importHandler(db *sql.DB, entity string, requiredColumns []string, importFn importRowFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+		t, err := readUploadedTable(w, r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		for _, column := range requiredColumns {
+			if !t.hasColumn(column) {
+				writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Missing required column %q", column))
+				return
+			}
+		}
+
+		result, err := runImport(db, r, entity, t, dryRun, importFn)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to import file")
+			return
+		}
+		requestLogger(r).Info("import", "entity", entity, "dry_run", result.DryRun, "applied", result.Applied,
+			"rows", result.TotalRows, "created", result.Created, "updated", result.Updated, "errors", len(result.Errors))
+
+		w.Header().Set("Content-Type", "application/json")
+		if !dryRun && !result.Applied {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// exportHandler writes the table built by exportFn as CSV or XLSX depending on ?format=
+func exportHandler(db *sql.DB, filename string, exportFn func(ctx context.Context, db *sql.DB) (*table, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := exportFormat(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+
+		t, err := exportFn(r.Context(), db)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to export "+strings.ReplaceAll(filename, "_", " "))
+			return
+		}
+
+		if err := writeTable(w, format, filename, t); err != nil {
+			requestLogger(r).Warn("writing export failed", "export", filename, "error", err)
+		}
+	}
+}
+
+// runImport applies every row inside one transaction, isolating rows with savepoints so
+// a failing row doesn't hide the errors of the following ones.
+func runImport(db *sql.DB, r *http.Request, entity string, t *table, dryRun bool, importFn importRowFunc) (*importResult, error) {
+	ctx := r.Context()
+	result := &importResult{DryRun: dryRun, TotalRows: len(t.Rows), Errors: []importRowError{}}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("runImport: error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, row := range t.Rows {
+		line := t.Lines[i]
+		values := make(map[string]string, len(t.Header))
+		for _, column := range t.Header {
+			values[column] = t.value(row, column)
+		}
+
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+			return nil, fmt.Errorf("runImport: error creating savepoint: %w", err)
+		}
+		created, rowErrors := importFn(ctx, tx, values)
+		if len(rowErrors) > 0 {
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); err != nil {
+				return nil, fmt.Errorf("runImport: error rolling back savepoint: %w", err)
+			}
+			for _, rowError := range rowErrors {
+				rowError.Row = line
+				result.Errors = append(result.Errors, rowError)
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row"); err != nil {
+			return nil, fmt.Errorf("runImport: error releasing savepoint: %w", err)
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil // Deferred rollback discards the changes
+	}
+	result.Applied = true
+	if err := recordAudit(tx, r, auditImport, entity, "", nil, result); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("runImport: error committing transaction: %w", err)
+	}
+	return result, nil
+}
+
+// Row importers
+
+func importAccountRow(ctx context.Context, tx *sql.Tx, row map[string]string) (bool, []importRowError) {
+	account := models.Account{Name: row["name"], VATNumber: row["vat_number"], Address: row["address"]}
+	rowErrors := importRowErrors(validateAccount(&account))
+	if account.VATNumber == "" {
+		rowErrors = append(rowErrors, importRowError{Field: "vat_number", Message: "vat_number is required"})
+	}
+	if len(rowErrors) > 0 {
+		return false, rowErrors
+	}
+
+	// A file without an address column must not blank the addresses already stored
+	_, hasAddress := row["address"]
+	sqlStatement := `
+		INSERT INTO accounts (name, vat_number, address)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id, vat_number) WHERE vat_number <> '' DO UPDATE
+		SET name = EXCLUDED.name,
+			address = CASE WHEN $4 THEN EXCLUDED.address ELSE accounts.address END
+		RETURNING (xmax = 0)`
+	var inserted bool
+	err := tx.QueryRowContext(ctx, sqlStatement, account.Name, account.VATNumber, account.Address, hasAddress).Scan(&inserted)
+	if err != nil {
+		return false, []importRowError{{Message: fmt.Sprintf("failed to save account: %v", err)}}
+	}
+	return inserted, nil
+}
+
+// importProductRow upserts a product keyed on product_id. The columns after name are optional: a file without
+// one of them leaves what is stored in it unchanged, and an empty unit_of_measure is pcs.
+func importProductRow(ctx context.Context, tx *sql.Tx, row map[string]string) (bool, []importRowError) {
+	product := models.Product{
+		ProductID:            row["product_id"],
+		Name:                 row["name"],
+		UnitOfMeasure:        row["unit_of_measure"],
+		CustomerPartNumber:   row["customer_part_number"],
+		SupplierPartNumber:   row["supplier_part_number"],
+		DefaultContainerType: row["default_container_type"],
+	}
+	var rowErrors []importRowError
+	for _, f := range []struct {
+		column string
+		value  **float64
+	}{
+		{"net_weight_kg", &product.NetWeightKg},
+		{"gross_weight_kg", &product.GrossWeightKg},
+		{"units_per_container", &product.UnitsPerContainer},
+	} {
+		value := strings.TrimSpace(row[f.column])
+		if value == "" {
+			continue
+		}
+		number, err := parseDecimal(value)
+		if err != nil {
+			rowErrors = append(rowErrors, importRowError{Field: f.column, Message: fmt.Sprintf("%s must be a number, got %q", f.column, value)})
+			continue
+		}
+		*f.value = &number
+	}
+	rowErrors = append(rowErrors, importRowErrors(validateProduct(&product))...)
+	if len(rowErrors) > 0 {
+		return false, rowErrors
+	}
+
+	set := "name = EXCLUDED.name"
+	for _, column := range productColumns[2:] {
+		if _, ok := row[column]; ok {
+			set += ", " + column + " = EXCLUDED." + column
+		}
+	}
+	sqlStatement := `
+		INSERT INTO products (
+			product_id, name, unit_of_measure, net_weight_kg, gross_weight_kg, customer_part_number, supplier_part_number,
+			default_container_type, units_per_container
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (tenant_id, product_id) DO UPDATE SET ` + set + `
+		RETURNING (xmax = 0)`
+	var inserted bool
+	err := tx.QueryRowContext(ctx, sqlStatement,
+		product.ProductID, product.Name, product.UnitOfMeasure, product.NetWeightKg, product.GrossWeightKg,
+		product.CustomerPartNumber, product.SupplierPartNumber, product.DefaultContainerType, product.UnitsPerContainer,
+	).Scan(&inserted)
+	if err != nil {
+		return false, []importRowError{{Message: fmt.Sprintf("failed to save product: %v", err)}}
+	}
+	if !inserted {
+		stored, err := getProductByID(ctx, tx, product.ProductID)
+		if err == nil {
+			err = refuseUnconvertibleChains(ctx, tx, *stored)
+		}
+		if err != nil {
+			return false, []importRowError{{Field: "unit_of_measure", Message: err.Error()}}
+		}
+	}
+	return inserted, nil
+}
+
+// importKanbanChainRow upserts a kanban chain keyed on customer VAT number, product and supplier VAT number,
+// and on the customer_site and supplier_site names when the file has those columns (empty for no site).
+// An internal customer or supplier is given by its code in customer_work_centre or supplier_work_centre, with
+// the VAT number left empty. kanban_type and quantity_unit, when empty, are unchanged for an existing chain;
+// a new chain takes the defaults of validateKanbanChain, as do an empty quantity and tipo_contenitore.
+// When no_of_active_kanbans grows, the missing cards are created in the first status of the chain.
+func importKanbanChainRow(ctx context.Context, tx *sql.Tx, row map[string]string) (bool, []importRowError) {
+	var rowErrors []importRowError
+	addError := func(field, format string, args ...interface{}) {
+		rowErrors = append(rowErrors, importRowError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	customerID, customerWorkCentreID, field, err := chainEndpointForImport(ctx, tx, row, "customer_vat_number", "customer_work_centre")
+	if err != nil {
+		addError(field, "%v", err)
+	}
+	supplierID, supplierWorkCentreID, field, err := chainEndpointForImport(ctx, tx, row, "supplier_vat_number", "supplier_work_centre")
+	if err != nil {
+		addError(field, "%v", err)
+	}
+
+	// A file without the site columns matches the chains whatever their sites, as before sites existed
+	_, hasCustomerSite := row["customer_site"]
+	_, hasSupplierSite := row["supplier_site"]
+	var customerSiteID, supplierSiteID *int64
+	if customerID != 0 {
+		if customerSiteID, err = siteIDByName(ctx, tx, customerID, row["customer_site"]); err != nil {
+			addError("customer_site", "%v", err)
+		}
+	}
+	if supplierID != 0 {
+		if supplierSiteID, err = siteIDByName(ctx, tx, supplierID, row["supplier_site"]); err != nil {
+			addError("supplier_site", "%v", err)
+		}
+	}
+	if customerWorkCentreID != nil && strings.TrimSpace(row["customer_site"]) != "" {
+		addError("customer_site", "only a customer account has sites")
+	}
+	if supplierWorkCentreID != nil && strings.TrimSpace(row["supplier_site"]) != "" {
+		addError("supplier_site", "only a supplier account has sites")
+	}
+
+	chain := models.KanbanChain{
+		ClienteID:            customerID,
+		ProdottoCodice:       strings.TrimSpace(row["product_id"]),
+		FornitoreID:          supplierID,
+		CustomerSiteID:       customerSiteID,
+		SupplierSiteID:       supplierSiteID,
+		CustomerWorkCentreID: customerWorkCentreID,
+		SupplierWorkCentreID: supplierWorkCentreID,
+		KanbanType:           strings.TrimSpace(row["kanban_type"]),
+		QuantityUnit:         row["quantity_unit"],
+		TipoContenitore:      row["tipo_contenitore"],
+		NoOfActiveKanbans:    -1, // Leaves the number of cards untouched
+	}
+	if value := strings.TrimSpace(row["leadtime_days"]); value != "" {
+		if chain.LeadtimeDays, err = strconv.ParseInt(value, 10, 64); err != nil {
+			addError("leadtime_days", "leadtime_days must be a whole number of days, got %q", value)
+		}
+	}
+	if value := strings.TrimSpace(row["quantity"]); value != "" {
+		if chain.Quantity, err = parseDecimal(value); err != nil {
+			addError("quantity", "quantity must be a number, got %q", value)
+		}
+	}
+	if value := row["no_of_active_kanbans"]; value != "" {
+		chain.NoOfActiveKanbans, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || chain.NoOfActiveKanbans < 0 {
+			addError("no_of_active_kanbans", "no_of_active_kanbans must be zero or a positive whole number, got %q", value)
+		}
+	}
+	if chain.StatusChainID, err = statusChainIDForImport(ctx, tx, row); err != nil {
+		addError("status_chain", "%v", err)
+	}
+
+	if len(rowErrors) > 0 {
+		return false, rowErrors
+	}
+
+	var existingID int64
+	var existingArchived bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, archived_at IS NOT NULL
+		FROM kanban_chains
+		WHERE COALESCE(cliente_id, 0) = $1 AND prodotto_codice = $2 AND COALESCE(fornitore_id, 0) = $3
+			AND (NOT $4 OR customer_site_id IS NOT DISTINCT FROM $5)
+			AND (NOT $6 OR supplier_site_id IS NOT DISTINCT FROM $7)
+			AND customer_work_centre_id IS NOT DISTINCT FROM $8 AND supplier_work_centre_id IS NOT DISTINCT FROM $9
+		ORDER BY id
+		LIMIT 1`, customerID, chain.ProdottoCodice, supplierID, hasCustomerSite, customerSiteID, hasSupplierSite, supplierSiteID,
+		customerWorkCentreID, supplierWorkCentreID).Scan(&existingID, &existingArchived)
+	if err != nil && err != sql.ErrNoRows {
+		return false, []importRowError{{Message: fmt.Sprintf("failed to look up kanban chain: %v", err)}}
+	}
+	if existingArchived {
+		return false, []importRowError{{Message: fmt.Sprintf("kanban chain %d is archived, restore it before importing it", existingID)}}
+	}
+	created := err == sql.ErrNoRows
+
+	var previous *models.KanbanChain
+	var existingActiveKanbans int64
+	if !created {
+		if previous, err = getKanbanChainForUpdate(ctx, tx, existingID); err != nil {
+			return false, []importRowError{{Message: fmt.Sprintf("failed to look up kanban chain: %v", err)}}
+		}
+		existingActiveKanbans = previous.NoOfActiveKanbans
+		// Empty columns leave the chain unchanged, and so do missing site columns
+		if chain.KanbanType == "" {
+			chain.KanbanType = previous.KanbanType
+		}
+		if strings.TrimSpace(chain.QuantityUnit) == "" {
+			chain.QuantityUnit = previous.QuantityUnit
+		}
+		if chain.StatusChainID == 0 {
+			chain.StatusChainID = previous.StatusChainID
+		}
+		if !hasCustomerSite {
+			chain.CustomerSiteID = previous.CustomerSiteID
+		}
+		if !hasSupplierSite {
+			chain.SupplierSiteID = previous.SupplierSiteID
+		}
+	}
+	if chain.NoOfActiveKanbans < 0 {
+		chain.NoOfActiveKanbans = existingActiveKanbans
+	}
+	fieldErrs, err := validateKanbanChain(ctx, tx, &chain)
+	if err != nil {
+		return false, []importRowError{{Message: fmt.Sprintf("failed to validate kanban chain: %v", err)}}
+	}
+	if len(fieldErrs) > 0 {
+		for i, e := range fieldErrs {
+			if column, ok := kanbanChainImportColumns[e.Field]; ok {
+				fieldErrs[i].Field = column
+			}
+		}
+		return false, importRowErrors(fieldErrs)
+	}
+	if chain.NoOfActiveKanbans < existingActiveKanbans {
+		return false, []importRowError{{
+			Field:   "no_of_active_kanbans",
+			Message: fmt.Sprintf("cannot reduce the chain from %d to %d cards by import, retire cards from the kanban list instead", existingActiveKanbans, chain.NoOfActiveKanbans),
+		}}
+	}
+
+	if created {
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO kanban_chains (
+				cliente_id, prodotto_codice, fornitore_id, leadtime_days,
+				quantity, tipo_contenitore, status_chain_id, no_of_active_kanbans,
+				customer_site_id, supplier_site_id, customer_work_centre_id, supplier_work_centre_id, kanban_type, quantity_unit
+			)
+			VALUES (NULLIF($1, 0), $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING id`,
+			chain.ClienteID, chain.ProdottoCodice, chain.FornitoreID, chain.LeadtimeDays, chain.Quantity, chain.TipoContenitore,
+			chain.StatusChainID, chain.NoOfActiveKanbans, chain.CustomerSiteID, chain.SupplierSiteID,
+			chain.CustomerWorkCentreID, chain.SupplierWorkCentreID, chain.KanbanType, chain.QuantityUnit,
+		).Scan(&chain.ID)
+	} else {
+		chain.ID = existingID
+		if err := refuseQuantityUnitChange(ctx, tx, previous, &chain, defaultPropagation); err != nil {
+			return false, []importRowError{{Field: "quantity_unit", Message: err.Error()}}
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE kanban_chains
+			SET leadtime_days = $2, quantity = $3, tipo_contenitore = $4, status_chain_id = $5, no_of_active_kanbans = $6,
+				kanban_type = $7, quantity_unit = $8
+			WHERE id = $1`,
+			chain.ID, chain.LeadtimeDays, chain.Quantity, chain.TipoContenitore, chain.StatusChainID, chain.NoOfActiveKanbans,
+			chain.KanbanType, chain.QuantityUnit,
+		)
+		if err == nil {
+			// Existing cards follow the chain with the default propagation policy
+			_, err = propagateKanbanChainChange(ctx, tx, previous, &chain, defaultPropagation, "")
+		}
+	}
+	if err != nil {
+		return false, []importRowError{{Message: fmt.Sprintf("failed to save kanban chain: %v", err)}}
+	}
+
+	if missing := chain.NoOfActiveKanbans - existingActiveKanbans; missing > 0 {
+		err = createInitialKanbans(ctx, tx, chain.ID, missing, chain.StatusChainID, chain.LeadtimeDays, chain.TipoContenitore, chain.Quantity)
+		if err != nil {
+			return false, []importRowError{{Field: "no_of_active_kanbans", Message: err.Error()}}
+		}
+	}
+	return created, nil
+}
+
+// kanbanChainImportColumns maps the fields of validateKanbanChain to the columns of a kanban chain import file
+var kanbanChainImportColumns = map[string]string{
+	"cliente_id":              "customer_vat_number",
+	"fornitore_id":            "supplier_vat_number",
+	"customer_work_centre_id": "customer_work_centre",
+	"supplier_work_centre_id": "supplier_work_centre",
+	"customer_site_id":        "customer_site",
+	"supplier_site_id":        "supplier_site",
+	"prodotto_codice":         "product_id",
+	"status_chain_id":         "status_chain",
+}
+
+// importRowErrors converts the field errors of a validator to row errors
+func importRowErrors(fieldErrs []models.FieldError) []importRowError {
+	var rowErrors []importRowError
+	for _, e := range fieldErrs {
+		rowErrors = append(rowErrors, importRowError{Field: e.Field, Message: e.Message})
+	}
+	return rowErrors
+}
+
+// accountIDByVATNumber resolves an account id from its VAT number, refusing archived accounts
+func accountIDByVATNumber(ctx context.Context, tx *sql.Tx, vatNumber string) (int64, error) {
+	vatNumber = normalizeVATNumber(vatNumber)
+	if vatNumber == "" {
+		return 0, fmt.Errorf("VAT number is required")
+	}
+	var id int64
+	var archived bool
+	err := tx.QueryRowContext(ctx, `SELECT id, archived_at IS NOT NULL FROM accounts WHERE vat_number = $1`, vatNumber).Scan(&id, &archived)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no account with VAT number %q", vatNumber)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up account: %w", err)
+	}
+	if archived {
+		return 0, fmt.Errorf("account with VAT number %q is archived", vatNumber)
+	}
+	return id, nil
+}
+
+// chainEndpointForImport resolves the customer or supplier of a kanban chain from the VAT number column or,
+// for an internal one, from the work centre column of an import row. It returns the column at fault with an error.
+func chainEndpointForImport(ctx context.Context, tx *sql.Tx, row map[string]string, vatColumn, workCentreColumn string) (accountID int64, workCentreID *int64, column string, err error) {
+	code := strings.TrimSpace(row[workCentreColumn])
+	if code == "" {
+		accountID, err = accountIDByVATNumber(ctx, tx, row[vatColumn])
+		return accountID, nil, vatColumn, err
+	}
+	if strings.TrimSpace(row[vatColumn]) != "" {
+		return 0, nil, workCentreColumn, fmt.Errorf("set either %s or %s, not both", vatColumn, workCentreColumn)
+	}
+	var id int64
+	var archived bool
+	err = tx.QueryRowContext(ctx, `SELECT id, archived_at IS NOT NULL FROM work_centres WHERE code = $1`, code).Scan(&id, &archived)
+	if err == sql.ErrNoRows {
+		return 0, nil, workCentreColumn, fmt.Errorf("no work centre with code %q", code)
+	}
+	if err != nil {
+		return 0, nil, workCentreColumn, fmt.Errorf("failed to look up work centre: %w", err)
+	}
+	if archived {
+		return 0, nil, workCentreColumn, fmt.Errorf("work centre %q is archived", code)
+	}
+	return 0, &id, workCentreColumn, nil
+}
+
+// siteIDByName resolves a site of an account from its name, refusing archived sites. It returns nil for an
+// empty name.
+func siteIDByName(ctx context.Context, tx *sql.Tx, accountID int64, name string) (*int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, nil
+	}
+	var id int64
+	var archived bool
+	err := tx.QueryRowContext(ctx, `SELECT id, archived_at IS NOT NULL FROM sites WHERE account_id = $1 AND name = $2`, accountID, name).Scan(&id, &archived)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("the account has no site %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up site: %w", err)
+	}
+	if archived {
+		return nil, fmt.Errorf("site %q is archived", name)
+	}
+	return &id, nil
+}
+
+// statusChainIDForImport resolves the status chain from the status_chain (name) or status_chain_id column.
+// It returns 0 when neither column is set.
+func statusChainIDForImport(ctx context.Context, tx *sql.Tx, row map[string]string) (int64, error) {
+	if idStr := row["status_chain_id"]; idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid status_chain_id %q", idStr)
+		}
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM status_chains WHERE status_chain_id = $1 AND archived_at IS NULL)`, id).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("failed to look up status chain: %w", err)
+		}
+		if !exists {
+			return 0, fmt.Errorf("unknown or archived status chain id %d", id)
+		}
+		return id, nil
+	}
+
+	name := row["status_chain"]
+	if name == "" {
+		return 0, nil
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT status_chain_id FROM status_chains WHERE name = $1 AND archived_at IS NULL`, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up status chain: %w", err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, fmt.Errorf("failed to look up status chain: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to look up status chain: %w", err)
+	}
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("unknown or archived status chain %q", name)
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("status chain name %q is ambiguous, use status_chain_id instead", name)
+}
+
+// parseDecimal parses a number accepting both '.' and ',' as decimal separator
+func parseDecimal(value string) (float64, error) {
+	if !strings.Contains(value, ".") {
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// Exporters
+
+func exportAccounts(ctx context.Context, db *sql.DB) (*table, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, vat_number, address FROM accounts WHERE archived_at IS NULL ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t := &table{Header: accountColumns}
+	for rows.Next() {
+		var name, vatNumber, address string
+		if err := rows.Scan(&name, &vatNumber, &address); err != nil {
+			return nil, err
+		}
+		t.Rows = append(t.Rows, []string{name, vatNumber, address})
+	}
+	return t, rows.Err()
+}
+
+func exportProducts(ctx context.Context, db *sql.DB) (*table, error) {
+	rows, err := db.QueryContext(ctx, `SELECT`+productSelect+` FROM products WHERE archived_at IS NULL ORDER BY product_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t := &table{Header: productColumns}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		t.Rows = append(t.Rows, []string{
+			p.ProductID, p.Name, p.UnitOfMeasure, formatOptionalDecimal(p.NetWeightKg), formatOptionalDecimal(p.GrossWeightKg),
+			p.CustomerPartNumber, p.SupplierPartNumber, p.DefaultContainerType, formatOptionalDecimal(p.UnitsPerContainer),
+		})
+	}
+	return t, rows.Err()
+}
+
+// formatOptionalDecimal formats a number for export, empty when there is none
+func formatOptionalDecimal(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+func exportKanbanChains(ctx context.Context, db *sql.DB) (*table, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			COALESCE(c.vat_number, ''), kc.prodotto_codice, COALESCE(s.vat_number, ''), kc.leadtime_days,
+			kc.quantity, kc.tipo_contenitore, sc.name, kc.no_of_active_kanbans, COALESCE(cs.name, ''), COALESCE(ss.name, ''),
+			COALESCE(cw.code, ''), COALESCE(sw.code, ''), kc.kanban_type, kc.quantity_unit
+		FROM kanban_chains kc
+		LEFT JOIN accounts c ON kc.cliente_id = c.id
+		LEFT JOIN accounts s ON kc.fornitore_id = s.id
+		LEFT JOIN work_centres cw ON kc.customer_work_centre_id = cw.id
+		LEFT JOIN work_centres sw ON kc.supplier_work_centre_id = sw.id
+		JOIN status_chains sc ON kc.status_chain_id = sc.status_chain_id
+		LEFT JOIN sites cs ON kc.customer_site_id = cs.id
+		LEFT JOIN sites ss ON kc.supplier_site_id = ss.id
+		WHERE kc.archived_at IS NULL
+		ORDER BY kc.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	t := &table{Header: kanbanChainColumns}
+	for rows.Next() {
+		var customerVAT, productID, supplierVAT, tipoContenitore, statusChainName, customerSite, supplierSite string
+		var customerWorkCentre, supplierWorkCentre, kanbanType, quantityUnit string
+		var leadtimeDays, noOfActiveKanbans int64
+		var quantity float64
+		if err := rows.Scan(
+			&customerVAT, &productID, &supplierVAT, &leadtimeDays, &quantity, &tipoContenitore, &statusChainName, &noOfActiveKanbans,
+			&customerSite, &supplierSite, &customerWorkCentre, &supplierWorkCentre, &kanbanType, &quantityUnit,
+		); err != nil {
+			return nil, err
+		}
+		t.Rows = append(t.Rows, []string{
+			customerVAT, productID, supplierVAT, strconv.FormatInt(leadtimeDays, 10),
+			strconv.FormatFloat(quantity, 'f', -1, 64), tipoContenitore, statusChainName, strconv.FormatInt(noOfActiveKanbans, 10),
+			customerSite, supplierSite, customerWorkCentre, supplierWorkCentre, kanbanType, quantityUnit,
+		})
+	}
+	return t, rows.Err()
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"electronic_kanban_backend/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Propagation policies of a kanban chain edit to the chain's existing kanbans
+const (
+	propagationImmediate = "immediate" // Every active card takes the new values at once
+	propagationOnReturn  = "on_return" // Each card takes them when it next returns to the first status of its chain
+	propagationNone      = "none"      // Only cards created from now on use the new values
+
+	defaultPropagation = propagationOnReturn // Cards in flight keep the container they were filled in
+)
+
+// parsePropagation validates a propagation policy, empty meaning the default
+func parsePropagation(value string) (string, error) {
+	switch value {
+	case "":
+		return defaultPropagation, nil
+	case propagationImmediate, propagationOnReturn, propagationNone:
+		return value, nil
+	}
+	return "", fmt.Errorf("propagation must be %s, %s or %s", propagationImmediate, propagationOnReturn, propagationNone)
+}
+
+// GetKanbanChainChangesHandler returns a handler for GET /api/kanban-chains/{id}/changes
+func GetKanbanChainChangesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		vars := mux.Vars(r)
+		id, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban chain ID")
+			return
+		}
+		if _, err := getKanbanChainByID(ctx, db, id); err != nil {
+			writeDBError(w, r, err, "Failed to fetch kanban chain")
+			return
+		}
+
+		changes, err := getKanbanChainChanges(ctx, db, id)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch kanban chain changes")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	}
+}
+
+// Database interaction functions (private)
+
+// getKanbanChainForUpdate reads a kanban chain and locks it until the end of tx
+func getKanbanChainForUpdate(ctx context.Context, tx dbtx, id int64) (*models.KanbanChain, error) {
+	return scanKanbanChain(tx.QueryRowContext(ctx, `SELECT`+kanbanChainSelect+` FROM kanban_chains WHERE id = $1 FOR UPDATE`, id))
+}
+
+// propagateKanbanChainChange records the edit of a kanban chain from before to after and applies it to the
+// chain's active kanbans according to policy. It does nothing when no card setting changed, and returns
+// the recorded change otherwise.
+func propagateKanbanChainChange(ctx context.Context, tx dbtx, before, after *models.KanbanChain, policy string, requestID string) (*models.KanbanChainChange, error) {
+	if before.LeadtimeDays == after.LeadtimeDays && before.Quantity == after.Quantity &&
+		before.TipoContenitore == after.TipoContenitore && before.StatusChainID == after.StatusChainID {
+		return nil, nil
+	}
+
+	change := models.KanbanChainChange{
+		KanbanChainID:      after.ID,
+		Propagation:        policy,
+		OldLeadtimeDays:    before.LeadtimeDays,
+		NewLeadtimeDays:    after.LeadtimeDays,
+		OldQuantity:        before.Quantity,
+		NewQuantity:        after.Quantity,
+		OldTipoContenitore: before.TipoContenitore,
+		NewTipoContenitore: after.TipoContenitore,
+		OldStatusChainID:   before.StatusChainID,
+		NewStatusChainID:   after.StatusChainID,
+		RequestID:          requestID,
+	}
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO kanban_chain_changes (
+			kanban_chain_id, propagation, old_leadtime_days, new_leadtime_days, old_quantity, new_quantity,
+			old_tipo_contenitore, new_tipo_contenitore, old_status_chain_id, new_status_chain_id, request_id
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, changed_at`,
+		change.KanbanChainID, change.Propagation, change.OldLeadtimeDays, change.NewLeadtimeDays, change.OldQuantity, change.NewQuantity,
+		change.OldTipoContenitore, change.NewTipoContenitore, change.OldStatusChainID, change.NewStatusChainID, change.RequestID,
+	).Scan(&change.ID, &change.ChangedAt)
+	if err != nil {
+		return nil, fmt.Errorf("propagateKanbanChainChange: error recording change: %w", err)
+	}
+
+	switch policy {
+	case propagationImmediate:
+		change.CardsUpdated, err = applyChainChangeToCards(ctx, tx, change, `k.kanban_chain_id = $1 AND k.is_active = true`, after.ID)
+	case propagationOnReturn:
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, `UPDATE kanbans SET pending_chain_change_id = $2 WHERE kanban_chain_id = $1 AND is_active = true`, after.ID, change.ID)
+		if err != nil {
+			break
+		}
+		var marked int64
+		if marked, err = res.RowsAffected(); err != nil {
+			break
+		}
+		// Cards waiting in the first status are back at the start of the loop already, so they switch now
+		change.CardsUpdated, err = applyChainChangeToCards(ctx, tx, change, `
+			k.kanban_chain_id = $1 AND k.is_active = true
+			AND k.status_current = (
+				SELECT scs.status_id FROM status_chains_statuses scs
+				WHERE scs.status_chain_id = k.status_chain_id
+				ORDER BY scs."order" ASC LIMIT 1
+			)`, after.ID)
+		change.CardsPending = marked - change.CardsUpdated
+	case propagationNone:
+		// This edit doesn't reach the cards; those waiting for an earlier on_return change still take it
+	}
+	if err != nil {
+		return nil, fmt.Errorf("propagateKanbanChainChange: error propagating change %d: %w", change.ID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE kanban_chain_changes SET cards_updated = $2 WHERE id = $1`, change.ID, change.CardsUpdated); err != nil {
+		return nil, fmt.Errorf("propagateKanbanChainChange: error recording propagation of change %d: %w", change.ID, err)
+	}
+	slog.Info("kanban chain change propagated", "kanban_chain_id", change.KanbanChainID, "change_id", change.ID,
+		"propagation", policy, "cards_updated", change.CardsUpdated, "cards_pending", change.CardsPending)
+	return &change, nil
+}
+
+// applyChainChangeToCards gives the kanbans matching where ($1 is the kanban chain id) the new values of change.
+// When the status chain changed, a card keeps its status if the new chain has it, otherwise it moves to the new
+// chain's first status. It returns the number of cards updated.
+func applyChainChangeToCards(ctx context.Context, tx dbtx, change models.KanbanChainChange, where string, args ...interface{}) (int64, error) {
+	n := len(args)
+	query := fmt.Sprintf(`
+		UPDATE kanbans k
+		SET leadtime_days = $%[1]d, quantity = $%[2]d, tipo_contenitore = $%[3]d,
+			status_current = CASE
+				WHEN k.status_chain_id = $%[4]d THEN k.status_current
+				WHEN EXISTS (
+					SELECT 1 FROM status_chains_statuses scs WHERE scs.status_chain_id = $%[4]d AND scs.status_id = k.status_current
+				) THEN k.status_current
+				ELSE (
+					SELECT scs.status_id FROM status_chains_statuses scs
+					WHERE scs.status_chain_id = $%[4]d
+					ORDER BY scs."order" ASC LIMIT 1
+				)
+			END,
+			status_chain_id = $%[4]d,
+			pending_chain_change_id = NULL
+		WHERE %[5]s`, n+1, n+2, n+3, n+4, where)
+	args = append(args, change.NewLeadtimeDays, change.NewQuantity, change.NewTipoContenitore, change.NewStatusChainID)
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// applyPendingChainChange gives a kanban that just returned to the first status of its chain the values of
+// the chain change it was waiting for, if any. It returns whether the card was updated.
+func applyPendingChainChange(ctx context.Context, tx dbtx, kanbanID int64) (bool, error) {
+	var change models.KanbanChainChange
+	err := tx.QueryRowContext(ctx, `
+		SELECT c.id, c.new_leadtime_days, c.new_quantity, c.new_tipo_contenitore, c.new_status_chain_id
+		FROM kanbans k
+		JOIN kanban_chain_changes c ON c.id = k.pending_chain_change_id
+		WHERE k.id = $1`, kanbanID).Scan(
+		&change.ID, &change.NewLeadtimeDays, &change.NewQuantity, &change.NewTipoContenitore, &change.NewStatusChainID,
+	)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("applyPendingChainChange: error reading pending change of kanban %d: %w", kanbanID, err)
+	}
+
+	// A card returning to the first status of its old chain starts the new chain from its first status
+	updated, err := applyChainChangeToCards(ctx, tx, change, `k.id = $1`, kanbanID)
+	if err != nil {
+		return false, fmt.Errorf("applyPendingChainChange: error applying change %d to kanban %d: %w", change.ID, kanbanID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE kanban_chain_changes SET cards_updated = cards_updated + $2 WHERE id = $1`, change.ID, updated); err != nil {
+		return false, fmt.Errorf("applyPendingChainChange: error counting kanban %d on change %d: %w", kanbanID, change.ID, err)
+	}
+	slog.Debug("kanban took a pending kanban chain change", "kanban_id", kanbanID, "change_id", change.ID)
+	return updated > 0, nil
+}
+
+// getKanbanChainChanges returns the changes of a kanban chain, newest first
+func getKanbanChainChanges(ctx context.Context, db dbtx, kanbanChainID int64) ([]models.KanbanChainChange, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			c.id, c.kanban_chain_id, c.changed_at, c.propagation,
+			c.old_leadtime_days, c.new_leadtime_days, c.old_quantity, c.new_quantity,
+			c.old_tipo_contenitore, c.new_tipo_contenitore, c.old_status_chain_id, c.new_status_chain_id,
+			c.cards_updated,
+			(SELECT COUNT(*) FROM kanbans k WHERE k.pending_chain_change_id = c.id AND k.is_active = true),
+			c.request_id
+		FROM kanban_chain_changes c
+		WHERE c.kanban_chain_id = $1
+		ORDER BY c.changed_at DESC, c.id DESC`, kanbanChainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.KanbanChainChange{}
+	for rows.Next() {
+		var c models.KanbanChainChange
+		if err := rows.Scan(
+			&c.ID, &c.KanbanChainID, &c.ChangedAt, &c.Propagation,
+			&c.OldLeadtimeDays, &c.NewLeadtimeDays, &c.OldQuantity, &c.NewQuantity,
+			&c.OldTipoContenitore, &c.NewTipoContenitore, &c.OldStatusChainID, &c.NewStatusChainID,
+			&c.CardsUpdated, &c.CardsPending, &c.RequestID,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -13,14 +14,44 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Kinds of kanban a chain circulates
+const (
+	kanbanTypeWithdrawal = "withdrawal" // Moves parts from the supplier's store to the customer
+	kanbanTypeProduction = "production" // Orders the supplying work centre to produce the parts
+)
+
+// kanbanChainRequest is the body of POST and PUT/PATCH /api/kanban-chains
+type kanbanChainRequest struct {
+	KanbanChain        models.KanbanChain `json:"kanban_chain"`
+	NoOfInitialKanbans int64              `json:"no_of_initial_kanbans"` // Cards to create in the first status of the chain
+	Propagation        string             `json:"propagation,omitempty"` // On update: immediate, on_return (default) or none
+}
+
 // GetKanbanChainsHandler returns a handler for GET /api/kanban-chains
 func GetKanbanChainsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		kanbanChains, err := getKanbanChains(db)
+		ctx := r.Context()
+		lq, err := parseListQuery(r, kanbanChainListSpec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		kanbanChains, err := getKanbanChains(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch kanban chains")
+			return
+		}
+		total, err := countRows(ctx, db, kanbanChainsFrom, lq, kanbanChainListSpec)
 		if err != nil {
-			http.Error(w, "Failed to fetch kanban chains", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to fetch kanban chains")
 			return
 		}
+		cursor, err := nextCursor(ctx, db, kanbanChainsFrom, lq, kanbanChainListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch kanban chains")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(kanbanChains)
 	}
@@ -29,343 +60,514 @@ func GetKanbanChainsHandler(db *sql.DB) http.HandlerFunc {
 // CreateKanbanChainHandler returns a handler for POST /api/kanban-chains
 func CreateKanbanChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("CreateKanbanChainHandler: Starting") // Log entry
+		ctx := r.Context()
 
-		var kanbanChainRequest struct { // Anonymous struct to handle request body
-			KanbanChain        models.KanbanChain `json:"kanban_chain"`
-			NoOfInitialKanbans int64              `json:"no_of_initial_kanbans"`
-		}
+		var kanbanChainRequest kanbanChainRequest
 
 		err := json.NewDecoder(r.Body).Decode(&kanbanChainRequest)
 		if err != nil {
-			log.Printf("CreateKanbanChainHandler: Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
 			return
 		}
-		log.Printf("CreateKanbanChainHandler: Request body decoded, Data: %+v", kanbanChainRequest)
-
-		newKanbanChain, err := createKanbanChain(db, kanbanChainRequest.KanbanChain)
+		fieldErrs, err := validateKanbanChain(ctx, db, &kanbanChainRequest.KanbanChain)
 		if err != nil {
-			log.Printf("CreateKanbanChainHandler: Failed to create kanban chain: %v", err)
-			http.Error(w, "Failed to create kanban chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to validate kanban chain")
+			return
+		}
+		if kanbanChainRequest.NoOfInitialKanbans < 0 {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: "no_of_initial_kanbans", Message: "no_of_initial_kanbans cannot be negative"})
+		}
+		if len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 
-		log.Printf("CreateKanbanChainHandler: Kanban chain created successfully, ID: %d", newKanbanChain.ID)
+		// The chain and its initial cards are created together, or not at all
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to create kanban chain")
+			return
+		}
+		defer tx.Rollback()
 
-		// Create initial kanbans based on no_of_active_kanbans
-		if kanbanChainRequest.NoOfInitialKanbans > 0 {
-			err = createInitialKanbans(db, newKanbanChain.ID, kanbanChainRequest.NoOfInitialKanbans, newKanbanChain.StatusChainID, newKanbanChain.LeadtimeDays, newKanbanChain.TipoContenitore, newKanbanChain.Quantity)
-			if err != nil {
-				// Consider logging the error and perhaps rolling back the kanban chain creation in a transaction for full rollback.
-				http.Error(w, "Failed to create initial kanbans for the chain", http.StatusInternalServerError)
-				log.Printf("CreateKanbanChainHandler: Failed to create initial kanbans for the chain: %v", err)
-				return
-			}
-			log.Println("CreateKanbanChainHandler: initial kanbans created successfully")
+		newKanbanChain, err := createKanbanChainWithKanbans(ctx, tx, kanbanChainRequest.KanbanChain, kanbanChainRequest.NoOfInitialKanbans)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to create kanban chain")
+			return
+		}
+		if err := recordAudit(tx, r, auditCreate, "kanban_chain", newKanbanChain.ID, nil, newKanbanChain); err != nil {
+			writeDBError(w, r, err, "Failed to create kanban chain")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeDBError(w, r, err, "Failed to create kanban chain")
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(newKanbanChain)
-		log.Println("CreateKanbanChainHandler: Finished successfully")
+		json.NewEncoder(w).Encode(models.NewKanbanChainResponse(*newKanbanChain))
 	}
 }
 
 // GetKanbanChainHandler returns a handler for GET /api/kanban-chains/{id}
 func GetKanbanChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Kanban Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Kanban Chain ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid kanban chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban chain ID")
 			return
 		}
 
-		kanbanChain, err := getKanbanChainByID(db, id)
+		kanbanChain, err := getKanbanChainByID(ctx, db, id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				http.NotFound(w, r)
-			} else {
-				http.Error(w, "Failed to fetch kanban chain", http.StatusInternalServerError)
-			}
+			writeDBError(w, r, err, "Failed to fetch kanban chain")
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(kanbanChain)
+		json.NewEncoder(w).Encode(models.NewKanbanChainResponse(*kanbanChain))
 	}
 }
 
 // UpdateKanbanChainHandler returns a handler for PUT/PATCH /api/kanban-chains/{id}
 func UpdateKanbanChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Kanban Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Kanban Chain ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid kanban chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban chain ID")
 			return
 		}
 
-		var kanbanChainRequest struct {
-			KanbanChain        models.KanbanChain `json:"kanban_chain"`
-			NoOfInitialKanbans int64              `json:"no_of_initial_kanbans"`
-		}
+		var kanbanChainRequest kanbanChainRequest
 
 		err = json.NewDecoder(r.Body).Decode(&kanbanChainRequest) // Decode the data once
 		if err != nil {
-			log.Printf("UpdateKanbanChainHandler: Error reading request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		fieldErrs, err := validateKanbanChain(ctx, db, &kanbanChainRequest.KanbanChain)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to validate kanban chain")
+			return
+		}
+		if kanbanChainRequest.NoOfInitialKanbans < 0 {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: "no_of_initial_kanbans", Message: "no_of_initial_kanbans cannot be negative"})
+		}
+		propagation, err := parsePropagation(kanbanChainRequest.Propagation)
+		if err != nil {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: "propagation", Message: err.Error()})
+		}
+		if len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
-		log.Printf("UpdateKanbanChainHandler: Request body decoded, Data: %+v", kanbanChainRequest)
 
 		kanbanChainUpdates := kanbanChainRequest.KanbanChain
 		kanbanChainUpdates.ID = id // Ensure ID from URL is used
-		log.Printf("UpdateKanbanChainHandler: Updating kanban chain: %+v", kanbanChainUpdates)
 
-		updatedKanbanChain, err := updateKanbanChain(db, kanbanChainUpdates)
+		tx, err := db.BeginTx(ctx, nil)
 		if err != nil {
-			log.Printf("UpdateKanbanChainHandler: Failed to update kanban chain: %v", err)
-			http.Error(w, "Failed to update kanban chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update kanban chain")
 			return
 		}
+		defer tx.Rollback()
 
-		log.Printf("UpdateKanbanChainHandler: Kanban chain updated successfully with data: %+v", updatedKanbanChain)
-
+		previousKanbanChain, err := getKanbanChainForUpdate(ctx, tx, id)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to update kanban chain")
+			return
+		}
+		// An archived chain has no cards in flight and must not get new ones
+		if previousKanbanChain.ArchivedAt != nil {
+			writeDBError(w, r, &conflictError{message: fmt.Sprintf("Kanban chain %d is archived, restore it before editing it", id)}, "Failed to update kanban chain")
+			return
+		}
+		updatedKanbanChain, err := updateKanbanChain(ctx, tx, kanbanChainUpdates)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to update kanban chain")
+			return
+		}
+		if err := refuseQuantityUnitChange(ctx, tx, previousKanbanChain, updatedKanbanChain, propagation); err != nil {
+			writeDBError(w, r, err, "Failed to update kanban chain")
+			return
+		}
+		// Existing cards follow the chain according to the propagation policy
+		if _, err := propagateKanbanChainChange(ctx, tx, previousKanbanChain, updatedKanbanChain, propagation, requestID(r)); err != nil {
+			writeDBError(w, r, err, "Failed to update the kanbans of the chain")
+			return
+		}
 		if kanbanChainRequest.NoOfInitialKanbans > 0 {
-			err = createInitialKanbans(db, updatedKanbanChain.ID, kanbanChainRequest.NoOfInitialKanbans, updatedKanbanChain.StatusChainID, updatedKanbanChain.LeadtimeDays, updatedKanbanChain.TipoContenitore, updatedKanbanChain.Quantity)
+			err = createInitialKanbans(ctx, tx, updatedKanbanChain.ID, kanbanChainRequest.NoOfInitialKanbans, updatedKanbanChain.StatusChainID, updatedKanbanChain.LeadtimeDays, updatedKanbanChain.TipoContenitore, updatedKanbanChain.Quantity)
 			if err != nil {
-				log.Printf("UpdateKanbanChainHandler: Error creating additional kanbans: %v", err)
-				http.Error(w, "Failed to create initial kanbans for the chain", http.StatusInternalServerError)
+				writeDBError(w, r, err, "Failed to create initial kanbans for the chain")
 				return
 			}
-			log.Println("UpdateKanbanChainHandler: initial kanbans created successfully")
-
-			// Retrieve the updated kanban chain to get the correct no_of_active_kanbans count
-			updatedKanbanChain, err = getKanbanChainByID(db, id)
-			if err != nil {
-				log.Printf("UpdateKanbanChainHandler: Error re-fetching kanban chain after kanban creation: %v", err)
-				// Not critical, but log the error
-			}
+		}
+		if updatedKanbanChain.NoOfActiveKanbans, err = syncNoOfActiveKanbans(ctx, tx, id); err != nil {
+			writeDBError(w, r, err, "Failed to update kanban chain")
+			return
+		}
+		if err := recordAudit(tx, r, auditUpdate, "kanban_chain", id, previousKanbanChain, updatedKanbanChain); err != nil {
+			writeDBError(w, r, err, "Failed to update kanban chain")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeDBError(w, r, err, "Failed to update kanban chain")
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(updatedKanbanChain)
-		log.Println("UpdateKanbanChainHandler: Finished successfully")
+		json.NewEncoder(w).Encode(models.NewKanbanChainResponse(*updatedKanbanChain))
 
 	}
 }
 
-// DeleteKanbanChainHandler returns a handler for DELETE /api/kanban-chains/{id}
+// DeleteKanbanChainHandler returns a handler for DELETE /api/kanban-chains/{id}, which archives the kanban chain
 func DeleteKanbanChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Kanban Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Kanban Chain ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid kanban chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban chain ID")
 			return
 		}
 
-		err = deleteKanbanChain(db, id)
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousKanbanChain, err := getKanbanChainForUpdate(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := archiveKanbanChain(ctx, tx, id); err != nil {
+				return err
+			}
+			archivedKanbanChain, err := getKanbanChainByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditArchive, "kanban_chain", id, previousKanbanChain, archivedKanbanChain)
+		})
 		if err != nil {
-			http.Error(w, "Failed to delete kanban chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to archive kanban chain")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK) // Respond with 200 OK
-		json.NewEncoder(w).Encode(map[string]string{"message": "Kanban chain deleted"})
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Kanban chain archived"})
+	}
+}
+
+// RestoreKanbanChainHandler returns a handler for POST /api/kanban-chains/{id}/restore
+func RestoreKanbanChainHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban chain ID")
+			return
+		}
+
+		var kanbanChain *models.KanbanChain
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousKanbanChain, err := getKanbanChainForUpdate(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if kanbanChain, err = restoreKanbanChain(ctx, tx, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditRestore, "kanban_chain", id, previousKanbanChain, kanbanChain)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to restore kanban chain")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.NewKanbanChainResponse(*kanbanChain))
 	}
 }
 
 // Database interaction functions (private)
 
-func getKanbanChains(db *sql.DB) ([]map[string]interface{}, error) {
-	rows, err := db.Query(`
+// kanbanChainListSpec defines what GET /api/kanban-chains can sort, filter and search on
+var kanbanChainListSpec = listSpec{
+	Fields: map[string]listField{
+		"id":                      {Column: "kc.id", Kind: fieldInt},
+		"cliente_id":              {Column: "kc.cliente_id", Kind: fieldInt},
+		"customer_name":           {Column: "COALESCE(c.name, cw.name)", Kind: fieldText},
+		"prodotto_codice":         {Column: "kc.prodotto_codice", Kind: fieldText},
+		"product_name":            {Column: "p.name", Kind: fieldText},
+		"fornitore_id":            {Column: "kc.fornitore_id", Kind: fieldInt},
+		"supplier_name":           {Column: "COALESCE(s.name, sw.name)", Kind: fieldText},
+		"customer_site_id":        {Column: "kc.customer_site_id", Kind: fieldInt},
+		"customer_site_name":      {Column: "cs.name", Kind: fieldText},
+		"supplier_site_id":        {Column: "kc.supplier_site_id", Kind: fieldInt},
+		"supplier_site_name":      {Column: "ss.name", Kind: fieldText},
+		"customer_work_centre_id": {Column: "kc.customer_work_centre_id", Kind: fieldInt},
+		"supplier_work_centre_id": {Column: "kc.supplier_work_centre_id", Kind: fieldInt},
+		"kanban_type":             {Column: "kc.kanban_type", Kind: fieldText},
+		"leadtime_days":           {Column: "kc.leadtime_days", Kind: fieldInt},
+		"quantity":                {Column: "kc.quantity", Kind: fieldFloat},
+		"quantity_unit":           {Column: "kc.quantity_unit", Kind: fieldText},
+		"tipo_contenitore":        {Column: "kc.tipo_contenitore", Kind: fieldText},
+		"status_chain_id":         {Column: "kc.status_chain_id", Kind: fieldInt},
+		"no_of_active_kanbans":    {Column: "kc.no_of_active_kanbans", Kind: fieldInt},
+	},
+	Search:      []string{"c.name", "s.name", "cw.name", "sw.name", "cs.name", "ss.name", "p.name", "kc.prodotto_codice", "kc.tipo_contenitore"},
+	DefaultSort: "id",
+	TieBreaker:  "kc.id",
+	Archived:    "kc.archived_at",
+}
+
+const kanbanChainsFrom = `
+		FROM kanban_chains kc
+		LEFT JOIN accounts c ON kc.cliente_id = c.id
+		LEFT JOIN work_centres cw ON kc.customer_work_centre_id = cw.id
+		JOIN products p ON kc.prodotto_codice = p.product_id
+		LEFT JOIN accounts s ON kc.fornitore_id = s.id
+		LEFT JOIN work_centres sw ON kc.supplier_work_centre_id = sw.id
+		LEFT JOIN sites cs ON kc.customer_site_id = cs.id
+		LEFT JOIN sites ss ON kc.supplier_site_id = ss.id
+		WHERE TRUE`
+
+func getKanbanChains(ctx context.Context, db *sql.DB, lq *listQuery) ([]models.KanbanChainListItem, error) {
+	var args []interface{}
+	rows, err := db.QueryContext(ctx, `
 		SELECT
 			kc.id,
-			c.name AS customer_name,
-			kc.cliente_id,
+			COALESCE(c.name, cw.name) AS customer_name,
+			COALESCE(kc.cliente_id, 0),
 			p.name AS product_name,
-            kc.prodotto_codice,
-			s.name AS supplier_name,
-			kc.fornitore_id,
+			kc.prodotto_codice,
+			COALESCE(s.name, sw.name) AS supplier_name,
+			COALESCE(kc.fornitore_id, 0),
+			kc.customer_site_id,
+			COALESCE(cs.name, '') AS customer_site_name,
+			kc.supplier_site_id,
+			COALESCE(ss.name, '') AS supplier_site_name,
+			kc.customer_work_centre_id,
+			kc.supplier_work_centre_id,
+			kc.kanban_type,
 			kc.leadtime_days,
 			kc.quantity,
+			kc.quantity_unit,
 			kc.tipo_contenitore,
 			kc.status_chain_id,
-			kc.no_of_active_kanbans
-		FROM kanban_chains kc
-		JOIN accounts c ON kc.cliente_id = c.id
-		JOIN products p ON kc.prodotto_codice = p.product_id
-        JOIN accounts s ON kc.fornitore_id = s.id
-	`)
+			kc.no_of_active_kanbans,
+			kc.archived_at`+kanbanChainsFrom+
+		lq.where(kanbanChainListSpec, &args)+lq.after(kanbanChainListSpec, &args)+lq.orderBy(kanbanChainListSpec)+lq.page(), args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var kanbanChains []map[string]interface{}
+	kanbanChains := []models.KanbanChainListItem{}
 	for rows.Next() {
-		var kcID int64
-		var customerName string
-		var clienteID int64
-		var productName string
-		var prodottoCodice string
-		var supplierName string
-		var fornitoreID int64
-		var leadtimeDays int64
-		var quantity float64
-		var tipoContenitore string
-		var statusChainID int64
-		var noOfActiveKanbans int64
-
+		var kc models.KanbanChain
+		var item models.KanbanChainListItem
 		if err := rows.Scan(
-			&kcID, &customerName, &clienteID, &productName, &prodottoCodice, &supplierName, &fornitoreID,
-			&leadtimeDays, &quantity, &tipoContenitore, &statusChainID, &noOfActiveKanbans,
+			&kc.ID, &item.CustomerName, &kc.ClienteID, &item.ProductName, &kc.ProdottoCodice, &item.SupplierName, &kc.FornitoreID,
+			&kc.CustomerSiteID, &item.CustomerSiteName, &kc.SupplierSiteID, &item.SupplierSiteName,
+			&kc.CustomerWorkCentreID, &kc.SupplierWorkCentreID, &kc.KanbanType,
+			&kc.LeadtimeDays, &kc.Quantity, &kc.QuantityUnit, &kc.TipoContenitore, &kc.StatusChainID, &kc.NoOfActiveKanbans, &kc.ArchivedAt,
 		); err != nil {
 			return nil, err
 		}
-		kanbanChains = append(kanbanChains, map[string]interface{}{
-			"id":                   kcID,
-			"customer_name":        customerName,
-			"cliente_id":           clienteID,
-			"product_name":         productName,
-			"prodotto_codice":      prodottoCodice,
-			"supplier_name":        supplierName,
-			"fornitore_id":         fornitoreID,
-			"leadtime_days":        leadtimeDays,
-			"quantity":             quantity,
-			"tipo_contenitore":     tipoContenitore,
-			"status_chain_id":      statusChainID,
-			"no_of_active_kanbans": noOfActiveKanbans,
-		})
+		item.KanbanChainResponse = models.NewKanbanChainResponse(kc)
+		kanbanChains = append(kanbanChains, item)
 	}
-	return kanbanChains, nil
+	return kanbanChains, rows.Err()
 }
 
-func createKanbanChain(db *sql.DB, kc models.KanbanChain) (*models.KanbanChain, error) {
-	log.Printf("createKanbanChain: Starting with data: %+v", kc)
+// kanbanChainSelect lists the columns of a kanban chain, as read by scanKanbanChain. An endpoint that is a work
+// centre reads as account 0.
+const kanbanChainSelect = `
+	id, COALESCE(cliente_id, 0), prodotto_codice, COALESCE(fornitore_id, 0), customer_site_id, supplier_site_id,
+	customer_work_centre_id, supplier_work_centre_id, kanban_type, leadtime_days,
+	quantity, quantity_unit, tipo_contenitore, status_chain_id, no_of_active_kanbans, archived_at`
+
+// scanKanbanChain reads a row of kanbanChainSelect
+func scanKanbanChain(row interface{ Scan(...interface{}) error }) (*models.KanbanChain, error) {
+	var kc models.KanbanChain
+	err := row.Scan(
+		&kc.ID, &kc.ClienteID, &kc.ProdottoCodice, &kc.FornitoreID, &kc.CustomerSiteID, &kc.SupplierSiteID,
+		&kc.CustomerWorkCentreID, &kc.SupplierWorkCentreID, &kc.KanbanType, &kc.LeadtimeDays,
+		&kc.Quantity, &kc.QuantityUnit, &kc.TipoContenitore, &kc.StatusChainID, &kc.NoOfActiveKanbans, &kc.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &kc, nil
+}
+
+func createKanbanChain(ctx context.Context, db dbtx, kc models.KanbanChain) (*models.KanbanChain, error) {
 	sqlStatement := `
 		INSERT INTO kanban_chains (
 			cliente_id, prodotto_codice, fornitore_id, leadtime_days,
-			quantity, tipo_contenitore, status_chain_id, no_of_active_kanbans
+			quantity, tipo_contenitore, status_chain_id, no_of_active_kanbans,
+			customer_site_id, supplier_site_id, customer_work_centre_id, supplier_work_centre_id, kanban_type, quantity_unit
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING
-			id, cliente_id, prodotto_codice, fornitore_id, leadtime_days,
-			quantity, tipo_contenitore, status_chain_id, no_of_active_kanbans
-	`
-	log.Printf("createKanbanChain: SQL Query: %s, Parameters: [%d, %s, %d, %d, %f, %s, %d, %d]", sqlStatement, kc.ClienteID, kc.ProdottoCodice, kc.FornitoreID, kc.LeadtimeDays, kc.Quantity, kc.TipoContenitore, kc.StatusChainID, kc.NoOfActiveKanbans)
-	var newKC models.KanbanChain
-	err := db.QueryRow(sqlStatement,
+		VALUES (NULLIF($1, 0), $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING` + kanbanChainSelect
+	return scanKanbanChain(db.QueryRowContext(ctx, sqlStatement,
 		kc.ClienteID, kc.ProdottoCodice, kc.FornitoreID, kc.LeadtimeDays,
 		kc.Quantity, kc.TipoContenitore, kc.StatusChainID, kc.NoOfActiveKanbans,
-	).Scan(
-		&newKC.ID, &newKC.ClienteID, &newKC.ProdottoCodice, &newKC.FornitoreID, &newKC.LeadtimeDays,
-		&newKC.Quantity, &newKC.TipoContenitore, &newKC.StatusChainID, &newKC.NoOfActiveKanbans,
-	)
-	if err != nil {
-		log.Printf("createKanbanChain: Error executing query: %v", err)
-		return nil, err
-	}
-	log.Printf("createKanbanChain: Kanban chain created successfully with data: %+v", newKC)
-
-	return &newKC, nil
+		kc.CustomerSiteID, kc.SupplierSiteID, kc.CustomerWorkCentreID, kc.SupplierWorkCentreID, kc.KanbanType, kc.QuantityUnit,
+	))
 }
 
-func getKanbanChainByID(db *sql.DB, id int64) (*models.KanbanChain, error) {
-	sqlStatement := `
-		SELECT
-			id, cliente_id, prodotto_codice, fornitore_id, leadtime_days,
-			quantity, tipo_contenitore, status_chain_id, no_of_active_kanbans
-		FROM kanban_chains
-		WHERE id = $1
-	`
-	var kc models.KanbanChain
-	err := db.QueryRow(sqlStatement, id).Scan(
-		&kc.ID, &kc.ClienteID, &kc.ProdottoCodice, &kc.FornitoreID, &kc.LeadtimeDays,
-		&kc.Quantity, &kc.TipoContenitore, &kc.StatusChainID, &kc.NoOfActiveKanbans,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &kc, nil
+func getKanbanChainByID(ctx context.Context, db dbtx, id int64) (*models.KanbanChain, error) {
+	return scanKanbanChain(db.QueryRowContext(ctx, `SELECT`+kanbanChainSelect+` FROM kanban_chains WHERE id = $1`, id))
 }
 
-func updateKanbanChain(db *sql.DB, kc models.KanbanChain) (*models.KanbanChain, error) {
-	log.Printf("updateKanbanChain: Starting with data: %+v", kc)
+func updateKanbanChain(ctx context.Context, db dbtx, kc models.KanbanChain) (*models.KanbanChain, error) {
 	sqlStatement := `
 		UPDATE kanban_chains
 		SET
-			cliente_id = $2, prodotto_codice = $3, fornitore_id = $4, leadtime_days = $5,
-			quantity = $6, tipo_contenitore = $7, status_chain_id = $8, no_of_active_kanbans = $9
+			cliente_id = NULLIF($2, 0), prodotto_codice = $3, fornitore_id = NULLIF($4, 0), leadtime_days = $5,
+			quantity = $6, tipo_contenitore = $7, status_chain_id = $8, no_of_active_kanbans = $9,
+			customer_site_id = $10, supplier_site_id = $11,
+			customer_work_centre_id = $12, supplier_work_centre_id = $13, kanban_type = $14, quantity_unit = $15
 		WHERE id = $1
-		RETURNING
-			id, cliente_id, prodotto_codice, fornitore_id, leadtime_days,
-			quantity, tipo_contenitore, status_chain_id, no_of_active_kanbans
-	`
-	log.Printf("updateKanbanChain: SQL Query: %s, Parameters: [%d, %d, %s, %d, %d, %f, %s, %d, %d]", sqlStatement, kc.ID, kc.ClienteID, kc.ProdottoCodice, kc.FornitoreID, kc.LeadtimeDays, kc.Quantity, kc.TipoContenitore, kc.StatusChainID, kc.NoOfActiveKanbans)
-	var updatedKC models.KanbanChain
-	err := db.QueryRow(sqlStatement,
+		RETURNING` + kanbanChainSelect
+	return scanKanbanChain(db.QueryRowContext(ctx, sqlStatement,
 		kc.ID, kc.ClienteID, kc.ProdottoCodice, kc.FornitoreID, kc.LeadtimeDays,
 		kc.Quantity, kc.TipoContenitore, kc.StatusChainID, kc.NoOfActiveKanbans,
-	).Scan(
-		&updatedKC.ID, &updatedKC.ClienteID, &updatedKC.ProdottoCodice, &updatedKC.FornitoreID, &updatedKC.LeadtimeDays,
-		&updatedKC.Quantity, &updatedKC.TipoContenitore, &updatedKC.StatusChainID, &updatedKC.NoOfActiveKanbans,
-	)
+		kc.CustomerSiteID, kc.SupplierSiteID, kc.CustomerWorkCentreID, kc.SupplierWorkCentreID, kc.KanbanType, kc.QuantityUnit,
+	))
+}
+
+// refuseQuantityUnitChange refuses a change of the unit of a kanban chain's quantity while the chain has active
+// cards that would keep their quantity in the old unit, which all but immediate propagation leaves some of.
+// Run it in a transaction, which a refusal must roll back.
+func refuseQuantityUnitChange(ctx context.Context, tx dbtx, before, after *models.KanbanChain, propagation string) error {
+	if before.QuantityUnit == after.QuantityUnit || propagation == propagationImmediate {
+		return nil
+	}
+	return refuseIfAny(ctx, tx, "The kanban chain has %d active kanbans counted in "+before.QuantityUnit+
+		"; change its quantity unit with immediate propagation, so they take the new quantity",
+		`SELECT COUNT(*) FROM kanbans WHERE kanban_chain_id = $1 AND is_active = true`, after.ID)
+}
+
+// archiveKanbanChain archives a kanban chain and retires its cards. It refuses while any card is in flight,
+// that is active and outside the first status of its status chain: those are physical containers
+// somewhere between customer and supplier, which must come back before the loop is closed.
+// Run it in a transaction, which a refusal must roll back.
+func archiveKanbanChain(ctx context.Context, tx dbtx, id int64) error {
+	if _, err := getKanbanChainForUpdate(ctx, tx, id); err != nil {
+		return err
+	}
+	err := refuseIfAny(ctx, tx, "%d kanbans of the chain are in flight, archive the chain when they are back in the first status", `
+		SELECT COUNT(*)
+		FROM kanbans k
+		WHERE k.kanban_chain_id = $1 AND k.is_active = true
+		AND k.status_current IS DISTINCT FROM (
+			SELECT scs.status_id FROM status_chains_statuses scs
+			WHERE scs.status_chain_id = k.status_chain_id
+			ORDER BY scs."order" ASC LIMIT 1
+		)`, id)
 	if err != nil {
-		log.Printf("updateKanbanChain: Error executing query: %v", err)
-		return nil, err
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE kanbans SET is_active = false, retired_at = NOW(), pending_chain_change_id = NULL WHERE kanban_chain_id = $1 AND is_active = true`, id); err != nil {
+		return err
+	}
+	if _, err := syncNoOfActiveKanbans(ctx, tx, id); err != nil {
+		return err
 	}
-	log.Printf("updateKanbanChain: Kanban chain updated successfully with data: %+v", updatedKC)
-	return &updatedKC, nil
+	return archiveRow(ctx, tx, "kanban_chains", "id", id)
 }
 
-func deleteKanbanChain(db *sql.DB, id int64) error {
-	sqlStatement := `DELETE FROM kanban_chains WHERE id = $1`
-	_, err := db.Exec(sqlStatement, id)
-	return err
+// restoreKanbanChain restores a kanban chain, refusing while its accounts, sites, work centres, product or status
+// chain are archived.
+// The chain comes back without cards: reactivate its retired cards, or give it new ones with no_of_initial_kanbans
+// on an update, which is refused while the chain is archived.
+// Run it in a transaction, which a refusal must roll back.
+func restoreKanbanChain(ctx context.Context, tx dbtx, id int64) (*models.KanbanChain, error) {
+	if err := restoreRow(ctx, tx, "kanban_chains", "id", id); err != nil {
+		return nil, err
+	}
+	err := refuseIfAny(ctx, tx, "%d of the accounts, sites, work centres, product and status chain of the kanban chain are archived, restore them first", `
+		SELECT
+			(SELECT COUNT(*) FROM accounts a WHERE a.id IN (kc.cliente_id, kc.fornitore_id) AND a.archived_at IS NOT NULL) +
+			(SELECT COUNT(*) FROM sites st WHERE st.id IN (kc.customer_site_id, kc.supplier_site_id) AND st.archived_at IS NOT NULL) +
+			(SELECT COUNT(*) FROM work_centres wc WHERE wc.id IN (kc.customer_work_centre_id, kc.supplier_work_centre_id) AND wc.archived_at IS NOT NULL) +
+			(SELECT COUNT(*) FROM products p WHERE p.product_id = kc.prodotto_codice AND p.archived_at IS NOT NULL) +
+			(SELECT COUNT(*) FROM status_chains sc WHERE sc.status_chain_id = kc.status_chain_id AND sc.archived_at IS NOT NULL)
+		FROM kanban_chains kc
+		WHERE kc.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	return getKanbanChainByID(ctx, tx, id)
 }
 
-// createInitialKanbans creates kanban records when a new kanban chain is created
-func createInitialKanbans(db *sql.DB, kanbanChainID int64, numberOfKanbans int64, statusChainID int64, leadtimeDays int64, tipoContenitore string, quantity float64) error {
-	log.Println("createInitialKanbans: Starting") // Log entry
-	log.Printf("createInitialKanbans: Parameters - kanbanChainID: %d, numberOfKanbans: %d, statusChainID: %d, leadtimeDays: %d, tipoContenitore: %s, quantity: %f", kanbanChainID, numberOfKanbans, statusChainID, leadtimeDays, tipoContenitore, quantity)
+// createKanbanChainWithKanbans creates a kanban chain with its initial cards in the first status of its status chain,
+// inside tx. no_of_active_kanbans is set to the number of cards actually created.
+func createKanbanChainWithKanbans(ctx context.Context, tx dbtx, kc models.KanbanChain, initialKanbans int64) (*models.KanbanChain, error) {
+	newKanbanChain, err := createKanbanChain(ctx, tx, kc)
+	if err != nil {
+		return nil, err
+	}
+	if initialKanbans > 0 {
+		err = createInitialKanbans(ctx, tx, newKanbanChain.ID, initialKanbans, newKanbanChain.StatusChainID, newKanbanChain.LeadtimeDays, newKanbanChain.TipoContenitore, newKanbanChain.Quantity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if newKanbanChain.NoOfActiveKanbans, err = syncNoOfActiveKanbans(ctx, tx, newKanbanChain.ID); err != nil {
+		return nil, err
+	}
+	return newKanbanChain, nil
+}
 
-	tx, err := db.Begin()
+// syncNoOfActiveKanbans sets no_of_active_kanbans of a kanban chain to the number of its active cards, and returns it
+func syncNoOfActiveKanbans(ctx context.Context, tx dbtx, kanbanChainID int64) (int64, error) {
+	var count int64
+	err := tx.QueryRowContext(ctx, `
+		UPDATE kanban_chains
+		SET no_of_active_kanbans = (SELECT COUNT(*) FROM kanbans WHERE kanban_chain_id = $1 AND is_active = true)
+		WHERE id = $1
+		RETURNING no_of_active_kanbans`, kanbanChainID).Scan(&count)
 	if err != nil {
-		log.Printf("createInitialKanbans: Error starting transaction: %v", err) // Log transaction begin error
-		return fmt.Errorf("error starting transaction for creating initial kanbans: %w", err)
+		return 0, fmt.Errorf("syncNoOfActiveKanbans: error counting kanbans of chain %d: %w", kanbanChainID, err)
 	}
-	defer tx.Rollback() // Rollback if any operation fails
+	return count, nil
+}
+
+// createInitialKanbans creates numberOfKanbans cards for a kanban chain in the first status of its status chain.
+// It runs on the caller's transaction, so the cards are only kept if the rest of the change is.
+func createInitialKanbans(ctx context.Context, tx dbtx, kanbanChainID int64, numberOfKanbans int64, statusChainID int64, leadtimeDays int64, tipoContenitore string, quantity float64) error {
 
 	// Get the first status in the status chain to set as initial status_current
-	firstStatusID, err := getFirstStatusIDInChain(db, statusChainID)
+	firstStatusID, err := getFirstStatusIDInChain(ctx, tx, statusChainID)
 	if err != nil {
-		log.Printf("createInitialKanbans: Error getting first status in chain: %v", err) // Log error getting first status
 		return fmt.Errorf("error getting first status in chain: %w", err)
 	}
 	if firstStatusID == 0 {
-		log.Printf("createInitialKanbans: No statuses found in status chain %d", statusChainID) // Log no statuses found
 		return fmt.Errorf("no statuses found in status chain %d", statusChainID)
 	}
-	log.Printf("createInitialKanbans: First Status ID in chain %d: %d", statusChainID, firstStatusID) // Log first status ID
 
 	sqlStatement := `
 		INSERT INTO kanbans (
@@ -374,26 +576,16 @@ func createInitialKanbans(db *sql.DB, kanbanChainID int64, numberOfKanbans int64
 		SELECT $1, $2, $3, $4, $5, $6, NOW()
 		FROM generate_series(1, $7)
 	` // data_aggiornamento set to NOW() on creation
-	log.Printf("createInitialKanbans: SQL Query: %s, Parameters: [kanbanChainID=%d, statusChainID=%d, firstStatusID=%d, leadtimeDays=%d, tipoContenitore=%s, quantity=%f, numberOfKanbans=%d]", sqlStatement, kanbanChainID, statusChainID, firstStatusID, leadtimeDays, tipoContenitore, quantity, numberOfKanbans)
-
-	_, err = tx.Exec(sqlStatement, kanbanChainID, statusChainID, firstStatusID, leadtimeDays, tipoContenitore, quantity, numberOfKanbans)
+	_, err = tx.ExecContext(ctx, sqlStatement, kanbanChainID, statusChainID, firstStatusID, leadtimeDays, tipoContenitore, quantity, numberOfKanbans)
 	if err != nil {
-		log.Printf("createInitialKanbans: Error executing kanban insert query: %v", err) // Log query execution error
 		return fmt.Errorf("error inserting kanbans: %w", err)
 	}
-	log.Println("createInitialKanbans: Kanban insert query executed successfully") // Log query success
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("createInitialKanbans: Error committing transaction: %v", err) // Log transaction commit error
-		return fmt.Errorf("error committing transaction for initial kanban creation: %w", err)
-	}
-
-	log.Println("createInitialKanbans: Finished successfully") // Log exit
+	slog.Debug("initial kanbans created", "kanban_chain_id", kanbanChainID, "kanbans", numberOfKanbans, "status_id", firstStatusID)
 	return nil
 }
 
 // getFirstStatusIDInChain retrieves the status_id of the first status in a status chain (based on 'order').
-func getFirstStatusIDInChain(db *sql.DB, statusChainID int64) (int64, error) {
+func getFirstStatusIDInChain(ctx context.Context, db dbtx, statusChainID int64) (int64, error) {
 	query := `
 		SELECT status_id
 		FROM status_chains_statuses
@@ -402,7 +594,7 @@ func getFirstStatusIDInChain(db *sql.DB, statusChainID int64) (int64, error) {
 		LIMIT 1;
 	`
 	var statusID int64
-	err := db.QueryRow(query, statusChainID).Scan(&statusID)
+	err := db.QueryRowContext(ctx, query, statusChainID).Scan(&statusID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, nil // No statuses in chain, return 0
@@ -1,11 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -13,19 +13,34 @@ import (
 	"electronic_kanban_backend/models"
 
 	"github.com/gorilla/mux"
-	"github.com/lib/pq"
 )
 
 // GetKanbansHandler returns a handler for GET /api/kanbans, now with product filtering
 func GetKanbansHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		productIDs := r.URL.Query()["product_id"] // Get product_id query parameters
+		ctx := r.Context()
+		lq, err := parseListQuery(r, kanbanListSpec) // product_id=... filters are handled as list filters
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
 
-		kanbans, err := getKanbans(db, productIDs) // Pass productIDs to getKanbans
+		kanbans, err := getKanbans(ctx, db, lq)
 		if err != nil {
-			http.Error(w, "Failed to fetch kanbans", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to fetch kanbans")
 			return
 		}
+		total, err := countRows(ctx, db, kanbansFrom, lq, kanbanListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch kanbans")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, kanbansFrom, lq, kanbanListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch kanbans")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(kanbans)
 	}
@@ -34,119 +49,131 @@ func GetKanbansHandler(db *sql.DB) http.HandlerFunc {
 // CreateKanbanHandler returns a handler for POST /api/kanbans
 func CreateKanbanHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		var kanban models.Kanban
 		err := json.NewDecoder(r.Body).Decode(&kanban)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		fieldErrs, err := validateKanban(ctx, db, &kanban)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to validate kanban")
+			return
+		}
+		if len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 
-		newKanban, err := createKanban(db, kanban)
+		var newKanban *models.Kanban
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if newKanban, err = createKanban(ctx, tx, kanban); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditCreate, "kanban", newKanban.ID, nil, newKanban)
+		})
 		if err != nil {
-			http.Error(w, "Failed to create kanban", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to create kanban")
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(newKanban)
+		json.NewEncoder(w).Encode(models.NewKanbanResponse(*newKanban))
 	}
 }
 
 // GetKanbanHandler returns a handler for GET /api/kanbans/{id}
 func GetKanbanHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Kanban ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Kanban ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid kanban ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban ID")
 			return
 		}
 
-		kanban, err := getKanbanByID(db, id) // Use getKanbanByID to fetch single kanban
+		kanban, err := getKanbanByID(ctx, db, id) // Use getKanbanByID to fetch single kanban
 		if err != nil {
-			if err == sql.ErrNoRows {
-				http.NotFound(w, r)
-			} else {
-				http.Error(w, "Failed to fetch kanban", http.StatusInternalServerError)
-			}
+			writeDBError(w, r, err, "Failed to fetch kanban")
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(kanban)
+		json.NewEncoder(w).Encode(models.NewKanbanResponse(*kanban))
 	}
 }
 
 // UpdateKanbanHandler returns a handler for PUT/PATCH /api/kanbans/{id} - For updating editable fields
 func UpdateKanbanHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("UpdateKanbanHandler: Starting")
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			log.Println("UpdateKanbanHandler: Kanban ID is required")
-			http.Error(w, "Kanban ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Kanban ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			log.Printf("UpdateKanbanHandler: Invalid kanban ID: %v", err)
-			http.Error(w, "Invalid kanban ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban ID")
 			return
 		}
 
 		var kanbanUpdates map[string]interface{} // Allow partial updates
 		err = json.NewDecoder(r.Body).Decode(&kanbanUpdates)
 		if err != nil {
-			log.Printf("UpdateKanbanHandler: Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
 			return
 		}
-		log.Printf("UpdateKanbanHandler: Updating kanban with ID %d, data: %+v", id, kanbanUpdates)
 
 		//		updatedKanban, err := updateKanbanPartial(db, id, kanbanUpdates) // Call new updateKanbanPartial function
-		updatedKanban, err := updateKanbanStatus(db, id) // CALL CORRECT FUNCTION HERE - updateKanbanStatus
+		updatedKanban, err := updateKanbanStatus(db, r, id) // CALL CORRECT FUNCTION HERE - updateKanbanStatus
 
 		if err != nil {
-			log.Printf("UpdateKanbanHandler: Failed to update kanban: %v", err)
-			http.Error(w, "Failed to update kanban", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update kanban")
 			return
 		}
 
-		log.Printf("UpdateKanbanHandler: Kanban updated successfully: %+v", updatedKanban)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(updatedKanban)
-		log.Println("UpdateKanbanHandler: Finished successfully")
+		json.NewEncoder(w).Encode(models.NewKanbanResponse(*updatedKanban))
 	}
 }
 
+// kanbanEditRequest is the body of PUT/PATCH /api/kanbans/{id}; fields left out are not changed
+type kanbanEditRequest struct {
+	LeadtimeDays    *int64   `json:"leadtime_days"`
+	TipoContenitore *string  `json:"tipo_contenitore"`
+	Quantity        *float64 `json:"quantity"`
+}
+
 // updateKanbanPartial updates specific fields of a kanban (leadtime_days, tipo_contenitore, quantity)
-func updateKanbanPartial(db *sql.DB, id int64, updates map[string]interface{}) (*models.Kanban, error) {
-	log.Printf("updateKanbanPartial: Starting, ID: %d, updates: %+v", id, updates)
+func updateKanbanPartial(ctx context.Context, db dbtx, id int64, updates kanbanEditRequest) (*models.Kanban, error) {
 	// Start building the UPDATE query dynamically
 	sqlStatement := `UPDATE kanbans SET data_aggiornamento = NOW()` // Always update data_aggiornamento
 	var args []interface{}
 	argIndex := 1
 
-	if leadtimeDays, ok := updates["leadtime_days"].(float64); ok {
+	if updates.LeadtimeDays != nil {
 		sqlStatement += fmt.Sprintf(", leadtime_days = $%d", argIndex)
-		args = append(args, int64(leadtimeDays))
+		args = append(args, *updates.LeadtimeDays)
 		argIndex++
 	}
-	if tipoContenitore, ok := updates["tipo_contenitore"].(string); ok {
+	if updates.TipoContenitore != nil {
 		sqlStatement += fmt.Sprintf(", tipo_contenitore = $%d", argIndex)
-		args = append(args, tipoContenitore)
+		args = append(args, *updates.TipoContenitore)
 		argIndex++
 	}
-	if quantity, ok := updates["quantity"].(float64); ok {
+	if updates.Quantity != nil {
 		sqlStatement += fmt.Sprintf(", quantity = $%d", argIndex)
-		args = append(args, quantity)
+		args = append(args, *updates.Quantity)
 		argIndex++
 	}
 	// Add other fields to update here if needed, following the same pattern
@@ -154,55 +181,180 @@ func updateKanbanPartial(db *sql.DB, id int64, updates map[string]interface{}) (
 	sqlStatement += fmt.Sprintf(" WHERE id = $%d RETURNING id, data_aggiornamento, leadtime_days, is_active, kanban_chain_id, status_chain_id, status_current, tipo_contenitore, quantity", argIndex)
 	args = append(args, id)
 
-	log.Printf("updateKanbanPartial: SQL Query: %s, Parameters: %+v", sqlStatement, args)
-
 	var updatedKanban models.Kanban
-	row := db.QueryRow(sqlStatement, args...)
+	row := db.QueryRowContext(ctx, sqlStatement, args...)
 	err := row.Scan(
 		&updatedKanban.ID, &updatedKanban.DataAggiornamento, &updatedKanban.LeadtimeDays, &updatedKanban.IsActive, &updatedKanban.KanbanChainID,
 		&updatedKanban.StatusChainID, &updatedKanban.StatusCurrent, &updatedKanban.TipoContenitore, &updatedKanban.Quantity,
 	)
 	if err != nil {
-		log.Printf("updateKanbanPartial: Error updating kanban: %v", err)
 		return nil, err
 	}
-	log.Printf("updateKanbanPartial: Kanban updated succesfully with data: %+v", updatedKanban)
 	return &updatedKanban, nil
 }
 
 // DeleteKanbanHandler returns a handler for DELETE /api/kanbans/{id}
 func DeleteKanbanHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Kanban ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Kanban ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid kanban ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban ID")
 			return
 		}
 
-		err = deleteKanban(db, id) // Call deleteKanban database function
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousKanban, err := getKanbanByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := deleteKanban(ctx, tx, id); err != nil { // Call deleteKanban database function
+				return err
+			}
+			deletedKanban, err := getKanbanByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if _, err := syncNoOfActiveKanbans(ctx, tx, deletedKanban.KanbanChainID); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditDelete, "kanban", id, previousKanban, deletedKanban)
+		})
 		if err != nil {
-			http.Error(w, "Failed to delete kanban", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to delete kanban")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK) // Respond with 200 OK
-		json.NewEncoder(w).Encode(map[string]string{"message": "Kanban deleted"})
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Kanban deleted"})
+	}
+}
+
+// GetRetiredKanbansHandler returns a handler for GET /api/kanbans/retired, listing the cards deleted or retired
+// with their kanban chain
+func GetRetiredKanbansHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		lq, err := parseListQuery(r, retiredKanbanListSpec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+
+		kanbans, err := getRetiredKanbans(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch retired kanbans")
+			return
+		}
+		total, err := countRows(ctx, db, retiredKanbansFrom, lq, retiredKanbanListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch retired kanbans")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, retiredKanbansFrom, lq, retiredKanbanListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch retired kanbans")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(kanbans)
+	}
+}
+
+// ReactivateKanbanHandler returns a handler for POST /api/kanbans/{id}/reactivate, which puts a retired card
+// back in circulation
+func ReactivateKanbanHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban ID")
+			return
+		}
+
+		var kanban *models.Kanban
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousKanban, err := getKanbanByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if kanban, err = reactivateKanban(ctx, tx, previousKanban); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditRestore, "kanban", id, previousKanban, kanban)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to reactivate kanban")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.NewKanbanResponse(*kanban))
 	}
 }
 
 // Database interaction functions (private)
 
-// getKanbans retrieves kanbans, optionally filtered by product IDs
-func getKanbans(db *sql.DB, productIDs []string) ([]map[string]interface{}, error) { // Return []map[string]interface{}
+// kanbanListSpec defines what GET /api/kanbans can sort, filter and search on
+var kanbanListSpec = listSpec{
+	Fields: map[string]listField{
+		"id":                 {Column: "k.id", Kind: fieldInt},
+		"data_aggiornamento": {Column: "k.data_aggiornamento", Kind: fieldText},
+		"leadtime_days":      {Column: "k.leadtime_days", Kind: fieldInt},
+		"kanban_chain_id":    {Column: "k.kanban_chain_id", Kind: fieldInt},
+		"status_chain_id":    {Column: "k.status_chain_id", Kind: fieldInt},
+		"status_current":     {Column: "k.status_current", Kind: fieldInt},
+		"tipo_contenitore":   {Column: "k.tipo_contenitore", Kind: fieldText},
+		"quantity":           {Column: "k.quantity", Kind: fieldFloat},
+		"product_id":         {Column: "kc.prodotto_codice", Kind: fieldText},
+		"product_name":       {Column: "p.name", Kind: fieldText},
+	},
+	Search:      []string{"kc.prodotto_codice", "p.name", "k.tipo_contenitore"},
+	DefaultSort: "id",
+	TieBreaker:  "k.id",
+}
+
+const kanbansFrom = `
+		FROM
+			kanbans k
+		JOIN
+			kanban_chains kc ON k.kanban_chain_id = kc.id
+		JOIN
+			products p ON kc.prodotto_codice = p.product_id
+		WHERE k.is_active=true`
+
+// retiredKanbanListSpec defines what GET /api/kanbans/retired can sort, filter and search on
+var retiredKanbanListSpec = func() listSpec {
+	spec := kanbanListSpec
+	spec.Fields = map[string]listField{"retired_at": {Column: "k.retired_at", Kind: fieldText}}
+	for name, field := range kanbanListSpec.Fields {
+		spec.Fields[name] = field
+	}
+	spec.DefaultSort = "-retired_at"
+	return spec
+}()
+
+const retiredKanbansFrom = `
+		FROM
+			kanbans k
+		JOIN
+			kanban_chains kc ON k.kanban_chain_id = kc.id
+		JOIN
+			products p ON kc.prodotto_codice = p.product_id
+		WHERE k.is_active=false`
+
+// getKanbans retrieves active kanbans matching the list query
+func getKanbans(ctx context.Context, db *sql.DB, lq *listQuery) ([]models.KanbanListItem, error) {
 	query := `
 		SELECT
-			k.id AS kanban_id,
+			k.id,
 			k.data_aggiornamento,
 			k.leadtime_days,
 			k.is_active,
@@ -211,72 +363,88 @@ func getKanbans(db *sql.DB, productIDs []string) ([]map[string]interface{}, erro
 			k.status_current,
 			k.tipo_contenitore,
 			k.quantity,
-			p.product_id AS product_id,  -- Include product_id
-			p.name AS product_name       -- Include product_name
-		FROM
-			kanbans k
-		JOIN
-			kanban_chains kc ON k.kanban_chain_id = kc.id
-		JOIN
-			products p ON kc.prodotto_codice = p.product_id
-		WHERE k.is_active=true
-	`
+			p.product_id,
+			p.name AS product_name,
+			kc.quantity_unit` + kanbansFrom
 	var args []interface{}
-	if len(productIDs) > 0 {
-		query += " AND kc.prodotto_codice = ANY($1)" // Filter directly on kc.prodotto_codice
-		// Use pq.Array to correctly format the productIDs array for PostgreSQL
-		args = append(args, pq.Array(productIDs)) // Use pq.Array here
-	}
+	query += lq.where(kanbanListSpec, &args) + lq.after(kanbanListSpec, &args) + lq.orderBy(kanbanListSpec) + lq.page()
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var kanbans []map[string]interface{} // Return slice of maps
+	kanbans := []models.KanbanListItem{}
 	for rows.Next() {
-		var kanbanData map[string]interface{} = make(map[string]interface{}) // Use map[string]interface{}
 		var k models.Kanban
-		var productID string   // Temporary variable for product_id
-		var productName string // Temporary variable for product_name
-
+		var item models.KanbanListItem
 		err := rows.Scan(
 			&k.ID, &k.DataAggiornamento, &k.LeadtimeDays, &k.IsActive, &k.KanbanChainID,
 			&k.StatusChainID, &k.StatusCurrent, &k.TipoContenitore, &k.Quantity,
-			&productID,   // Scan into temporary productID variable
-			&productName, // Scan into temporary productName variable
+			&item.ProductID, &item.ProductName, &item.QuantityUnit,
 		)
 		if err != nil {
 			return nil, err
 		}
-		// Assign temporary variables to the map
-		kanbanData["product_id"] = productID
-		kanbanData["product_name"] = productName
+		item.KanbanResponse = models.NewKanbanResponse(k)
+		kanbans = append(kanbans, item)
+	}
+	return kanbans, rows.Err()
+}
+
+// getRetiredKanbans retrieves the inactive kanbans matching the list query
+func getRetiredKanbans(ctx context.Context, db *sql.DB, lq *listQuery) ([]models.RetiredKanbanListItem, error) {
+	query := `
+		SELECT
+			k.id,
+			k.data_aggiornamento,
+			k.leadtime_days,
+			k.is_active,
+			k.kanban_chain_id,
+			k.status_chain_id,
+			k.status_current,
+			k.tipo_contenitore,
+			k.quantity,
+			p.product_id,
+			p.name AS product_name,
+			kc.quantity_unit,
+			COALESCE(k.retired_at, k.data_aggiornamento),
+			kc.archived_at IS NOT NULL` + retiredKanbansFrom
+	var args []interface{}
+	query += lq.where(retiredKanbanListSpec, &args) + lq.after(retiredKanbanListSpec, &args) + lq.orderBy(retiredKanbanListSpec) + lq.page()
 
-		// Copy Kanban fields to the map as well, if needed for frontend
-		kanbanData["id"] = k.ID
-		kanbanData["data_aggiornamento"] = k.DataAggiornamento
-		kanbanData["leadtime_days"] = k.LeadtimeDays
-		kanbanData["is_active"] = k.IsActive
-		kanbanData["kanban_chain_id"] = k.KanbanChainID
-		kanbanData["status_chain_id"] = k.StatusChainID
-		kanbanData["status_current"] = k.StatusCurrent
-		kanbanData["tipo_contenitore"] = k.TipoContenitore
-		kanbanData["quantity"] = k.Quantity
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		kanbans = append(kanbans, kanbanData) // Append the map to kanbans slice
+	kanbans := []models.RetiredKanbanListItem{}
+	for rows.Next() {
+		var k models.Kanban
+		var item models.RetiredKanbanListItem
+		err := rows.Scan(
+			&k.ID, &k.DataAggiornamento, &k.LeadtimeDays, &k.IsActive, &k.KanbanChainID,
+			&k.StatusChainID, &k.StatusCurrent, &k.TipoContenitore, &k.Quantity,
+			&item.ProductID, &item.ProductName, &item.QuantityUnit, &item.RetiredAt, &item.KanbanChainArchived,
+		)
+		if err != nil {
+			return nil, err
+		}
+		item.KanbanResponse = models.NewKanbanResponse(k)
+		kanbans = append(kanbans, item)
 	}
-	return kanbans, nil
+	return kanbans, rows.Err()
 }
 
-func createKanban(db *sql.DB, kanban models.Kanban) (*models.Kanban, error) {
+func createKanban(ctx context.Context, db dbtx, kanban models.Kanban) (*models.Kanban, error) {
 	sqlStatement := `
 		INSERT INTO kanbans (data_aggiornamento, leadtime_days, is_active, kanban_chain_id, status_chain_id, status_current, tipo_contenitore, quantity)
 		VALUES (NOW(), $1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, data_aggiornamento, leadtime_days, is_active, kanban_chain_id, status_chain_id, status_current, tipo_contenitore, quantity`
 	var newKanban models.Kanban
-	err := db.QueryRow(sqlStatement,
+	err := db.QueryRowContext(ctx, sqlStatement,
 		kanban.LeadtimeDays, kanban.IsActive, kanban.KanbanChainID, kanban.StatusChainID, kanban.StatusCurrent, kanban.TipoContenitore, kanban.Quantity,
 	).Scan(
 		&newKanban.ID, &newKanban.DataAggiornamento, &newKanban.LeadtimeDays, &newKanban.IsActive, &newKanban.KanbanChainID,
@@ -288,19 +456,29 @@ func createKanban(db *sql.DB, kanban models.Kanban) (*models.Kanban, error) {
 	return &newKanban, nil
 }
 
-func getKanbanByID(db *sql.DB, id int64) (*models.Kanban, error) {
-	sqlStatement := `
+func getKanbanByID(ctx context.Context, db dbtx, id int64) (*models.Kanban, error) {
+	return scanKanban(db.QueryRowContext(ctx, kanbanByIDQuery, id))
+}
+
+// getKanbanForUpdate reads a kanban and locks it until the end of the transaction, so concurrent moves of the
+// same card wait for each other
+func getKanbanForUpdate(ctx context.Context, tx dbtx, id int64) (*models.Kanban, error) {
+	return scanKanban(tx.QueryRowContext(ctx, kanbanByIDQuery+` FOR UPDATE`, id))
+}
+
+const kanbanByIDQuery = `
 		SELECT
 			id, data_aggiornamento, leadtime_days, is_active, kanban_chain_id,
 			status_chain_id, status_current, tipo_contenitore, quantity
 		FROM kanbans
-		WHERE id = $1
-	`
+		WHERE id = $1`
+
+func scanKanban(row interface{ Scan(...interface{}) error }) (*models.Kanban, error) {
 	var kanban models.Kanban
-	err := db.QueryRow(sqlStatement, id).Scan(
+	err := row.Scan(
 		&kanban.ID, &kanban.DataAggiornamento, &kanban.LeadtimeDays, &kanban.IsActive, &kanban.KanbanChainID,
 		&kanban.StatusChainID, &kanban.StatusCurrent, &kanban.TipoContenitore, &kanban.Quantity,
-	) // Line 289 is likely this rows.Scan line
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -308,14 +486,14 @@ func getKanbanByID(db *sql.DB, id int64) (*models.Kanban, error) {
 }
 
 // deleteKanban performs a soft delete of a kanban by setting is_active to false
-func deleteKanban(db *sql.DB, id int64) error {
+func deleteKanban(ctx context.Context, db dbtx, id int64) error {
 	sqlStatement := `
 		UPDATE kanbans
-		SET is_active = false, data_aggiornamento = NOW()
+		SET is_active = false, retired_at = NOW(), data_aggiornamento = NOW()
 		WHERE id = $1
 		RETURNING id, data_aggiornamento, leadtime_days, is_active, kanban_chain_id, status_chain_id, status_current, tipo_contenitore, quantity` // Returning updated kanban
 	var updatedKanban models.Kanban // To scan the updated kanban
-	err := db.QueryRow(sqlStatement, id).Scan(
+	err := db.QueryRowContext(ctx, sqlStatement, id).Scan(
 		&updatedKanban.ID,
 		&updatedKanban.DataAggiornamento,
 		&updatedKanban.LeadtimeDays,
@@ -327,88 +505,92 @@ func deleteKanban(db *sql.DB, id int64) error {
 		&updatedKanban.Quantity, // **CORRECTED - Now exactly 9 arguments, matching RETURNING clause**
 	)
 	if err != nil {
-		log.Printf("deleteKanban: Error executing soft DELETE (UPDATE is_active=false) query for kanban ID %d: %v", id, err)
 		return fmt.Errorf("deleteKanban: error soft deleting kanban ID %d: %w", id, err)
 	}
-	log.Printf("deleteKanban: Successfully soft deleted kanban with ID %d", id)
 	return nil
 }
 
-func updateKanban(db *sql.DB, id int64, updates map[string]interface{}) (*models.Kanban, error) {
-	log.Printf("updateKanban: Starting, ID: %d, updates: %+v", id, updates)
-	// Start building the UPDATE query dynamically
-	sqlStatement := `UPDATE kanbans SET data_aggiornamento = NOW()` // Always update data_aggiornamento
-	var args []interface{}
-	argIndex := 1
-
-	if statusCurrent, ok := updates["status_current"].(float64); ok { // Assuming status_current is sent as integer in JSON, hence float64 after unmarshaling
-		sqlStatement += fmt.Sprintf(", status_current = $%d", argIndex)
-		args = append(args, int64(statusCurrent)) // Convert float64 to int64
-		argIndex++
+// reactivateKanban puts retired kanban k back in circulation with the current values of its kanban chain, as a new
+// card would. It keeps its status when the chain's status chain still has it, and otherwise starts from the first
+// status. It refuses while the card is active or its chain archived. Run it in a transaction, which a refusal must
+// roll back.
+func reactivateKanban(ctx context.Context, tx dbtx, k *models.Kanban) (*models.Kanban, error) {
+	if k.IsActive {
+		return nil, &conflictError{message: fmt.Sprintf("Kanban %d is not retired", k.ID)}
 	}
-	// Add other fields to update here if needed, following the same pattern
-
-	sqlStatement += fmt.Sprintf(" WHERE id = $%d RETURNING id, data_aggiornamento, leadtime_days, is_active, kanban_chain_id, status_chain_id, status_current, tipo_contenitore, quantity", argIndex)
-	args = append(args, id)
-	log.Printf("updateKanban: SQL Query: %s, Parameters: %+v", sqlStatement, args)
-
-	var updatedKanban models.Kanban
-	row := db.QueryRow(sqlStatement, args...) // Pass all arguments as slice
-	err := row.Scan(
-		&updatedKanban.ID, &updatedKanban.DataAggiornamento, &updatedKanban.LeadtimeDays, &updatedKanban.IsActive, &updatedKanban.KanbanChainID,
-		&updatedKanban.StatusChainID, &updatedKanban.StatusCurrent, &updatedKanban.TipoContenitore, &updatedKanban.Quantity,
-	)
+	kc, err := getKanbanChainForUpdate(ctx, tx, k.KanbanChainID)
 	if err != nil {
-		log.Printf("updateKanban: Error updating kanban: %v", err)
 		return nil, err
 	}
-	log.Printf("updateKanban: Kanban updated succesfully with data: %+v", updatedKanban)
+	if kc.ArchivedAt != nil {
+		return nil, &conflictError{message: fmt.Sprintf("Kanban chain %d of the kanban is archived, restore it first", kc.ID)}
+	}
 
-	// Record history of status change if status_current was updated
-	if _, statusUpdated := updates["status_current"]; statusUpdated {
-		log.Println("updateKanban: Recording status history")
-		if err := recordKanbanHistory(db, &updatedKanban, updates); err != nil {
-			// Log the history recording error, but don't fail the main update
-			fmt.Printf("updateKanban: Error recording kanban history: %v\n", err) // Or use a proper logger
+	statuses, err := getStatusChainStatusesOrdered(ctx, tx, kc.StatusChainID)
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, &conflictError{message: fmt.Sprintf("Status chain %d of the kanban chain has no statuses", kc.StatusChainID)}
+	}
+	statusID := statuses[0].StatusID
+	for _, s := range statuses {
+		if s.StatusID == k.StatusCurrent {
+			statusID = k.StatusCurrent
 		}
 	}
 
-	return &updatedKanban, nil
+	var reactivated models.Kanban
+	err = tx.QueryRowContext(ctx, `
+		UPDATE kanbans
+		SET is_active = true, retired_at = NULL, pending_chain_change_id = NULL, data_aggiornamento = NOW(),
+			leadtime_days = $2, tipo_contenitore = $3, quantity = $4, status_chain_id = $5, status_current = $6
+		WHERE id = $1
+		RETURNING id, data_aggiornamento, leadtime_days, is_active, kanban_chain_id, status_chain_id, status_current, tipo_contenitore, quantity`,
+		k.ID, kc.LeadtimeDays, kc.TipoContenitore, kc.Quantity, kc.StatusChainID, statusID,
+	).Scan(
+		&reactivated.ID, &reactivated.DataAggiornamento, &reactivated.LeadtimeDays, &reactivated.IsActive, &reactivated.KanbanChainID,
+		&reactivated.StatusChainID, &reactivated.StatusCurrent, &reactivated.TipoContenitore, &reactivated.Quantity,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syncNoOfActiveKanbans(ctx, tx, kc.ID); err != nil {
+		return nil, err
+	}
+	return &reactivated, nil
 }
 
-func recordKanbanHistory(db *sql.DB, updatedKanban *models.Kanban, updates map[string]interface{}) error {
-	var previousStatus int64
-	if statusCurrentFloat, ok := updates["status_current"].(float64); ok {
-		currentStatus := int64(statusCurrentFloat)
-		// Retrieve previous status from the kanban record before update
-		previousKanban, err := getKanbanByID(db, updatedKanban.ID) // Get Kanban before update to find previous status
-		if err != nil {
-			return fmt.Errorf("error fetching previous kanban status: %w", err)
-		}
-		previousStatus = previousKanban.StatusCurrent
-
-		sqlStatement := `
-			INSERT INTO kanban_histories (kanban_id, previous_status, next_status, data_aggiornamento)
-			VALUES ($1, $2, $3, NOW())
-		`
-		_, err = db.Exec(sqlStatement, updatedKanban.ID, previousStatus, currentStatus)
-		if err != nil {
-			return fmt.Errorf("error inserting kanban history: %w", err)
-		}
+// recordKanbanHistory records a status change of a kanban in kanban_histories
+func recordKanbanHistory(ctx context.Context, db dbtx, kanbanID, previousStatus, nextStatus int64) error {
+	sqlStatement := `
+		INSERT INTO kanban_histories (kanban_id, previous_status, next_status, data_aggiornamento)
+		VALUES ($1, $2, $3, NOW())
+	`
+	if _, err := db.ExecContext(ctx, sqlStatement, kanbanID, previousStatus, nextStatus); err != nil {
+		return fmt.Errorf("error inserting kanban history: %w", err)
 	}
 	return nil
 }
 
-// updateKanbanStatus updates the kanban status to the next status in the chain
-func updateKanbanStatus(db *sql.DB, id int64) (*models.Kanban, error) {
-	log.Printf("updateKanbanStatus: Starting, ID: %d", id)
+// updateKanbanStatus updates the kanban status to the next status in the chain, recording the move in the audit log
+func updateKanbanStatus(db *sql.DB, r *http.Request, id int64) (*models.Kanban, error) {
+	ctx := r.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("updateKanbanStatus: error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	currentKanban, err := getKanbanByID(db, id)
+	currentKanban, err := getKanbanForUpdate(ctx, tx, id)
 	if err != nil {
 		return nil, fmt.Errorf("updateKanbanStatus: error fetching kanban: %w", err)
 	}
+	if !currentKanban.IsActive {
+		return nil, &conflictError{message: fmt.Sprintf("Kanban %d is retired, reactivate it before moving it", id)}
+	}
 
-	statusChainStatuses, err := getStatusChainStatusesOrdered(db, currentKanban.StatusChainID)
+	statusChainStatuses, err := getStatusChainStatusesOrdered(ctx, tx, currentKanban.StatusChainID)
 	if err != nil {
 		return nil, fmt.Errorf("updateKanbanStatus: error fetching status chain statuses: %w", err)
 	}
@@ -417,7 +599,7 @@ func updateKanbanStatus(db *sql.DB, id int64) (*models.Kanban, error) {
 		return nil, fmt.Errorf("updateKanbanStatus: no statuses in status chain")
 	}
 
-	nextStatusID, err := getNextStatusIDInChain(db, currentKanban.StatusCurrent, statusChainStatuses) // CALL getNextStatusIDInChain HERE
+	nextStatusID, err := getNextStatusIDInChain(ctx, tx, currentKanban.StatusCurrent, statusChainStatuses)
 	if err != nil {
 		return nil, fmt.Errorf("updateKanbanStatus: error getting next status: %w", err)
 	}
@@ -425,24 +607,42 @@ func updateKanbanStatus(db *sql.DB, id int64) (*models.Kanban, error) {
 	sqlStatement := `
 		UPDATE kanbans
 		SET status_current = $2, data_aggiornamento = NOW()
-		WHERE id = $1
+		WHERE id = $1 AND is_active = true
 		RETURNING id, data_aggiornamento, leadtime_days, is_active, kanban_chain_id, status_chain_id, status_current, tipo_contenitore, quantity
 	`
 	var updatedKanban models.Kanban
-	err = db.QueryRow(sqlStatement, id, nextStatusID).Scan(
+	err = tx.QueryRowContext(ctx, sqlStatement, id, nextStatusID).Scan(
 		&updatedKanban.ID, &updatedKanban.DataAggiornamento, &updatedKanban.LeadtimeDays, &updatedKanban.IsActive, &updatedKanban.KanbanChainID,
 		&updatedKanban.StatusChainID, &updatedKanban.StatusCurrent, &updatedKanban.TipoContenitore, &updatedKanban.Quantity,
 	)
 	if err != nil {
-		log.Printf("updateKanbanStatus: Error updating kanban: %v", err)
 		return nil, err
 	}
-	log.Printf("updateKanbanStatus: Kanban status updated succesfully to status_id: %d", nextStatusID)
 
-	// Record history of status change if status_current was updated
-	updates := map[string]interface{}{"status_current": float64(nextStatusID)} // Pass nextStatusID as update
-	if err := recordKanbanHistory(db, &updatedKanban, updates); err != nil {
-		fmt.Printf("updateKanbanStatus: Error recording kanban history: %v\n", err) // Or use a proper logger
+	// Back at the start of the loop: take the kanban chain changes the card was waiting for
+	if nextStatusID == statusChainStatuses[0].StatusID {
+		applied, err := applyPendingChainChange(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if applied {
+			err = tx.QueryRowContext(ctx, `SELECT leadtime_days, status_chain_id, status_current, tipo_contenitore, quantity FROM kanbans WHERE id = $1`, id).Scan(
+				&updatedKanban.LeadtimeDays, &updatedKanban.StatusChainID, &updatedKanban.StatusCurrent, &updatedKanban.TipoContenitore, &updatedKanban.Quantity,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("updateKanbanStatus: error re-reading kanban: %w", err)
+			}
+		}
+	}
+	requestLogger(r).Debug("kanban moved", "kanban_id", id, "from_status", currentKanban.StatusCurrent, "to_status", nextStatusID)
+	if err := recordKanbanHistory(ctx, tx, id, currentKanban.StatusCurrent, updatedKanban.StatusCurrent); err != nil {
+		return nil, fmt.Errorf("updateKanbanStatus: %w", err)
+	}
+	if err := recordAudit(tx, r, auditMove, "kanban", id, currentKanban, &updatedKanban); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("updateKanbanStatus: error committing status change: %w", err)
 	}
 
 	return &updatedKanban, nil
@@ -451,145 +651,91 @@ func updateKanbanStatus(db *sql.DB, id int64) (*models.Kanban, error) {
 // KanbanEditFormHandler handles PUT/PATCH requests to /api/kanbans/{id} - For Kanban Edit Form updates
 func KanbanEditFormHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("KanbanEditFormHandler: Starting")
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			log.Println("KanbanEditFormHandler: Kanban ID is required")
-			http.Error(w, "Kanban ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Kanban ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			log.Printf("KanbanEditFormHandler: Invalid kanban ID: %v", err)
-			http.Error(w, "Invalid kanban ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid kanban ID")
 			return
 		}
 
-		var kanbanUpdates map[string]interface{} // Allow partial updates
+		var kanbanUpdates kanbanEditRequest // Allow partial updates
 		err = json.NewDecoder(r.Body).Decode(&kanbanUpdates)
 		if err != nil {
-			log.Printf("KanbanEditFormHandler: Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateKanbanEdit(&kanbanUpdates); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
-		log.Printf("KanbanEditFormHandler: Updating kanban with ID %d, data from edit form: %+v", id, kanbanUpdates)
 
-		updatedKanban, err := updateKanbanPartial(db, id, kanbanUpdates) // CALL updateKanbanPartial HERE - For Edit Form Updates
+		var updatedKanban *models.Kanban
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousKanban, err := getKanbanByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if updatedKanban, err = updateKanbanPartial(ctx, tx, id, kanbanUpdates); err != nil { // CALL updateKanbanPartial HERE - For Edit Form Updates
+				return err
+			}
+			return recordAudit(tx, r, auditUpdate, "kanban", id, previousKanban, updatedKanban)
+		})
 		if err != nil {
-			log.Printf("KanbanEditFormHandler: Failed to update kanban: %v", err)
-			http.Error(w, "Failed to update kanban", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update kanban")
 			return
 		}
 
-		log.Printf("KanbanEditFormHandler: Kanban updated successfully via edit form: %+v", updatedKanban)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(updatedKanban)
-		log.Println("KanbanEditFormHandler: Finished successfully")
+		json.NewEncoder(w).Encode(models.NewKanbanResponse(*updatedKanban))
 	}
 }
 
 // getNextStatusInChain determines the next status in the status chain based on the current status and chain order.
-func getNextStatusIDInChain(db *sql.DB, currentStatusID int64, statusChainStatuses []map[string]interface{}) (int64, error) {
-	log.Println("getNextStatusIDInChain: Starting - Current Status ID:", currentStatusID)          // LOG: Function start and currentStatusID
-	log.Printf("getNextStatusIDInChain: Status Chain Statuses received: %+v", statusChainStatuses) // LOG: statusChainStatuses array
-
+func getNextStatusIDInChain(ctx context.Context, db dbtx, currentStatusID int64, statusChainStatuses []models.StatusChainStatusItem) (int64, error) {
 	var nextStatusID int64
-	lastStatusID := int64(0) // To track the last status in the chain
 
 	if len(statusChainStatuses) == 0 {
-		log.Println("getNextStatusIDInChain: Status chain is EMPTY") // LOG: Empty chain
 		return 0, fmt.Errorf("getNextStatusInChain: status chain is empty")
 	}
 
 	foundCurrent := false
 	isLastStatus := false
 
-	for i, statusMap := range statusChainStatuses {
-		statusID := statusMap["status_id"].(int64)
-		log.Printf("getNextStatusIDInChain: Checking status in chain - Index: %d, Status ID: %d, Order: %d", i, statusID, statusMap["order"]) // LOG: Status details in loop
-		if i == len(statusChainStatuses)-1 {
-			lastStatusID = statusID                                                         // Capture the last status ID
-			log.Println("getNextStatusChain: Last Status ID in chain found:", lastStatusID) // Log: Last status ID
-		}
+	for i, status := range statusChainStatuses {
+		statusID := status.StatusID
 		if statusID == currentStatusID {
 			foundCurrent = true
-			log.Println("getNextStatusInChain: Current Status ID FOUND in chain at index:", i) // Log: Current status found
 			if i == len(statusChainStatuses)-1 {
-				isLastStatus = true                                                             // Current status is the last one
-				log.Println("getNextStatusInChain: Current Status is the LAST status in chain") // Log: Last status in chain
+				isLastStatus = true // Current status is the last one
 			} else {
-				nextStatusID = statusChainStatuses[i+1]["status_id"].(int64)                  // Get the next status
-				log.Println("getNextStatusInChain: Next Status ID determined:", nextStatusID) // Log: Next status ID
+				nextStatusID = statusChainStatuses[i+1].StatusID // Get the next status
 			}
 			break // Exit loop once current status is found
 		}
 	}
 
 	if !foundCurrent {
-		log.Printf("getNextStatusIDInChain: Current Status ID %d NOT FOUND in status chain", currentStatusID) // Log: Current status NOT found
 		return 0, fmt.Errorf("getNextStatusInChain: current status not found in status chain")
 	}
 
 	if isLastStatus {
-		nextStatusID = statusChainStatuses[0]["status_id"].(int64)                          // Cycle back to the first status
-		log.Println("getNextStatusInChain: Cycling back to FIRST Status ID:", nextStatusID) // Log: Cycle to first status
+		nextStatusID = statusChainStatuses[0].StatusID // Cycle back to the first status
 	}
 
-	log.Println("getNextStatusInChain: Returning Next Status ID:", nextStatusID) // Log: Function exit and nextStatusID
 	return nextStatusID, nil
 }
 
 // getStatusChainStatusesOrdered retrieves statuses for a given status chain, ordered by their 'order' field.
-// It returns []map[string]interface{} to include 'order' and 'customer_supplier' if needed in the future.
-func getStatusChainStatusesOrdered(db *sql.DB, statusChainID int64) ([]map[string]interface{}, error) {
-	query := `
-		SELECT
-			scs.status_id,
-			s.name AS status_name,
-			s.color AS status_color,
-			scs."order",
-			scs.customer_supplier
-		FROM
-			status_chains_statuses scs
-		JOIN
-			statuses s ON scs.status_id = s.status_id
-		WHERE
-			scs.status_chain_id = $1
-		ORDER BY
-			scs."order" ASC;
-	`
-
-	rows, err := db.Query(query, statusChainID)
+func getStatusChainStatusesOrdered(ctx context.Context, db dbtx, statusChainID int64) ([]models.StatusChainStatusItem, error) {
+	statuses, err := getStatusChainStatuses(ctx, db, statusChainID)
 	if err != nil {
-		return nil, fmt.Errorf("getStatusChainStatusesOrdered: error querying status chain statuses: %w", err)
-	}
-	defer rows.Close()
-
-	var statuses []map[string]interface{}
-	for rows.Next() {
-		var statusID int64
-		var statusName string
-		var statusColor string
-		var order int64
-		var customerSupplier int
-
-		if err := rows.Scan(&statusID, &statusName, &statusColor, &order, &customerSupplier); err != nil {
-			return nil, fmt.Errorf("getStatusChainStatusesOrdered: error scanning status chain status row: %w", err)
-		}
-
-		statuses = append(statuses, map[string]interface{}{
-			"status_id":         statusID,
-			"status_name":       statusName,
-			"status_color":      statusColor,
-			"order":             order,
-			"customer_supplier": customerSupplier,
-		})
+		return nil, fmt.Errorf("getStatusChainStatusesOrdered: %w", err)
 	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("getStatusChainStatusesOrdered: error iterating status chain statuses rows: %w", err)
-	}
-
 	return statuses, nil
 }
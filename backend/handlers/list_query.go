@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Query parameters shared by every list endpoint:
+//
+//	limit=50            page size (omit to get every row, max maxListLimit)
+//	cursor=<opaque>     continue from the X-Next-Cursor of the previous page
+//	sort=name,-id       comma-separated fields, '-' for descending
+//	q=text              case-insensitive free-text search
+//	<field>=value       exact match, repeat the parameter to match any of several values
+//	include_archived=true  also list archived records, on endpoints of archivable records
+//
+// The total number of matching rows is returned in the X-Total-Count header, and the cursor
+// of the next page (if any) in X-Next-Cursor and in a Link: <...>; rel="next" header.
+// Pages are keyset pages: the cursor holds the sort keys and tie-breaker of the last row of
+// the previous page, so deep pages stay fast and rows added between pages are not repeated.
+const (
+	maxListLimit = 1000
+)
+
+// Reserved list parameters, which are never treated as field filters
+var listParams = map[string]bool{"limit": true, "cursor": true, "sort": true, "q": true, "include_archived": true}
+
+// fieldKind tells how a filter value is parsed before being compared to its column
+type fieldKind int
+
+const (
+	fieldText fieldKind = iota
+	fieldInt
+	fieldFloat
+	fieldBool
+)
+
+// listField is a field clients may sort and filter on
+type listField struct {
+	Column string // SQL expression
+	Kind   fieldKind
+}
+
+// listSpec describes the sortable, filterable and searchable fields of a list endpoint
+type listSpec struct {
+	Fields      map[string]listField
+	Search      []string // SQL expressions matched by q
+	DefaultSort string   // Sort applied when the client doesn't send one
+	TieBreaker  string   // Unique SQL expression appended to every ORDER BY, for stable pages
+	Archived    string   // archived_at column of archivable records, which are hidden unless include_archived=true
+}
+
+// listQuery is a parsed and validated list request
+type listQuery struct {
+	Limit   int       // 0 means no limit
+	After   []*string // Sort keys and tie-breaker of the last row of the previous page, as text; nil for the first page
+	Sort    []string  // API field names, '-' prefixed when descending
+	Filters map[string][]interface{}
+	Q       string
+
+	IncludeArchived bool
+}
+
+// parseListQuery validates the list parameters of r against spec
+func parseListQuery(r *http.Request, spec listSpec) (*listQuery, error) {
+	values := r.URL.Query()
+	lq := &listQuery{Filters: map[string][]interface{}{}, Q: strings.TrimSpace(values.Get("q"))}
+
+	if limitStr := values.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		lq.Limit = limit
+	}
+
+	if archived := values.Get("include_archived"); archived != "" {
+		include, err := strconv.ParseBool(archived)
+		if err != nil {
+			return nil, fmt.Errorf("include_archived must be true or false")
+		}
+		lq.IncludeArchived = include
+	}
+
+	sort := values.Get("sort")
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	for _, key := range strings.Split(sort, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if _, ok := spec.Fields[strings.TrimPrefix(key, "-")]; !ok {
+			return nil, fmt.Errorf("cannot sort on %q", strings.TrimPrefix(key, "-"))
+		}
+		lq.Sort = append(lq.Sort, key)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor, lq.Sort)
+		if err != nil {
+			return nil, err
+		}
+		lq.After = after
+	}
+
+	for name, rawValues := range values {
+		if listParams[name] {
+			continue
+		}
+		field, ok := spec.Fields[name]
+		if !ok {
+			continue // Unknown parameters are left to the handler
+		}
+		for _, raw := range rawValues {
+			value, err := parseFilterValue(field.Kind, raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s", raw, name)
+			}
+			lq.Filters[name] = append(lq.Filters[name], value)
+		}
+	}
+	return lq, nil
+}
+
+func parseFilterValue(kind fieldKind, raw string) (interface{}, error) {
+	switch kind {
+	case fieldInt:
+		return strconv.ParseInt(raw, 10, 64)
+	case fieldFloat:
+		return strconv.ParseFloat(raw, 64)
+	case fieldBool:
+		return strconv.ParseBool(raw)
+	}
+	return raw, nil
+}
+
+// where returns the filter and search conditions as " AND ..." (or "" when there are none),
+// appending their parameters to args.
+func (lq *listQuery) where(spec listSpec, args *[]interface{}) string {
+	var conditions []string
+	if spec.Archived != "" && !lq.IncludeArchived {
+		conditions = append(conditions, spec.Archived+" IS NULL")
+	}
+	for name, values := range lq.Filters {
+		column := spec.Fields[name].Column
+		if len(values) == 1 {
+			*args = append(*args, values[0])
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(*args)))
+			continue
+		}
+		*args = append(*args, filterArray(spec.Fields[name].Kind, values))
+		conditions = append(conditions, fmt.Sprintf("%s = ANY($%d)", column, len(*args)))
+	}
+
+	if lq.Q != "" && len(spec.Search) > 0 {
+		*args = append(*args, "%"+escapeLike(lq.Q)+"%")
+		var matches []string
+		for _, column := range spec.Search {
+			matches = append(matches, fmt.Sprintf("%s ILIKE $%d", column, len(*args)))
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " AND " + strings.Join(conditions, " AND ")
+}
+
+// sortKey is a column of the ORDER BY clause
+type sortKey struct {
+	column  string
+	desc    bool
+	notNull bool // The tie-breaker, which is never NULL
+}
+
+// sortKeys returns the columns lq sorts on, always ending with the spec's tie-breaker
+func (lq *listQuery) sortKeys(spec listSpec) []sortKey {
+	var keys []sortKey
+	for _, key := range lq.Sort {
+		name := strings.TrimPrefix(key, "-")
+		keys = append(keys, sortKey{column: spec.Fields[name].Column, desc: name != key})
+	}
+	return append(keys, sortKey{column: spec.TieBreaker, notNull: true})
+}
+
+// orderBy returns the ORDER BY clause, always ending with the spec's tie-breaker
+func (lq *listQuery) orderBy(spec listSpec) string {
+	var keys []string
+	for _, key := range lq.sortKeys(spec) {
+		direction := "ASC"
+		if key.desc {
+			direction = "DESC"
+		}
+		keys = append(keys, key.column+" "+direction)
+	}
+	return " ORDER BY " + strings.Join(keys, ", ")
+}
+
+// after returns the keyset condition " AND ..." selecting the rows that sort after the cursor (or "" without
+// one), appending its parameters to args. Like ORDER BY, it puts NULLs last ascending and first descending.
+func (lq *listQuery) after(spec listSpec, args *[]interface{}) string {
+	if lq.After == nil {
+		return ""
+	}
+	var equal, alternatives []string
+	for i, key := range lq.sortKeys(spec) {
+		value := lq.After[i]
+		var placeholder, past string
+		if value != nil {
+			*args = append(*args, *value)
+			placeholder = fmt.Sprintf("$%d", len(*args))
+		}
+		switch {
+		case value == nil && key.desc:
+			past = key.column + " IS NOT NULL"
+		case value == nil:
+			// Nothing sorts after NULL ascending
+		case key.desc:
+			past = key.column + " < " + placeholder
+		case key.notNull:
+			past = key.column + " > " + placeholder
+		default:
+			past = "(" + key.column + " > " + placeholder + " OR " + key.column + " IS NULL)"
+		}
+		// Rows equal to the cursor on the previous keys and past it on this one
+		if past != "" {
+			alternatives = append(alternatives, strings.Join(append(equal[:len(equal):len(equal)], past), " AND "))
+		}
+		if value == nil {
+			equal = append(equal, key.column+" IS NULL")
+		} else {
+			equal = append(equal, key.column+" = "+placeholder)
+		}
+	}
+	if len(alternatives) == 0 {
+		return " AND FALSE"
+	}
+	return " AND (" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// page returns the LIMIT clause
+func (lq *listQuery) page() string {
+	if lq.Limit == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d", lq.Limit)
+}
+
+// cursorAfterPage returns the cursor of the page after the one lq selects, or "" when it is the last page.
+// from is the "FROM ... WHERE ..." part of the list query with its conditions, whose parameters are args.
+func (lq *listQuery) cursorAfterPage(ctx context.Context, db dbtx, spec listSpec, from string, args []interface{}) (string, error) {
+	if lq.Limit == 0 {
+		return "", nil
+	}
+	keys := lq.sortKeys(spec)
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = "(" + key.column + ")::text"
+	}
+	// The last row of the page, and whether another one follows it
+	query := "SELECT " + strings.Join(columns, ", ") + " " + from + lq.after(spec, &args) + lq.orderBy(spec) +
+		fmt.Sprintf(" LIMIT 2 OFFSET %d", lq.Limit-1)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var last []*string
+	found := 0
+	for rows.Next() {
+		found++
+		if found > 1 {
+			continue
+		}
+		values := make([]sql.NullString, len(keys))
+		dest := make([]interface{}, len(keys))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		for _, v := range values {
+			if v.Valid {
+				value := v.String
+				last = append(last, &value)
+			} else {
+				last = append(last, nil)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil || found < 2 {
+		return "", err
+	}
+	return encodeCursor(lq.Sort, last), nil
+}
+
+// nextCursor returns the cursor of the page after the one lq selects, or "" when it is the last page, where
+// from is the "FROM ... WHERE ..." part of the list query
+func nextCursor(ctx context.Context, db dbtx, from string, lq *listQuery, spec listSpec) (string, error) {
+	var args []interface{}
+	return lq.cursorAfterPage(ctx, db, spec, from+lq.where(spec, &args), args)
+}
+
+// writeListHeaders sets X-Total-Count and, when there are more rows, X-Next-Cursor and Link
+func (lq *listQuery) writeListHeaders(w http.ResponseWriter, r *http.Request, total int64, cursor string) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if cursor == "" {
+		return
+	}
+	w.Header().Set("X-Next-Cursor", cursor)
+
+	next := url.URL{Path: r.URL.Path}
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+}
+
+// listCursor is the content of the opaque cursors of the list endpoints: the sort of the list, and the sort keys
+// and tie-breaker of the last row of a page as text
+type listCursor struct {
+	Sort string    `json:"s"`
+	Keys []*string `json:"k"`
+}
+
+func encodeCursor(sort []string, keys []*string) string {
+	raw, _ := json.Marshal(listCursor{Sort: strings.Join(sort, ","), Keys: keys})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor decodes a cursor issued for a list with the given sort, returning its keys
+func decodeCursor(encoded string, sort []string) ([]*string, error) {
+	var c listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(raw, &c) != nil || len(c.Keys) != len(sort)+1 || c.Keys[len(sort)] == nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if c.Sort != strings.Join(sort, ",") {
+		return nil, fmt.Errorf("the cursor belongs to a list sorted on %q, not %q", c.Sort, strings.Join(sort, ","))
+	}
+	return c.Keys, nil
+}
+
+func filterArray(kind fieldKind, values []interface{}) interface{} {
+	switch kind {
+	case fieldInt:
+		array := make([]int64, len(values))
+		for i, v := range values {
+			array[i] = v.(int64)
+		}
+		return pq.Array(array)
+	case fieldFloat:
+		array := make([]float64, len(values))
+		for i, v := range values {
+			array[i] = v.(float64)
+		}
+		return pq.Array(array)
+	case fieldBool:
+		array := make([]bool, len(values))
+		for i, v := range values {
+			array[i] = v.(bool)
+		}
+		return pq.Array(array)
+	}
+	array := make([]string, len(values))
+	for i, v := range values {
+		array[i] = v.(string)
+	}
+	return pq.Array(array)
+}
+
+// escapeLike escapes the LIKE wildcards in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// countRows returns the number of rows matching lq, where from is the "FROM ... WHERE ..." part of the list query
+func countRows(ctx context.Context, db dbtx, from string, lq *listQuery, spec listSpec) (int64, error) {
+	var args []interface{}
+	query := "SELECT COUNT(*) " + from + lq.where(spec, &args)
+	var total int64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseListQuery(t *testing.T) {
+	acme := "Acme"
+	id := "12"
+	tests := []struct {
+		name    string
+		query   string
+		want    *listQuery
+		wantErr string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  &listQuery{Sort: []string{"name"}, Filters: map[string][]interface{}{}},
+		},
+		{
+			name:  "page, sort, search and filters",
+			query: "limit=50&sort=-name,id&q=+acme+&id=3&id=4&vat_number=IT01234567897&include_archived=true&unknown=x",
+			want: &listQuery{
+				Limit:           50,
+				Sort:            []string{"-name", "id"},
+				Filters:         map[string][]interface{}{"id": {int64(3), int64(4)}, "vat_number": {"IT01234567897"}},
+				Q:               "acme",
+				IncludeArchived: true,
+			},
+		},
+		{
+			name:  "cursor",
+			query: "limit=2&cursor=" + encodeCursor([]string{"name"}, []*string{&acme, &id}),
+			want:  &listQuery{Limit: 2, After: []*string{&acme, &id}, Sort: []string{"name"}, Filters: map[string][]interface{}{}},
+		},
+		{name: "zero limit", query: "limit=0", wantErr: "limit must be between 1 and 1000"},
+		{name: "negative limit", query: "limit=-5", wantErr: "limit must be between 1 and 1000"},
+		{name: "limit over the maximum", query: "limit=1001", wantErr: "limit must be between 1 and 1000"},
+		{name: "limit not a number", query: "limit=ten", wantErr: "limit must be between 1 and 1000"},
+		{name: "unknown sort field", query: "sort=-password", wantErr: `cannot sort on "password"`},
+		{name: "filter of the wrong kind", query: "id=abc", wantErr: `invalid value "abc" for id`},
+		{name: "include_archived not a boolean", query: "include_archived=maybe", wantErr: "include_archived must be true or false"},
+		{name: "cursor not base64", query: "cursor=not*base64", wantErr: "invalid cursor"},
+		{name: "offset cursor", query: "cursor=" + base64.RawURLEncoding.EncodeToString([]byte("o:-5")), wantErr: "invalid cursor"},
+		{name: "cursor without tie-breaker", query: "cursor=" + encodeCursor([]string{"name"}, []*string{&acme, nil}), wantErr: "invalid cursor"},
+		{name: "cursor with missing keys", query: "cursor=" + encodeCursor([]string{"name"}, []*string{&id}), wantErr: "invalid cursor"},
+		{
+			name:    "cursor of another sort",
+			query:   "sort=address&cursor=" + encodeCursor([]string{"name"}, []*string{&acme, &id}),
+			wantErr: `the cursor belongs to a list sorted on "name", not "address"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/accounts?"+tt.query, nil)
+			got, err := parseListQuery(r, accountListSpec)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListQueryAfter(t *testing.T) {
+	acme := "Acme"
+	id := "12"
+	tests := []struct {
+		name  string
+		sort  []string
+		after []*string
+		where string
+		args  []interface{}
+	}{
+		{name: "first page", sort: []string{"name"}},
+		{
+			name:  "ascending, NULLs last",
+			sort:  []string{"address"},
+			after: []*string{&acme, &id},
+			where: " AND ((address > $1 OR address IS NULL) OR address = $1 AND id > $2)",
+			args:  []interface{}{"Acme", "12"},
+		},
+		{
+			name:  "ascending after a NULL",
+			sort:  []string{"address"},
+			after: []*string{nil, &id},
+			where: " AND (address IS NULL AND id > $1)",
+			args:  []interface{}{"12"},
+		},
+		{
+			name:  "descending, NULLs first",
+			sort:  []string{"-address"},
+			after: []*string{&acme, &id},
+			where: " AND (address < $1 OR address = $1 AND id > $2)",
+			args:  []interface{}{"Acme", "12"},
+		},
+		{
+			name:  "descending after a NULL",
+			sort:  []string{"-address"},
+			after: []*string{nil, &id},
+			where: " AND (address IS NOT NULL OR address IS NULL AND id > $1)",
+			args:  []interface{}{"12"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lq := &listQuery{Sort: tt.sort, After: tt.after}
+			var args []interface{}
+			if where := lq.after(accountListSpec, &args); where != tt.where {
+				t.Errorf("after = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestListQueryOrderByAndPage(t *testing.T) {
+	lq := &listQuery{Limit: 25, Sort: []string{"-name", "address"}}
+	if got, want := lq.orderBy(accountListSpec), " ORDER BY name DESC, address ASC, id ASC"; got != want {
+		t.Errorf("orderBy = %q, want %q", got, want)
+	}
+	if got, want := lq.page(), " LIMIT 25"; got != want {
+		t.Errorf("page = %q, want %q", got, want)
+	}
+	if got := (&listQuery{}).page(); got != "" {
+		t.Errorf("page without limit = %q, want none", got)
+	}
+}
+
+func TestWriteListHeaders(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/accounts?limit=2&q=acme", nil)
+	lq := &listQuery{Limit: 2}
+
+	w := httptest.NewRecorder()
+	lq.writeListHeaders(w, r, 5, "abc")
+	if got := w.Header().Get("X-Total-Count"); got != "5" {
+		t.Errorf("X-Total-Count = %q, want 5", got)
+	}
+	if got := w.Header().Get("X-Next-Cursor"); got != "abc" {
+		t.Errorf("X-Next-Cursor = %q, want abc", got)
+	}
+	if got := w.Header().Get("Link"); !strings.Contains(got, "cursor=abc") || !strings.Contains(got, "q=acme") || !strings.HasSuffix(got, `rel="next"`) {
+		t.Errorf("Link = %q, want the same query with the cursor", got)
+	}
+
+	w = httptest.NewRecorder()
+	lq.writeListHeaders(w, r, 2, "")
+	if got := w.Header().Get("X-Next-Cursor") + w.Header().Get("Link"); got != "" {
+		t.Errorf("last page has next page headers %q", got)
+	}
+}
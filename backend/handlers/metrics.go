@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"electronic_kanban_backend/utils"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes every metric exported by the backend
+const metricsNamespace = "electronic_kanban"
+
+// metricsQueryTimeout bounds the business queries of a scrape, so a slow database can't pile up scrapes
+const metricsQueryTimeout = 5 * time.Second
+
+// httpRequestDuration is the latency of the API per route template, method and status
+var httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metricsNamespace,
+	Name:      "http_request_duration_seconds",
+	Help:      "Latency of HTTP requests by route template, method and status.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route", "method", "status"})
+
+// MetricsMiddleware records the latency of every routed request. It is a mux middleware, so the route
+// template is known and IDs in the path don't multiply the series. Unmatched paths never reach it.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// MetricsHandler returns a handler for GET /metrics exposing the HTTP latency, the connection pool of db,
+// the Go runtime and the kanban gauges, in the Prometheus text format
+func MetricsHandler(db *sql.DB) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "postgres"),
+		httpRequestDuration,
+		newKanbanCollector(db),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorLog:      slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+		ErrorHandling: promhttp.ContinueOnError, // Keep serving the pool and runtime metrics while the database is down
+	})
+}
+
+// kanbanCollector reads the business gauges from the database at every scrape, so they are right
+// whichever instance served the writes
+type kanbanCollector struct {
+	db             *sql.DB
+	activeByStatus *prometheus.Desc
+	activeBySupp   *prometheus.Desc
+	activeByWC     *prometheus.Desc
+	overdue        *prometheus.Desc
+	transitions    *prometheus.Desc
+}
+
+func newKanbanCollector(db *sql.DB) *kanbanCollector {
+	return &kanbanCollector{
+		db: db,
+		activeByStatus: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "kanbans", "active_by_status"),
+			"Active kanban cards by current status.", []string{"status_id", "status"}, nil),
+		activeBySupp: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "kanbans", "active_by_supplier"),
+			"Active kanban cards by supplier account.", []string{"supplier_id", "supplier"}, nil),
+		activeByWC: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "kanbans", "active_by_work_centre"),
+			"Active kanban cards by supplying work centre.", []string{"work_centre_id", "work_centre"}, nil),
+		overdue: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "kanbans", "overdue"),
+			"Active kanban cards that have not moved for longer than their lead time.", nil, nil),
+		transitions: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "kanbans", "transitions_per_minute"),
+			"Kanban status changes recorded in the last minute.", nil, nil),
+	}
+}
+
+func (c *kanbanCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeByStatus
+	ch <- c.activeBySupp
+	ch <- c.activeByWC
+	ch <- c.overdue
+	ch <- c.transitions
+}
+
+// Collect runs one query per gauge, over the kanbans of every tenant. A failing query is reported as an invalid
+// metric, which drops that gauge from the scrape rather than exporting a misleading zero.
+func (c *kanbanCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(utils.WithAllTenants(context.Background()), metricsQueryTimeout)
+	defer cancel()
+
+	c.collectLabelled(ctx, ch, c.activeByStatus, `
+		SELECT s.status_id, s.name, COUNT(*)
+		FROM kanbans k
+		JOIN statuses s ON k.status_current = s.status_id
+		WHERE k.is_active = true
+		GROUP BY s.status_id, s.name`)
+	c.collectLabelled(ctx, ch, c.activeBySupp, `
+		SELECT a.id, a.name, COUNT(*)
+		FROM kanbans k
+		JOIN kanban_chains kc ON k.kanban_chain_id = kc.id
+		JOIN accounts a ON kc.fornitore_id = a.id
+		WHERE k.is_active = true
+		GROUP BY a.id, a.name`)
+	c.collectLabelled(ctx, ch, c.activeByWC, `
+		SELECT wc.id, wc.name, COUNT(*)
+		FROM kanbans k
+		JOIN kanban_chains kc ON k.kanban_chain_id = kc.id
+		JOIN work_centres wc ON kc.supplier_work_centre_id = wc.id
+		WHERE k.is_active = true
+		GROUP BY wc.id, wc.name`)
+	c.collectCount(ctx, ch, c.overdue, `
+		SELECT COUNT(*)
+		FROM kanbans
+		WHERE is_active = true AND data_aggiornamento + leadtime_days * INTERVAL '1 day' < NOW()`)
+	c.collectCount(ctx, ch, c.transitions, `
+		SELECT COUNT(*)
+		FROM kanban_histories
+		WHERE data_aggiornamento > NOW() - INTERVAL '1 minute'`)
+}
+
+// collectLabelled exports one gauge per row of query, which selects the id, the name and the count
+func (c *kanbanCollector) collectLabelled(ctx context.Context, ch chan<- prometheus.Metric, desc *prometheus.Desc, query string) {
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		c.fail(ch, desc, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name string
+		var count float64
+		if err := rows.Scan(&id, &name, &count); err != nil {
+			c.fail(ch, desc, err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, count, strconv.FormatInt(id, 10), name)
+	}
+	if err := rows.Err(); err != nil {
+		c.fail(ch, desc, err)
+	}
+}
+
+// collectCount exports the single count selected by query
+func (c *kanbanCollector) collectCount(ctx context.Context, ch chan<- prometheus.Metric, desc *prometheus.Desc, query string) {
+	var count float64
+	if err := c.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		c.fail(ch, desc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, count)
+}
+
+func (c *kanbanCollector) fail(ch chan<- prometheus.Metric, desc *prometheus.Desc, err error) {
+	ch <- prometheus.NewInvalidMetric(desc, err)
+}
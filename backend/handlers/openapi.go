@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"electronic_kanban_backend/models"
+
+	"github.com/gorilla/mux"
+)
+
+// apiOperation documents a route for the OpenAPI spec. Request and Response are zero values of the
+// body types; the schemas are generated from their json tags, so the spec can't drift from the code.
+type apiOperation struct {
+	Summary      string
+	Tag          string
+	List         *listSpec   // Set on list endpoints, documents the list query parameters
+	Query        []apiParam  // Extra query parameters
+	Request      interface{} // JSON request body, nil when there is none
+	Response     interface{} // JSON response body
+	Status       int         // Success status, 200 when not set
+	Upload       bool        // Request is a CSV/XLSX file upload instead of JSON
+	Download     bool        // Response is a CSV/XLSX file
+	ContentType  string      // Type of a response that is neither JSON nor a file, like an HTML page
+	ErrorStatus  []int       // Error statuses beyond the defaults (400, 500, 404 with a path parameter, 409/422 on writes)
+	ReportStatus []int       // Failure statuses that still return the Response body, like a rejected import
+}
+
+// apiParam is a documented query parameter
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+var (
+	dryRunParam     = apiParam{"dry_run", "boolean", "Validate the input and report the result without saving anything"}
+	exportFormatArg = apiParam{"format", "string", "csv (default) or xlsx"}
+)
+
+// apiOperations documents every route, keyed by "METHOD /path" as registered on the router.
+// PATCH routes share the documentation of the PUT route on the same path.
+var apiOperations = map[string]apiOperation{
+	"GET /api/accounts":                              {Summary: "List accounts", Tag: "Accounts", List: &accountListSpec, Response: []models.Account{}},
+	"POST /api/accounts":                             {Summary: "Create an account", Tag: "Accounts", Request: models.Account{}, Response: models.Account{}, Status: http.StatusCreated},
+	"GET /api/accounts/export":                       {Summary: "Export accounts as CSV or XLSX", Tag: "Accounts", Query: []apiParam{exportFormatArg}, Download: true},
+	"POST /api/accounts/import":                      {Summary: "Import accounts from CSV or XLSX, upserting by VAT number", Tag: "Accounts", Query: []apiParam{dryRunParam}, Upload: true, Response: importResult{}, ReportStatus: []int{http.StatusUnprocessableEntity}},
+	"GET /api/accounts/{id}":                         {Summary: "Get an account", Tag: "Accounts", Response: models.Account{}},
+	"PUT /api/accounts/{id}":                         {Summary: "Update an account", Tag: "Accounts", Request: models.Account{}, Response: models.Account{}},
+	"DELETE /api/accounts/{id}":                      {Summary: "Archive an account, refused while kanban chains use it", Tag: "Accounts", Response: models.MessageResponse{}},
+	"POST /api/accounts/{id}/restore":                {Summary: "Restore an archived account", Tag: "Accounts", Response: models.Account{}},
+	"GET /api/accounts/{id}/contacts":                {Summary: "List the contacts of an account", Tag: "Accounts", List: &contactListSpec, Response: []models.Contact{}},
+	"POST /api/accounts/{id}/contacts":               {Summary: "Create a contact of an account, refused while the account is archived", Tag: "Accounts", Request: models.Contact{}, Response: models.Contact{}, Status: http.StatusCreated},
+	"GET /api/accounts/{id}/contacts/{contactId}":    {Summary: "Get a contact of an account", Tag: "Accounts", Response: models.Contact{}},
+	"PUT /api/accounts/{id}/contacts/{contactId}":    {Summary: "Update a contact and their notification preferences", Tag: "Accounts", Request: models.Contact{}, Response: models.Contact{}},
+	"DELETE /api/accounts/{id}/contacts/{contactId}": {Summary: "Delete a contact", Tag: "Accounts", Response: models.MessageResponse{}},
+
+	"GET /api/sites":               {Summary: "List sites, filter on account_id for the sites of an account", Tag: "Sites", List: &siteListSpec, Response: []models.Site{}},
+	"POST /api/sites":              {Summary: "Create a site of an account", Tag: "Sites", Request: models.Site{}, Response: models.Site{}, Status: http.StatusCreated},
+	"GET /api/sites/{id}":          {Summary: "Get a site", Tag: "Sites", Response: models.Site{}},
+	"PUT /api/sites/{id}":          {Summary: "Update the name or address of a site", Tag: "Sites", Request: models.Site{}, Response: models.Site{}},
+	"DELETE /api/sites/{id}":       {Summary: "Archive a site, refused while kanban chains use it", Tag: "Sites", Response: models.MessageResponse{}},
+	"POST /api/sites/{id}/restore": {Summary: "Restore an archived site", Tag: "Sites", Response: models.Site{}},
+
+	"GET /api/work-centres":               {Summary: "List work centres", Tag: "Work Centres", List: &workCentreListSpec, Response: []models.WorkCentre{}},
+	"POST /api/work-centres":              {Summary: "Create a work centre", Tag: "Work Centres", Request: models.WorkCentre{}, Response: models.WorkCentre{}, Status: http.StatusCreated},
+	"GET /api/work-centres/{id}":          {Summary: "Get a work centre", Tag: "Work Centres", Response: models.WorkCentre{}},
+	"PUT /api/work-centres/{id}":          {Summary: "Update the code or name of a work centre", Tag: "Work Centres", Request: models.WorkCentre{}, Response: models.WorkCentre{}},
+	"DELETE /api/work-centres/{id}":       {Summary: "Archive a work centre, refused while kanban chains use it", Tag: "Work Centres", Response: models.MessageResponse{}},
+	"POST /api/work-centres/{id}/restore": {Summary: "Restore an archived work centre", Tag: "Work Centres", Response: models.WorkCentre{}},
+
+	"GET /api/products":               {Summary: "List products", Tag: "Products", List: &productListSpec, Response: []models.Product{}},
+	"POST /api/products":              {Summary: "Create a product", Tag: "Products", Request: models.Product{}, Response: models.Product{}, Status: http.StatusCreated},
+	"GET /api/products/export":        {Summary: "Export products as CSV or XLSX", Tag: "Products", Query: []apiParam{exportFormatArg}, Download: true},
+	"POST /api/products/import":       {Summary: "Import products from CSV or XLSX, upserting by product_id", Tag: "Products", Query: []apiParam{dryRunParam}, Upload: true, Response: importResult{}, ReportStatus: []int{http.StatusUnprocessableEntity}},
+	"GET /api/products/{id}":          {Summary: "Get a product", Tag: "Products", Response: models.Product{}},
+	"PUT /api/products/{id}":          {Summary: "Update a product", Tag: "Products", Request: models.Product{}, Response: models.Product{}},
+	"DELETE /api/products/{id}":       {Summary: "Archive a product, refused while kanban chains use it", Tag: "Products", Response: models.MessageResponse{}},
+	"POST /api/products/{id}/restore": {Summary: "Restore an archived product", Tag: "Products", Response: models.Product{}},
+
+	"GET /api/statuses":               {Summary: "List statuses", Tag: "Statuses", List: &statusListSpec, Response: []models.Status{}},
+	"POST /api/statuses":              {Summary: "Create a status", Tag: "Statuses", Request: models.Status{}, Response: models.Status{}, Status: http.StatusCreated},
+	"GET /api/statuses/{id}":          {Summary: "Get a status", Tag: "Statuses", Response: models.Status{}},
+	"PUT /api/statuses/{id}":          {Summary: "Update a status", Tag: "Statuses", Request: models.Status{}, Response: models.Status{}},
+	"DELETE /api/statuses/{id}":       {Summary: "Archive a status, refused while status chains use it", Tag: "Statuses", Response: models.MessageResponse{}},
+	"POST /api/statuses/{id}/restore": {Summary: "Restore an archived status", Tag: "Statuses", Response: models.Status{}},
+
+	"GET /api/status-chains":                                        {Summary: "List status chains", Tag: "Status Chains", List: &statusChainListSpec, Response: []models.StatusChain{}},
+	"POST /api/status-chains":                                       {Summary: "Create a status chain with its statuses", Tag: "Status Chains", Request: statusChainCreateRequest{}, Response: models.StatusChain{}, Status: http.StatusCreated},
+	"GET /api/status-chains/{id}":                                   {Summary: "Get a status chain", Tag: "Status Chains", Response: models.StatusChain{}},
+	"PUT /api/status-chains/{id}":                                   {Summary: "Rename a status chain", Tag: "Status Chains", Request: models.StatusChain{}, Response: models.StatusChain{}},
+	"DELETE /api/status-chains/{id}":                                {Summary: "Archive a status chain, refused while kanban chains use it", Tag: "Status Chains", Response: models.MessageResponse{}},
+	"POST /api/status-chains/{id}/restore":                          {Summary: "Restore an archived status chain", Tag: "Status Chains", Response: models.StatusChain{}},
+	"GET /api/status-chains/{statusChainId}/statuses":               {Summary: "List the statuses of a status chain, in order", Tag: "Status Chains", Response: []models.StatusChainStatusItem{}},
+	"PUT /api/status-chains/{statusChainId}/statuses":               {Summary: "Replace the statuses of a status chain", Tag: "Status Chains", Request: []models.StatusChainStatus{}, Response: []models.StatusChainStatusItem{}},
+	"DELETE /api/status-chains/{statusChainId}/statuses/{statusId}": {Summary: "Remove a status from a status chain", Tag: "Status Chains", Response: models.MessageResponse{}},
+
+	"GET /api/kanban-chains":               {Summary: "List kanban chains", Tag: "Kanban Chains", List: &kanbanChainListSpec, Response: []models.KanbanChainListItem{}},
+	"POST /api/kanban-chains":              {Summary: "Create a kanban chain and its initial cards", Tag: "Kanban Chains", Request: kanbanChainRequest{}, Response: models.KanbanChainResponse{}, Status: http.StatusCreated},
+	"GET /api/kanban-chains/export":        {Summary: "Export kanban chains as CSV or XLSX", Tag: "Kanban Chains", Query: []apiParam{exportFormatArg}, Download: true},
+	"POST /api/kanban-chains/import":       {Summary: "Import kanban chains from CSV or XLSX", Tag: "Kanban Chains", Query: []apiParam{dryRunParam}, Upload: true, Response: importResult{}, ReportStatus: []int{http.StatusUnprocessableEntity}},
+	"GET /api/kanban-chains/{id}":          {Summary: "Get a kanban chain", Tag: "Kanban Chains", Response: models.KanbanChainResponse{}},
+	"PUT /api/kanban-chains/{id}":          {Summary: "Update a kanban chain, propagate the change to its kanbans and add cards", Tag: "Kanban Chains", Request: kanbanChainRequest{}, Response: models.KanbanChainResponse{}},
+	"DELETE /api/kanban-chains/{id}":       {Summary: "Archive a kanban chain, retiring its idle cards; refused while cards are in flight", Tag: "Kanban Chains", Response: models.MessageResponse{}},
+	"POST /api/kanban-chains/{id}/restore": {Summary: "Restore an archived kanban chain", Tag: "Kanban Chains", Response: models.KanbanChainResponse{}},
+	"GET /api/kanban-chains/{id}/changes":  {Summary: "Changes of a kanban chain and their propagation to its kanbans, newest first", Tag: "Kanban Chains", Response: []models.KanbanChainChange{}},
+
+	"GET /api/kanbans":                  {Summary: "List active kanbans", Tag: "Kanbans", List: &kanbanListSpec, Response: []models.KanbanListItem{}},
+	"POST /api/kanbans":                 {Summary: "Create a kanban", Tag: "Kanbans", Request: models.Kanban{}, Response: models.KanbanResponse{}, Status: http.StatusCreated},
+	"GET /api/kanbans/{id}":             {Summary: "Get a kanban", Tag: "Kanbans", Response: models.KanbanResponse{}},
+	"PUT /api/kanbans/{id}":             {Summary: "Edit the leadtime, container type or quantity of a kanban", Tag: "Kanbans", Request: kanbanEditRequest{}, Response: models.KanbanResponse{}},
+	"PUT /api/kanbans/{id}/status":      {Summary: "Move a kanban to the next status of its chain, refused for retired kanbans", Tag: "Kanbans", Response: models.KanbanResponse{}},
+	"DELETE /api/kanbans/{id}":          {Summary: "Retire a kanban, which reactivate puts back in circulation", Tag: "Kanbans", Response: models.MessageResponse{}},
+	"GET /api/kanbans/retired":          {Summary: "List retired kanbans, last retired first", Tag: "Kanbans", List: &retiredKanbanListSpec, Response: []models.RetiredKanbanListItem{}},
+	"POST /api/kanbans/{id}/reactivate": {Summary: "Put a retired kanban back in circulation with the current values of its chain", Tag: "Kanbans", Response: models.KanbanResponse{}},
+
+	"GET /api/dashboards/supplier/{supplierId}":                {Summary: "Kanbans a supplier has to serve, filtered and grouped", Tag: "Dashboards", Query: dashboardQueryParams(dashboardSupplier), Response: models.SupplierDashboardResponse{}},
+	"GET /api/dashboards/customer/{customerId}":                {Summary: "Kanbans a customer is waiting for, filtered and grouped", Tag: "Dashboards", Query: dashboardQueryParams(dashboardCustomer), Response: models.CustomerDashboardResponse{}},
+	"GET /api/dashboards/work-centres/{workCentreId}/supplier": {Summary: "Kanbans a work centre has to serve, filtered and grouped", Tag: "Dashboards", Query: dashboardQueryParams(dashboardSupplier), Response: models.SupplierDashboardResponse{}},
+	"GET /api/dashboards/work-centres/{workCentreId}/customer": {Summary: "Kanbans a work centre is waiting for, filtered and grouped", Tag: "Dashboards", Query: dashboardQueryParams(dashboardCustomer), Response: models.CustomerDashboardResponse{}},
+	"GET /api/dashboard-views":                                 {Summary: "List the caller's saved dashboard views", Tag: "Dashboards", Query: []apiParam{{"dashboard", "string", "Only the views of this dashboard: supplier or customer"}}, Response: []models.DashboardView{}},
+	"PUT /api/dashboard-views/{dashboard}/{name}":              {Summary: "Create or replace a saved view of the caller", Tag: "Dashboards", Request: dashboardViewRequest{}, Response: models.DashboardView{}},
+	"DELETE /api/dashboard-views/{dashboard}/{name}":           {Summary: "Delete a saved view of the caller", Tag: "Dashboards", Response: models.MessageResponse{}},
+
+	"GET /api/config/export":  {Summary: "Export the whole configuration as a bundle", Tag: "Configuration", Response: models.ConfigBundle{}, ErrorStatus: []int{http.StatusConflict}},
+	"POST /api/config/import": {Summary: "Import a configuration bundle", Tag: "Configuration", Query: []apiParam{dryRunParam, {"on_conflict", "string", "fail (default), skip or overwrite"}}, Request: models.ConfigBundle{}, Response: configImportReport{}, ReportStatus: []int{http.StatusConflict, http.StatusUnprocessableEntity}},
+
+	"GET /api/audit":        {Summary: "List audit log entries, newest first", Tag: "Audit", List: &auditListSpec, Query: auditQueryParams, Response: []models.AuditEntry{}},
+	"GET /api/audit/verify": {Summary: "Check the hash chain of the audit log", Tag: "Audit", Response: models.AuditVerification{}},
+
+	"GET /api/wallboard":        {Summary: "Active cards of every kanban chain, for the shop-floor wallboard", Tag: "Wallboard", Query: wallboardQueryParams, Response: models.WallboardResponse{}, ErrorStatus: []int{http.StatusUnauthorized}},
+	"GET /api/wallboard/events": {Summary: "Push channel of the wallboard: a change event whenever a record changes", Tag: "Wallboard", Query: wallboardQueryParams[:1], ContentType: "text/event-stream", ErrorStatus: []int{http.StatusUnauthorized}},
+	"GET /wallboard":            {Summary: "Wallboard page for large screens, cycling through the kanban chains", Tag: "Wallboard", Query: wallboardQueryParams, ContentType: "text/html", ErrorStatus: []int{http.StatusUnauthorized}},
+
+	"GET /api/openapi.json": {Summary: "This OpenAPI document", Tag: "Meta"},
+	"GET /healthz":          {Summary: "Liveness probe, succeeds while the process serves requests", Tag: "Meta", Response: models.HealthResponse{}},
+	"GET /readyz":           {Summary: "Readiness probe, fails with 503 while the database is unreachable", Tag: "Meta", Response: models.HealthResponse{}, ReportStatus: []int{http.StatusServiceUnavailable}},
+	"GET /metrics":          {Summary: "Prometheus metrics: HTTP latency, connection pool and kanban gauges", Tag: "Meta"},
+}
+
+// OpenAPIHandler returns a handler for GET /api/openapi.json, describing every route registered on router
+func OpenAPIHandler(router *mux.Router) http.HandlerFunc {
+	var (
+		once sync.Once
+		spec []byte
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { // Routes are all registered by the time the first request comes in
+			var err error
+			spec, err = json.Marshal(buildOpenAPISpec(router))
+			if err != nil {
+				requestLogger(r).Error("building the OpenAPI spec failed", "error", err)
+			}
+		})
+		if spec == nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to build the OpenAPI spec")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+func buildOpenAPISpec(router *mux.Router) map[string]interface{} {
+	schemas := schemaRegistry{}
+	paths := map[string]map[string]interface{}{}
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path = pathParamPattern.ReplaceAllString(path, "{$1}")
+		for _, method := range methods {
+			op, ok := apiOperations[method+" "+path]
+			if !ok && method == http.MethodPatch {
+				op, ok = apiOperations[http.MethodPut+" "+path]
+			}
+			if !ok {
+				slog.Warn("route has no OpenAPI documentation", "method", method, "path", path)
+				op = apiOperation{Summary: method + " " + path}
+			}
+			if paths[path] == nil {
+				paths[path] = map[string]interface{}{}
+			}
+			paths[path][strings.ToLower(method)] = op.document(method, path, schemas)
+		}
+		return nil
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Electronic Kanban API",
+			"version":     "1.0.0",
+			"description": "Italian DB column names (cliente_id, prodotto_codice, tipo_contenitore, ...) are kept for existing clients; new clients should use the English names returned alongside them. Every request works on the data of one tenant, named by the subdomain it is sent to or by the X-Tenant header set by the authenticating proxy.",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// document builds the OpenAPI operation object of op
+func (op apiOperation) document(method string, path string, schemas schemaRegistry) map[string]interface{} {
+	var parameters []interface{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name": match[1], "in": "path", "required": true, "schema": map[string]string{"type": "string"},
+		})
+	}
+	if op.List != nil {
+		parameters = append(parameters, listParameters(*op.List)...)
+	}
+	for _, p := range op.Query {
+		parameters = append(parameters, map[string]interface{}{
+			"name": p.Name, "in": "query", "description": p.Description, "schema": map[string]string{"type": p.Type},
+		})
+	}
+
+	doc := map[string]interface{}{"summary": op.Summary}
+	if op.Tag != "" {
+		doc["tags"] = []string{op.Tag}
+	}
+	if len(parameters) > 0 {
+		doc["parameters"] = parameters
+	}
+
+	switch {
+	case op.Upload:
+		fileSchema := map[string]interface{}{"type": "string", "format": "binary"}
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{"schema": map[string]interface{}{
+					"type": "object", "properties": map[string]interface{}{"file": fileSchema},
+				}},
+				"text/csv":      map[string]interface{}{"schema": fileSchema},
+				xlsxContentType: map[string]interface{}{"schema": fileSchema},
+			},
+		}
+	case op.Request != nil:
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(op.Request))}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	switch {
+	case op.Download:
+		fileSchema := map[string]interface{}{"type": "string", "format": "binary"}
+		success["content"] = map[string]interface{}{
+			"text/csv":      map[string]interface{}{"schema": fileSchema},
+			xlsxContentType: map[string]interface{}{"schema": fileSchema},
+		}
+	case op.ContentType != "":
+		success["content"] = map[string]interface{}{op.ContentType: map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+	case op.Response != nil:
+		success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(op.Response))}}
+	}
+	if op.List != nil {
+		success["headers"] = map[string]interface{}{
+			"X-Total-Count": map[string]interface{}{"description": "Number of rows matching the filters", "schema": map[string]string{"type": "integer"}},
+			"X-Next-Cursor": map[string]interface{}{"description": "Cursor of the next page, missing on the last page", "schema": map[string]string{"type": "string"}},
+			"Link":          map[string]interface{}{"description": "URL of the next page, with rel=\"next\"", "schema": map[string]string{"type": "string"}},
+		}
+	}
+
+	errorContent := map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(models.ErrorResponse{}))}}
+	responses := map[string]interface{}{statusKey(status): success}
+	for _, s := range op.ReportStatus {
+		responses[statusKey(s)] = map[string]interface{}{"description": http.StatusText(s), "content": success["content"]}
+	}
+	errorStatuses := []int{http.StatusBadRequest, http.StatusInternalServerError}
+	if strings.Contains(path, "{") {
+		errorStatuses = append(errorStatuses, http.StatusNotFound)
+	}
+	if method != http.MethodGet {
+		errorStatuses = append(errorStatuses, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+	for _, s := range append(errorStatuses, op.ErrorStatus...) {
+		if _, ok := responses[statusKey(s)]; !ok {
+			responses[statusKey(s)] = map[string]interface{}{"description": http.StatusText(s), "content": errorContent}
+		}
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
+
+// listParameters documents the list query parameters of spec
+func listParameters(spec listSpec) []interface{} {
+	var sortable []string
+	for name := range spec.Fields {
+		sortable = append(sortable, name)
+	}
+	sort.Strings(sortable)
+
+	parameters := []interface{}{
+		map[string]interface{}{"name": "limit", "in": "query", "description": "Page size, omit to get every row", "schema": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": maxListLimit}},
+		map[string]interface{}{"name": "cursor", "in": "query", "description": "X-Next-Cursor of the previous page", "schema": map[string]string{"type": "string"}},
+		map[string]interface{}{"name": "sort", "in": "query", "description": "Comma-separated fields, '-' prefixed for descending. Default: " + spec.DefaultSort + ". Fields: " + strings.Join(sortable, ", "), "schema": map[string]string{"type": "string"}},
+	}
+	if spec.Archived != "" {
+		parameters = append(parameters, map[string]interface{}{"name": "include_archived", "in": "query", "description": "Also list archived records", "schema": map[string]string{"type": "boolean"}})
+	}
+	if len(spec.Search) > 0 {
+		parameters = append(parameters, map[string]interface{}{"name": "q", "in": "query", "description": "Case-insensitive free-text search", "schema": map[string]string{"type": "string"}})
+	}
+	for _, name := range sortable {
+		parameters = append(parameters, map[string]interface{}{
+			"name": name, "in": "query", "description": "Exact match, repeat to match any of several values",
+			"schema": map[string]interface{}{"type": "array", "items": map[string]string{"type": fieldKindType(spec.Fields[name].Kind)}},
+			"style":  "form", "explode": true,
+		})
+	}
+	return parameters
+}
+
+func fieldKindType(kind fieldKind) string {
+	switch kind {
+	case fieldInt:
+		return "integer"
+	case fieldFloat:
+		return "number"
+	case fieldBool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// schemaRegistry collects the component schemas of the named struct types, keyed by type name
+type schemaRegistry map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of t, a $ref for named structs
+func (s schemaRegistry) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			s[name] = nil // Placeholder, for types that reference themselves
+			s[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaOf(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{} // interface{}: any value
+}
+
+// structSchema builds the object schema of a struct from its json tags, flattening embedded structs
+func (s schemaRegistry) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	s.addProperties(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (s schemaRegistry) addProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.addProperties(field.Type, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schemaOf(field.Type)
+	}
+}
+
+// schemaName returns the component name of a struct type, capitalised so unexported request types read like the models
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Object"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
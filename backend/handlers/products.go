@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"electronic_kanban_backend/models"
@@ -13,11 +15,28 @@ import (
 // GetProductsHandler returns a handler for GET /api/products
 func GetProductsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		products, err := getProducts(db)
+		ctx := r.Context()
+		lq, err := parseListQuery(r, productListSpec)
 		if err != nil {
-			http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
 			return
 		}
+		products, err := getProducts(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch products")
+			return
+		}
+		total, err := countRows(ctx, db, productsFrom, lq, productListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch products")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, productsFrom, lq, productListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch products")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(products)
 	}
@@ -26,16 +45,28 @@ func GetProductsHandler(db *sql.DB) http.HandlerFunc {
 // CreateProductHandler returns a handler for POST /api/products
 func CreateProductHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		var product models.Product
 		err := json.NewDecoder(r.Body).Decode(&product)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateProduct(&product); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 
-		newProduct, err := createProduct(db, product)
+		var newProduct *models.Product
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if newProduct, err = createProduct(ctx, tx, product); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditCreate, "product", newProduct.ProductID, nil, newProduct)
+		})
 		if err != nil {
-			http.Error(w, "Failed to create product", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to create product")
 			return
 		}
 
@@ -48,16 +79,13 @@ func CreateProductHandler(db *sql.DB) http.HandlerFunc {
 // GetProductHandler returns a handler for GET /api/products/{id}
 func GetProductHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		id := vars["id"] // Product ID is text, no need to parse to int
 
-		product, err := getProductByID(db, id)
+		product, err := getProductByID(ctx, db, id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				http.NotFound(w, r)
-			} else {
-				http.Error(w, "Failed to fetch product", http.StatusInternalServerError)
-			}
+			writeDBError(w, r, err, "Failed to fetch product")
 			return
 		}
 
@@ -69,20 +97,38 @@ func GetProductHandler(db *sql.DB) http.HandlerFunc {
 // UpdateProductHandler returns a handler for PUT/PATCH /api/products/{id}
 func UpdateProductHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		id := vars["id"] // Product ID is text
 
 		var productUpdates models.Product
 		err := json.NewDecoder(r.Body).Decode(&productUpdates)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateProduct(&productUpdates); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 		productUpdates.ProductID = id // Ensure ID from URL is used
 
-		updatedProduct, err := updateProduct(db, productUpdates)
+		var updatedProduct *models.Product
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousProduct, err := getProductByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if updatedProduct, err = updateProduct(ctx, tx, productUpdates); err != nil {
+				return err
+			}
+			if err := refuseUnconvertibleChains(ctx, tx, *updatedProduct); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditUpdate, "product", id, previousProduct, updatedProduct)
+		})
 		if err != nil {
-			http.Error(w, "Failed to update product", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update product")
 			return
 		}
 
@@ -91,86 +137,192 @@ func UpdateProductHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// DeleteProductHandler returns a handler for DELETE /api/products/{id}
+// DeleteProductHandler returns a handler for DELETE /api/products/{id}, which archives the product
 func DeleteProductHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		id := vars["id"] // Product ID is text
 
-		err := deleteProduct(db, id)
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			previousProduct, err := getProductByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := archiveProduct(ctx, tx, id); err != nil {
+				return err
+			}
+			archivedProduct, err := getProductByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditArchive, "product", id, previousProduct, archivedProduct)
+		})
 		if err != nil {
-			http.Error(w, "Failed to delete product", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to archive product")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK) // Respond with 200 OK
-		json.NewEncoder(w).Encode(map[string]string{"message": "Product deleted"})
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Product archived"})
+	}
+}
+
+// RestoreProductHandler returns a handler for POST /api/products/{id}/restore
+func RestoreProductHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		vars := mux.Vars(r)
+		id := vars["id"] // Product ID is text
+
+		var product *models.Product
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			previousProduct, err := getProductByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if product, err = restoreProduct(ctx, tx, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditRestore, "product", id, previousProduct, product)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to restore product")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(product)
 	}
 }
 
 // Database interaction functions (private)
 
-func getProducts(db *sql.DB) ([]models.Product, error) {
-	rows, err := db.Query("SELECT product_id, name FROM products")
+// productListSpec defines what GET /api/products can sort, filter and search on
+var productListSpec = listSpec{
+	Fields: map[string]listField{
+		"product_id":             {Column: "product_id", Kind: fieldText},
+		"name":                   {Column: "name", Kind: fieldText},
+		"unit_of_measure":        {Column: "unit_of_measure", Kind: fieldText},
+		"customer_part_number":   {Column: "customer_part_number", Kind: fieldText},
+		"supplier_part_number":   {Column: "supplier_part_number", Kind: fieldText},
+		"default_container_type": {Column: "default_container_type", Kind: fieldText},
+	},
+	Search:      []string{"product_id", "name", "customer_part_number", "supplier_part_number"},
+	DefaultSort: "product_id",
+	TieBreaker:  "product_id",
+	Archived:    "archived_at",
+}
+
+const productsFrom = `FROM products WHERE TRUE`
+
+// productSelect lists the columns of a product, as read by scanProduct
+const productSelect = `
+	product_id, name, unit_of_measure, net_weight_kg, gross_weight_kg, customer_part_number, supplier_part_number,
+	default_container_type, units_per_container, archived_at`
+
+// scanProduct reads a row of productSelect
+func scanProduct(row interface{ Scan(...interface{}) error }) (*models.Product, error) {
+	var product models.Product
+	err := row.Scan(
+		&product.ProductID, &product.Name, &product.UnitOfMeasure, &product.NetWeightKg, &product.GrossWeightKg,
+		&product.CustomerPartNumber, &product.SupplierPartNumber, &product.DefaultContainerType, &product.UnitsPerContainer,
+		&product.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func getProducts(ctx context.Context, db dbtx, lq *listQuery) ([]models.Product, error) {
+	var args []interface{}
+	query := "SELECT" + productSelect + " " + productsFrom +
+		lq.where(productListSpec, &args) + lq.after(productListSpec, &args) + lq.orderBy(productListSpec) + lq.page()
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var products []models.Product
+	products := []models.Product{}
 	for rows.Next() {
-		var product models.Product
-		if err := rows.Scan(&product.ProductID, &product.Name); err != nil {
+		product, err := scanProduct(rows)
+		if err != nil {
 			return nil, err
 		}
-		products = append(products, product)
+		products = append(products, *product)
 	}
-	return products, nil
+	return products, rows.Err()
 }
 
-func createProduct(db *sql.DB, product models.Product) (*models.Product, error) {
+func createProduct(ctx context.Context, db dbtx, product models.Product) (*models.Product, error) {
 	sqlStatement := `
-		INSERT INTO products (product_id, name)
-		VALUES ($1, $2)
-		RETURNING product_id, name`
-	var newProduct models.Product
-	err := db.QueryRow(sqlStatement, product.ProductID, product.Name).Scan(
-		&newProduct.ProductID, &newProduct.Name,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &newProduct, nil
+		INSERT INTO products (
+			product_id, name, unit_of_measure, net_weight_kg, gross_weight_kg, customer_part_number, supplier_part_number,
+			default_container_type, units_per_container
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING` + productSelect
+	return scanProduct(db.QueryRowContext(ctx, sqlStatement,
+		product.ProductID, product.Name, product.UnitOfMeasure, product.NetWeightKg, product.GrossWeightKg,
+		product.CustomerPartNumber, product.SupplierPartNumber, product.DefaultContainerType, product.UnitsPerContainer,
+	))
 }
 
-func getProductByID(db *sql.DB, id string) (*models.Product, error) {
-	sqlStatement := `SELECT product_id, name FROM products WHERE product_id = $1`
-	var product models.Product
-	err := db.QueryRow(sqlStatement, id).Scan(&product.ProductID, &product.Name)
-	if err != nil {
-		return nil, err
-	}
-	return &product, nil
+func getProductByID(ctx context.Context, db dbtx, id string) (*models.Product, error) {
+	return scanProduct(db.QueryRowContext(ctx, `SELECT`+productSelect+` FROM products WHERE product_id = $1`, id))
 }
 
-func updateProduct(db *sql.DB, product models.Product) (*models.Product, error) {
+func updateProduct(ctx context.Context, db dbtx, product models.Product) (*models.Product, error) {
 	sqlStatement := `
 		UPDATE products
-		SET name = $2
+		SET
+			name = $2, unit_of_measure = $3, net_weight_kg = $4, gross_weight_kg = $5,
+			customer_part_number = $6, supplier_part_number = $7, default_container_type = $8, units_per_container = $9
 		WHERE product_id = $1
-		RETURNING product_id, name`
-	var updatedProduct models.Product
-	err := db.QueryRow(sqlStatement, product.ProductID, product.Name).Scan(
-		&updatedProduct.ProductID, &updatedProduct.Name,
-	)
+		RETURNING` + productSelect
+	return scanProduct(db.QueryRowContext(ctx, sqlStatement,
+		product.ProductID, product.Name, product.UnitOfMeasure, product.NetWeightKg, product.GrossWeightKg,
+		product.CustomerPartNumber, product.SupplierPartNumber, product.DefaultContainerType, product.UnitsPerContainer,
+	))
+}
+
+// refuseUnconvertibleChains refuses a product whose unit of measure or net weight no longer converts the quantity
+// units of the kanban chains using it, such as kilograms once the product has no net weight.
+// Run it in a transaction, which a refusal must roll back.
+func refuseUnconvertibleChains(ctx context.Context, tx dbtx, product models.Product) error {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT quantity_unit FROM kanban_chains WHERE prodotto_codice = $1`, product.ProductID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var unit string
+		if err := rows.Scan(&unit); err != nil {
+			return err
+		}
+		if _, ok := convertQuantity(1, unit, product.UnitOfMeasure, product); !ok {
+			return &conflictError{message: fmt.Sprintf(
+				"Kanban chains of the product count in %s, which does not convert to %s without a net weight", unit, product.UnitOfMeasure)}
+		}
 	}
-	return &updatedProduct, nil
+	return rows.Err()
 }
 
-func deleteProduct(db *sql.DB, id string) error {
-	sqlStatement := `DELETE FROM products WHERE product_id = $1`
-	_, err := db.Exec(sqlStatement, id)
-	return err
+// archiveProduct archives a product, refusing while kanban chains that are not archived use it.
+// Run it in a transaction, which a refusal must roll back.
+func archiveProduct(ctx context.Context, tx dbtx, id string) error {
+	if err := archiveRow(ctx, tx, "products", "product_id", id); err != nil {
+		return err
+	}
+	return refuseIfAny(ctx, tx, "The product is used by %d kanban chains that are not archived",
+		`SELECT COUNT(*) FROM kanban_chains WHERE prodotto_codice = $1 AND archived_at IS NULL`, id)
+}
+
+func restoreProduct(ctx context.Context, db dbtx, id string) (*models.Product, error) {
+	if err := restoreRow(ctx, db, "products", "product_id", id); err != nil {
+		return nil, err
+	}
+	return getProductByID(ctx, db, id)
 }
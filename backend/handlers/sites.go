@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"electronic_kanban_backend/models"
+
+	"github.com/gorilla/mux"
+)
+
+// GetSitesHandler returns a handler for GET /api/sites; filter on account_id for the sites of an account
+func GetSitesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		lq, err := parseListQuery(r, siteListSpec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		sites, err := getSites(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch sites")
+			return
+		}
+		total, err := countRows(ctx, db, sitesFrom, lq, siteListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch sites")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, sitesFrom, lq, siteListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch sites")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sites)
+	}
+}
+
+// CreateSiteHandler returns a handler for POST /api/sites
+func CreateSiteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var site models.Site
+		if err := json.NewDecoder(r.Body).Decode(&site); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		fieldErrs, err := validateSite(ctx, db, &site)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to validate site")
+			return
+		}
+		if len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
+			return
+		}
+
+		var newSite *models.Site
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if newSite, err = createSite(ctx, tx, site); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditCreate, "site", newSite.ID, nil, newSite)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to create site")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newSite)
+	}
+}
+
+// GetSiteHandler returns a handler for GET /api/sites/{id}
+func GetSiteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid site ID")
+			return
+		}
+
+		site, err := getSiteByID(r.Context(), db, id)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch site")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(site)
+	}
+}
+
+// UpdateSiteHandler returns a handler for PUT/PATCH /api/sites/{id}. A site stays with its account:
+// account_id, when sent, must be the current one.
+func UpdateSiteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid site ID")
+			return
+		}
+
+		var siteUpdates models.Site
+		if err := json.NewDecoder(r.Body).Decode(&siteUpdates); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		siteUpdates.ID = id // Ensure ID from URL is used
+
+		var updatedSite *models.Site
+		var fieldErrs []models.FieldError
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousSite, err := getSiteByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if siteUpdates.AccountID != 0 && siteUpdates.AccountID != previousSite.AccountID {
+				fieldErrs = fieldErrors("account_id", "a site cannot move to another account, create a site there instead")
+				return nil
+			}
+			siteUpdates.AccountID = previousSite.AccountID
+			if fieldErrs, err = validateSite(ctx, tx, &siteUpdates); err != nil || len(fieldErrs) > 0 {
+				return err
+			}
+			if updatedSite, err = updateSite(ctx, tx, siteUpdates); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditUpdate, "site", id, previousSite, updatedSite)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to update site")
+			return
+		}
+		if len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updatedSite)
+	}
+}
+
+// DeleteSiteHandler returns a handler for DELETE /api/sites/{id}, which archives the site
+func DeleteSiteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid site ID")
+			return
+		}
+
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousSite, err := getSiteByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := archiveSite(ctx, tx, id); err != nil {
+				return err
+			}
+			archivedSite, err := getSiteByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditArchive, "site", id, previousSite, archivedSite)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to archive site")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Site archived"})
+	}
+}
+
+// RestoreSiteHandler returns a handler for POST /api/sites/{id}/restore
+func RestoreSiteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid site ID")
+			return
+		}
+
+		var site *models.Site
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousSite, err := getSiteByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if site, err = restoreSite(ctx, tx, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditRestore, "site", id, previousSite, site)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to restore site")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(site)
+	}
+}
+
+// Database interaction functions (private)
+
+// siteListSpec defines what GET /api/sites can sort, filter and search on
+var siteListSpec = listSpec{
+	Fields: map[string]listField{
+		"id":         {Column: "id", Kind: fieldInt},
+		"account_id": {Column: "account_id", Kind: fieldInt},
+		"name":       {Column: "name", Kind: fieldText},
+		"address":    {Column: "address", Kind: fieldText},
+	},
+	Search:      []string{"name", "address"},
+	DefaultSort: "name",
+	TieBreaker:  "id",
+	Archived:    "archived_at",
+}
+
+const sitesFrom = `FROM sites WHERE TRUE`
+
+func getSites(ctx context.Context, db dbtx, lq *listQuery) ([]models.Site, error) {
+	var args []interface{}
+	query := "SELECT id, account_id, name, address, archived_at " + sitesFrom +
+		lq.where(siteListSpec, &args) + lq.after(siteListSpec, &args) + lq.orderBy(siteListSpec) + lq.page()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sites := []models.Site{}
+	for rows.Next() {
+		var site models.Site
+		if err := rows.Scan(&site.ID, &site.AccountID, &site.Name, &site.Address, &site.ArchivedAt); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+func createSite(ctx context.Context, db dbtx, site models.Site) (*models.Site, error) {
+	var newSite models.Site
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO sites (account_id, name, address)
+		VALUES ($1, $2, $3)
+		RETURNING id, account_id, name, address, archived_at`, site.AccountID, site.Name, site.Address).Scan(
+		&newSite.ID, &newSite.AccountID, &newSite.Name, &newSite.Address, &newSite.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &newSite, nil
+}
+
+func getSiteByID(ctx context.Context, db dbtx, id int64) (*models.Site, error) {
+	var site models.Site
+	err := db.QueryRowContext(ctx, `SELECT id, account_id, name, address, archived_at FROM sites WHERE id = $1`, id).Scan(
+		&site.ID, &site.AccountID, &site.Name, &site.Address, &site.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &site, nil
+}
+
+func updateSite(ctx context.Context, db dbtx, site models.Site) (*models.Site, error) {
+	var updatedSite models.Site
+	err := db.QueryRowContext(ctx, `
+		UPDATE sites
+		SET name = $2, address = $3
+		WHERE id = $1
+		RETURNING id, account_id, name, address, archived_at`, site.ID, site.Name, site.Address).Scan(
+		&updatedSite.ID, &updatedSite.AccountID, &updatedSite.Name, &updatedSite.Address, &updatedSite.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &updatedSite, nil
+}
+
+// archiveSite archives a site, refusing while kanban chains that are not archived ship to or from it.
+// Run it in a transaction, which a refusal must roll back.
+func archiveSite(ctx context.Context, tx dbtx, id int64) error {
+	if err := archiveRow(ctx, tx, "sites", "id", id); err != nil {
+		return err
+	}
+	return refuseIfAny(ctx, tx, "The site is used by %d kanban chains that are not archived",
+		`SELECT COUNT(*) FROM kanban_chains WHERE (customer_site_id = $1 OR supplier_site_id = $1) AND archived_at IS NULL`, id)
+}
+
+// restoreSite restores a site, refusing while its account is archived.
+// Run it in a transaction, which a refusal must roll back.
+func restoreSite(ctx context.Context, tx dbtx, id int64) (*models.Site, error) {
+	if err := restoreRow(ctx, tx, "sites", "id", id); err != nil {
+		return nil, err
+	}
+	accountArchived, err := rowExists(ctx, tx, `
+		SELECT 1 FROM sites st JOIN accounts a ON st.account_id = a.id
+		WHERE st.id = $1 AND a.archived_at IS NOT NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if accountArchived {
+		return nil, &conflictError{message: "The account of the site is archived, restore it first"}
+	}
+	return getSiteByID(ctx, tx, id)
+}
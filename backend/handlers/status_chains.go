@@ -1,10 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -13,14 +13,37 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// statusChainCreateRequest is the body of POST /api/status-chains
+type statusChainCreateRequest struct {
+	StatusChain     models.StatusChain         `json:"status_chain"`
+	StatusesUpdates []models.StatusChainStatus `json:"statuses"` // Expecting statuses in request now
+}
+
 // GetStatusChainsHandler returns a handler for GET /api/status-chains
 func GetStatusChainsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		statusChains, err := getStatusChains(db)
+		ctx := r.Context()
+		lq, err := parseListQuery(r, statusChainListSpec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		statusChains, err := getStatusChains(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch status chains")
+			return
+		}
+		total, err := countRows(ctx, db, statusChainsFrom, lq, statusChainListSpec)
 		if err != nil {
-			http.Error(w, "Failed to fetch status chains", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to fetch status chains")
 			return
 		}
+		cursor, err := nextCursor(ctx, db, statusChainsFrom, lq, statusChainListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch status chains")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(statusChains)
 	}
@@ -29,67 +52,73 @@ func GetStatusChainsHandler(db *sql.DB) http.HandlerFunc {
 // CreateStatusChainHandler returns a handler for POST /api/status-chains
 func CreateStatusChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("CreateStatusChainHandler: Starting") // Log entry
-		var statusChainRequest struct {
-			StatusChain     models.StatusChain         `json:"status_chain"`
-			StatusesUpdates []models.StatusChainStatus `json:"statuses"` // Expecting statuses in request now
-		}
+		ctx := r.Context()
+		var statusChainRequest statusChainCreateRequest
 		err := json.NewDecoder(r.Body).Decode(&statusChainRequest)
 		if err != nil {
-			log.Printf("CreateStatusChainHandler: Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
 			return
 		}
-		log.Printf("CreateStatusChainHandler: Request body decoded, data: %+v", statusChainRequest) // Log received data
-
-		newStatusChain, err := createStatusChain(db, statusChainRequest.StatusChain)
+		fieldErrs := validateStatusChain(&statusChainRequest.StatusChain)
+		statusErrs, err := validateStatusChainStatuses(ctx, db, statusChainRequest.StatusesUpdates)
 		if err != nil {
-			log.Printf("CreateStatusChainHandler: Failed to create status chain: %v", err)
-			http.Error(w, "Failed to create status chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to validate status chain")
+			return
+		}
+		if fieldErrs = append(fieldErrs, statusErrs...); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
-		log.Printf("CreateStatusChainHandler: Status chain created successfully, ID: %d", newStatusChain.StatusChainID) // Log success
 
-		// Insert linked statuses into status_chains_statuses
-		if len(statusChainRequest.StatusesUpdates) > 0 {
-			err = insertStatusChainStatuses(db, newStatusChain.StatusChainID, statusChainRequest.StatusesUpdates)
+		var newStatusChain *models.StatusChain
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if newStatusChain, err = createStatusChain(ctx, tx, statusChainRequest.StatusChain); err != nil {
+				return err
+			}
+
+			// Insert linked statuses into status_chains_statuses
+			if len(statusChainRequest.StatusesUpdates) > 0 {
+				if err := insertStatusChainStatuses(ctx, tx, newStatusChain.StatusChainID, statusChainRequest.StatusesUpdates); err != nil {
+					return err
+				}
+			}
+			created, err := getStatusChainAuditRecord(ctx, tx, newStatusChain.StatusChainID)
 			if err != nil {
-				log.Printf("CreateStatusChainHandler: Failed to insert status chain statuses: %v", err)
-				http.Error(w, "Failed to insert status chain statuses", http.StatusInternalServerError)
-				return
+				return err
 			}
-			log.Println("CreateStatusChainHandler: Status chain statuses inserted successfully")
+			return recordAudit(tx, r, auditCreate, "status_chain", newStatusChain.StatusChainID, nil, created)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to create status chain")
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(newStatusChain)
-		log.Println("CreateStatusChainHandler: Finished successfully") // Log exit
 	}
 }
 
 // GetStatusChainHandler returns a handler for GET /api/status-chains/{id}
 func GetStatusChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Status Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status Chain ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status chain ID")
 			return
 		}
 
-		statusChain, err := getStatusChainByID(db, id)
+		statusChain, err := getStatusChainByID(ctx, db, id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				http.NotFound(w, r)
-			} else {
-				http.Error(w, "Failed to fetch status chain", http.StatusInternalServerError)
-			}
+			writeDBError(w, r, err, "Failed to fetch status chain")
 			return
 		}
 
@@ -101,29 +130,39 @@ func GetStatusChainHandler(db *sql.DB) http.HandlerFunc {
 // UpdateStatusChainHandler returns a handler for PUT/PATCH /api/status-chains/{id}
 func UpdateStatusChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Status Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status Chain ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status chain ID")
 			return
 		}
 
 		var statusChainUpdates models.StatusChain
 		err = json.NewDecoder(r.Body).Decode(&statusChainUpdates)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateStatusChain(&statusChainUpdates); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 		statusChainUpdates.StatusChainID = id // Ensure ID from URL is used
 
-		updatedStatusChain, err := updateStatusChain(db, statusChainUpdates)
+		var updatedStatusChain *models.StatusChain
+		err = auditStatusChainChange(db, r, auditUpdate, id, func(tx *sql.Tx) error {
+			var err error
+			updatedStatusChain, err = updateStatusChain(ctx, tx, statusChainUpdates)
+			return err
+		})
 		if err != nil {
-			http.Error(w, "Failed to update status chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update status chain")
 			return
 		}
 
@@ -132,50 +171,80 @@ func UpdateStatusChainHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// DeleteStatusChainHandler returns a handler for DELETE /api/status-chains/{id}
+// DeleteStatusChainHandler returns a handler for DELETE /api/status-chains/{id}, which archives the status chain
 func DeleteStatusChainHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Status Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status Chain ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status chain ID")
 			return
 		}
 
-		err = deleteStatusChain(db, id)
+		err = auditStatusChainChange(db, r, auditArchive, id, func(tx *sql.Tx) error {
+			return archiveStatusChain(ctx, tx, id)
+		})
 		if err != nil {
-			http.Error(w, "Failed to delete status chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to archive status chain")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK) // Respond with 200 OK
-		json.NewEncoder(w).Encode(map[string]string{"message": "Status chain deleted"})
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Status chain archived"})
+	}
+}
+
+// RestoreStatusChainHandler returns a handler for POST /api/status-chains/{id}/restore
+func RestoreStatusChainHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status chain ID")
+			return
+		}
+
+		var statusChain *models.StatusChain
+		err = auditStatusChainChange(db, r, auditRestore, id, func(tx *sql.Tx) error {
+			var err error
+			statusChain, err = restoreStatusChain(ctx, tx, id)
+			return err
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to restore status chain")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statusChain)
 	}
 }
 
 // GetStatusChainStatusesHandler returns a handler to GET statuses for a specific chain
 func GetStatusChainStatusesHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		statusChainIDStr, ok := vars["statusChainId"]
 		if !ok {
-			http.Error(w, "Status Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status Chain ID is required")
 			return
 		}
 		statusChainID, err := strconv.ParseInt(statusChainIDStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status chain ID")
 			return
 		}
 
-		statuses, err := getStatusChainStatuses(db, statusChainID)
+		statuses, err := getStatusChainStatuses(ctx, db, statusChainID)
 		if err != nil {
-			http.Error(w, "Failed to fetch statuses for status chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to fetch statuses for status chain")
 			return
 		}
 
@@ -184,103 +253,126 @@ func GetStatusChainStatusesHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// UpdateStatusChainStatusesHandler handles PUT requests to update statuses in a status chain (order, customer_supplier)
+// UpdateStatusChainStatusesHandler handles PUT requests to update statuses in a status chain (order, customer_supplier, stage)
 func UpdateStatusChainStatusesHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("UpdateStatusChainStatusesHandler: Starting") // Log entry
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		statusChainIDStr, ok := vars["statusChainId"]
 		if !ok {
-			http.Error(w, "Status Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status Chain ID is required")
 			return
 		}
 		statusChainID, err := strconv.ParseInt(statusChainIDStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status chain ID")
 			return
 		}
 
 		var statusChainStatusesUpdates []models.StatusChainStatus // Assuming you'll create this model
 		err = json.NewDecoder(r.Body).Decode(&statusChainStatusesUpdates)
 		if err != nil {
-			log.Printf("UpdateStatusChainStatusesHandler: Invalid request body: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		fieldErrs, err := validateStatusChainStatuses(ctx, db, statusChainStatusesUpdates)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to validate status chain statuses")
+			return
+		}
+		if len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
-		log.Printf("UpdateStatusChainStatusesHandler: Request body decoded, StatusChainID: %d, StatusUpdates: %+v", statusChainID, statusChainStatusesUpdates) // Log received data
 
-		updatedStatuses, err := updateStatusChainStatuses(db, statusChainID, statusChainStatusesUpdates)
+		var updatedStatuses []models.StatusChainStatusItem
+		err = auditStatusChainChange(db, r, auditUpdate, statusChainID, func(tx *sql.Tx) error {
+			var err error
+			updatedStatuses, err = updateStatusChainStatuses(ctx, tx, statusChainID, statusChainStatusesUpdates)
+			return err
+		})
 		if err != nil {
-			log.Printf("UpdateStatusChainStatusesHandler: Failed to update statuses for status chain: %v", err)
-			http.Error(w, "Failed to update statuses for status chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update statuses for status chain")
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(updatedStatuses)
-		log.Println("UpdateStatusChainStatusesHandler: Finished successfully") // Log exit
 	}
 }
 
 // Database interaction functions (private)
 
-func getStatusChains(db *sql.DB) ([]models.StatusChain, error) {
-	rows, err := db.Query("SELECT status_chain_id, name FROM status_chains")
+// statusChainListSpec defines what GET /api/status-chains can sort, filter and search on
+var statusChainListSpec = listSpec{
+	Fields: map[string]listField{
+		"status_chain_id": {Column: "status_chain_id", Kind: fieldInt},
+		"name":            {Column: "name", Kind: fieldText},
+	},
+	Search:      []string{"name"},
+	DefaultSort: "name",
+	TieBreaker:  "status_chain_id",
+	Archived:    "archived_at",
+}
+
+const statusChainsFrom = `FROM status_chains WHERE TRUE`
+
+func getStatusChains(ctx context.Context, db dbtx, lq *listQuery) ([]models.StatusChain, error) {
+	var args []interface{}
+	query := "SELECT status_chain_id, name, archived_at " + statusChainsFrom +
+		lq.where(statusChainListSpec, &args) + lq.after(statusChainListSpec, &args) + lq.orderBy(statusChainListSpec) + lq.page()
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var statusChains []models.StatusChain
+	statusChains := []models.StatusChain{}
 	for rows.Next() {
 		var statusChain models.StatusChain
-		if err := rows.Scan(&statusChain.StatusChainID, &statusChain.Name); err != nil {
+		if err := rows.Scan(&statusChain.StatusChainID, &statusChain.Name, &statusChain.ArchivedAt); err != nil {
 			return nil, err
 		}
 		statusChains = append(statusChains, statusChain)
 	}
-	return statusChains, nil
+	return statusChains, rows.Err()
 }
 
-func createStatusChain(db *sql.DB, statusChain models.StatusChain) (*models.StatusChain, error) {
-	log.Printf("createStatusChain: Starting with data: %+v", statusChain) // Log entry
+func createStatusChain(ctx context.Context, db dbtx, statusChain models.StatusChain) (*models.StatusChain, error) {
 	sqlStatement := `
 		INSERT INTO status_chains (name)
 		VALUES ($1)
-		RETURNING status_chain_id, name`
-	log.Printf("createStatusChain: SQL Query: %s, Parameters: [%s]", sqlStatement, statusChain.Name) // Log SQL query
+		RETURNING status_chain_id, name, archived_at`
 	var newStatusChain models.StatusChain
-	err := db.QueryRow(sqlStatement, statusChain.Name).Scan(
-		&newStatusChain.StatusChainID, &newStatusChain.Name,
+	err := db.QueryRowContext(ctx, sqlStatement, statusChain.Name).Scan(
+		&newStatusChain.StatusChainID, &newStatusChain.Name, &newStatusChain.ArchivedAt,
 	)
 	if err != nil {
-		log.Printf("createStatusChain: Error executing query: %v", err) // Log error
 		return nil, fmt.Errorf("createStatusChain: error executing query: %w", err)
 	}
-	log.Printf("createStatusChain: Status chain created succesfully, ID: %d, Name: %s", newStatusChain.StatusChainID, newStatusChain.Name) // Log success
 
 	return &newStatusChain, nil
 }
 
-func getStatusChainByID(db *sql.DB, id int64) (*models.StatusChain, error) {
-	sqlStatement := `SELECT status_chain_id, name FROM status_chains WHERE status_chain_id = $1`
+func getStatusChainByID(ctx context.Context, db dbtx, id int64) (*models.StatusChain, error) {
+	sqlStatement := `SELECT status_chain_id, name, archived_at FROM status_chains WHERE status_chain_id = $1`
 	var statusChain models.StatusChain
-	err := db.QueryRow(sqlStatement, id).Scan(&statusChain.StatusChainID, &statusChain.Name)
+	err := db.QueryRowContext(ctx, sqlStatement, id).Scan(&statusChain.StatusChainID, &statusChain.Name, &statusChain.ArchivedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &statusChain, nil
 }
 
-func updateStatusChain(db *sql.DB, statusChain models.StatusChain) (*models.StatusChain, error) {
+func updateStatusChain(ctx context.Context, db dbtx, statusChain models.StatusChain) (*models.StatusChain, error) {
 	sqlStatement := `
 		UPDATE status_chains
 		SET name = $2
 		WHERE status_chain_id = $1
-		RETURNING status_chain_id, name`
+		RETURNING status_chain_id, name, archived_at`
 	var updatedStatusChain models.StatusChain
-	err := db.QueryRow(sqlStatement, statusChain.StatusChainID, statusChain.Name).Scan(
-		&updatedStatusChain.StatusChainID, &updatedStatusChain.Name,
+	err := db.QueryRowContext(ctx, sqlStatement, statusChain.StatusChainID, statusChain.Name).Scan(
+		&updatedStatusChain.StatusChainID, &updatedStatusChain.Name, &updatedStatusChain.ArchivedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -288,21 +380,128 @@ func updateStatusChain(db *sql.DB, statusChain models.StatusChain) (*models.Stat
 	return &updatedStatusChain, nil
 }
 
-func deleteStatusChain(db *sql.DB, id int64) error {
-	sqlStatement := `DELETE FROM status_chains WHERE status_chain_id = $1`
-	_, err := db.Exec(sqlStatement, id)
-	return err
+// archiveStatusChain archives a status chain, refusing while kanban chains that are not archived use it.
+// Run it in a transaction, which a refusal must roll back.
+func archiveStatusChain(ctx context.Context, tx dbtx, id int64) error {
+	if err := archiveRow(ctx, tx, "status_chains", "status_chain_id", id); err != nil {
+		return err
+	}
+	return refuseIfAny(ctx, tx, "The status chain is used by %d kanban chains that are not archived",
+		`SELECT COUNT(*) FROM kanban_chains WHERE status_chain_id = $1 AND archived_at IS NULL`, id)
+}
+
+// restoreStatusChain restores a status chain, refusing while any of its statuses is archived.
+// Run it in a transaction, which a refusal must roll back.
+func restoreStatusChain(ctx context.Context, tx dbtx, id int64) (*models.StatusChain, error) {
+	if err := restoreRow(ctx, tx, "status_chains", "status_chain_id", id); err != nil {
+		return nil, err
+	}
+	err := refuseIfAny(ctx, tx, "%d statuses of the status chain are archived, restore them first", `
+		SELECT COUNT(*)
+		FROM status_chains_statuses scs
+		JOIN statuses s ON s.status_id = scs.status_id
+		WHERE scs.status_chain_id = $1 AND s.archived_at IS NOT NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	return getStatusChainByID(ctx, tx, id)
+}
+
+// statusChainAuditRecord is a status chain with its statuses, as recorded in the audit log
+type statusChainAuditRecord struct {
+	models.StatusChain
+	Statuses []models.StatusChainStatusItem `json:"statuses"`
+}
+
+func getStatusChainAuditRecord(ctx context.Context, db dbtx, id int64) (*statusChainAuditRecord, error) {
+	statusChain, err := getStatusChainByID(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := getStatusChainStatuses(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	return &statusChainAuditRecord{StatusChain: *statusChain, Statuses: statuses}, nil
+}
+
+// auditStatusChainChange runs change on a status chain in a transaction, recording the chain and its statuses
+// before and after in the audit log
+func auditStatusChainChange(db *sql.DB, r *http.Request, action string, id int64, change func(tx *sql.Tx) error) error {
+	ctx := r.Context()
+	return inTx(ctx, db, func(tx *sql.Tx) error {
+		before, err := getStatusChainAuditRecord(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(tx); err != nil {
+			return err
+		}
+		after, err := getStatusChainAuditRecord(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, r, action, "status_chain", id, before, after)
+	})
+}
+
+// Stages of the kanban loop. Each status of a status chain stands for one, so the dashboards can tell
+// where a card is whatever the statuses are called.
+const (
+	stageToProduce    = "to_produce"    // Empty container back at the supplier, waiting to be produced
+	stageInProduction = "in_production" // Being produced or filled by the supplier
+	stageInTransit    = "in_transit"    // Shipped, on its way to the customer
+	stageAtCustomer   = "at_customer"   // Full container at the customer
+)
+
+// validStage reports whether stage is one of the stages of the kanban loop
+func validStage(stage string) bool {
+	switch stage {
+	case stageToProduce, stageInProduction, stageInTransit, stageAtCustomer:
+		return true
+	}
+	return false
+}
+
+// deriveStages fills in the stage of the statuses of a chain, in chain order, that don't set one.
+// Customer statuses are at_customer. Of the supplier statuses, the first is to_produce, the last one
+// is in_transit when there are at least three, and the others are in_production.
+func deriveStages(statuses []models.StatusChainStatusItem) {
+	var supplier []int
+	for i, s := range statuses {
+		if s.CustomerSupplier == 2 {
+			if s.Stage == "" {
+				statuses[i].Stage = stageAtCustomer
+			}
+			continue
+		}
+		supplier = append(supplier, i)
+	}
+	for n, i := range supplier {
+		if statuses[i].Stage != "" {
+			continue
+		}
+		switch {
+		case n == 0:
+			statuses[i].Stage = stageToProduce
+		case n == len(supplier)-1 && len(supplier) >= 3:
+			statuses[i].Stage = stageInTransit
+		default:
+			statuses[i].Stage = stageInProduction
+		}
+	}
 }
 
 // getStatusChainStatuses retrieves statuses for a given status chain, ordered by their 'order' field.
-func getStatusChainStatuses(db *sql.DB, statusChainID int64) ([]map[string]interface{}, error) {
+func getStatusChainStatuses(ctx context.Context, db dbtx, statusChainID int64) ([]models.StatusChainStatusItem, error) {
 	query := `
 		SELECT
 			scs.status_id,
 			s.name AS status_name,
 			s.color AS status_color,
 			scs."order",
-			scs.customer_supplier
+			scs.customer_supplier,
+			COALESCE(scs.stage, '')
 		FROM
 			status_chains_statuses scs
 		JOIN
@@ -313,150 +512,75 @@ func getStatusChainStatuses(db *sql.DB, statusChainID int64) ([]map[string]inter
 			scs."order" ASC;
 	`
 
-	rows, err := db.Query(query, statusChainID)
+	rows, err := db.QueryContext(ctx, query, statusChainID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying status chain statuses: %w", err)
 	}
 	defer rows.Close()
 
-	var statuses []map[string]interface{}
+	statuses := []models.StatusChainStatusItem{}
 	for rows.Next() {
-		var statusID int64
-		var statusName string
-		var statusColor string
-		var order int64
-		var customerSupplier int
-
-		if err := rows.Scan(&statusID, &statusName, &statusColor, &order, &customerSupplier); err != nil {
+		var status models.StatusChainStatusItem
+		if err := rows.Scan(&status.StatusID, &status.StatusName, &status.StatusColor, &status.Order, &status.CustomerSupplier, &status.Stage); err != nil {
 			return nil, fmt.Errorf("error scanning status chain status row: %w", err)
 		}
-
-		statuses = append(statuses, map[string]interface{}{
-			"status_id":         statusID,
-			"status_name":       statusName,
-			"status_color":      statusColor,
-			"order":             order,
-			"customer_supplier": customerSupplier,
-		})
+		statuses = append(statuses, status)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating status chain statuses rows: %w", err)
 	}
 
+	deriveStages(statuses)
 	return statuses, nil
 }
 
-// insertStatusChainStatuses inserts multiple status_chains_statuses records in a transaction
-func insertStatusChainStatuses(db *sql.DB, statusChainID int64, statusesUpdates []models.StatusChainStatus) error {
-	log.Println("insertStatusChainStatuses: Starting, StatusChainID:", statusChainID, ", StatusUpdates:", statusesUpdates) // Log entry
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("insertStatusChainStatuses: error starting transaction: %w", err)
-	}
-	defer tx.Rollback()
-
-	stmt, err := tx.Prepare(`
-		INSERT INTO status_chains_statuses (status_chain_id, status_id, "order", customer_supplier)
-		VALUES ($1, $2, $3, $4)
-	`)
-	if err != nil {
-		return fmt.Errorf("insertStatusChainStatuses: error preparing statement: %w", err)
-	}
-	defer stmt.Close()
-
+// insertStatusChainStatuses inserts the status_chains_statuses records of a new status chain
+func insertStatusChainStatuses(ctx context.Context, tx dbtx, statusChainID int64, statusesUpdates []models.StatusChainStatus) error {
 	for _, statusUpdate := range statusesUpdates {
-		log.Printf("updateStatusChainStatuses: Processing status update: StatusChainID=%d, StatusUpdate=%+v", statusChainID, statusUpdate) // Log each status update
-		_, err = stmt.Exec(statusChainID, statusUpdate.StatusID, statusUpdate.Order, statusUpdate.CustomerSupplier)
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO status_chains_statuses (status_chain_id, status_id, "order", customer_supplier, stage)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		`, statusChainID, statusUpdate.StatusID, statusUpdate.Order, statusUpdate.CustomerSupplier, statusUpdate.Stage)
 		if err != nil {
-			log.Printf("insertStatusChainStatuses: Error inserting status (StatusID: %d) for chain: %v", statusUpdate.StatusID, err) // Log individual status insert error
 			return fmt.Errorf("insertStatusChainStatuses: error executing insert for status_id %d: %w", statusUpdate.StatusID, err)
 		}
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		log.Printf("insertStatusChainStatuses: Error committing transaction: %v", err) // Log transaction commit error
-		return fmt.Errorf("insertStatusChainStatuses: error committing transaction: %w", err)
-	}
-	log.Println("insertStatusChainStatuses: Status chain statuses inserted successfully") // Log success
 	return nil
 }
 
-func updateStatusChainStatuses(db *sql.DB, statusChainID int64, statusesUpdates []models.StatusChainStatus) ([]map[string]interface{}, error) {
-	log.Println("updateStatusChainStatuses: Starting, StatusChainID:", statusChainID, ", StatusUpdates:", statusesUpdates)
-	tx, err := db.Begin()
-	if err != nil {
-		return nil, fmt.Errorf("updateStatusChainStatuses: error starting transaction: %w", err)
-	}
-	defer tx.Rollback() // Rollback if function exits early
-
-	// Prepare statement for updating status_chains_statuses
-	stmt, err := tx.Prepare(`
-		UPDATE status_chains_statuses
-		SET "order" = $3, customer_supplier = $4
-		WHERE status_chain_id = $1 AND status_id = $2
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("updateStatusChainStatuses: error preparing update statement: %w", err)
-	}
-	defer stmt.Close()
-
+// updateStatusChainStatuses sets the order and owner of statuses of a status chain, adding the ones it doesn't have yet
+func updateStatusChainStatuses(ctx context.Context, tx dbtx, statusChainID int64, statusesUpdates []models.StatusChainStatus) ([]models.StatusChainStatusItem, error) {
 	for _, statusUpdate := range statusesUpdates {
-		log.Printf("updateStatusChainStatuses: Processing status update: StatusChainID=%d, StatusUpdate=%+v", statusChainID, statusUpdate) // Log each status update
 
 		// **Attempt UPDATE first:**
-		updateStmt, err := tx.Prepare(`
+		res, err := tx.ExecContext(ctx, `
 			UPDATE status_chains_statuses
-			SET "order" = $3, customer_supplier = $4
+			SET "order" = $3, customer_supplier = $4, stage = NULLIF($5, '')
 			WHERE status_chain_id = $1 AND status_id = $2
-		`)
+		`, statusChainID, statusUpdate.StatusID, statusUpdate.Order, statusUpdate.CustomerSupplier, statusUpdate.Stage)
 		if err != nil {
-			return nil, fmt.Errorf("updateStatusChainStatuses: error preparing update statement: %w", err)
-		}
-		res, err := updateStmt.Exec(statusChainID, statusUpdate.StatusID, statusUpdate.Order, statusUpdate.CustomerSupplier)
-		if err != nil {
-			updateStmt.Close()
-			log.Printf("updateStatusChainStatuses: Error executing UPDATE statement for status_id %d: %v", statusUpdate.StatusID, err)
 			return nil, fmt.Errorf("updateStatusChainStatuses: error updating status chain status (status_id: %d): %w", statusUpdate.StatusID, err)
 		}
 		rowsAffected, _ := res.RowsAffected()
-		updateStmt.Close()
-		log.Printf("updateStatusChainStatuses: UPDATE statement executed for status_id %d, rows affected: %d", statusUpdate.StatusID, rowsAffected)
 
 		// **If no rows updated (status not found in chain), perform INSERT:**
 		if rowsAffected == 0 {
-			log.Printf("updateStatusChainStatuses: Status ID %d not found in chain %d, attempting INSERT", statusUpdate.StatusID, statusChainID)
-			insertStmt, err := tx.Prepare(`
-				INSERT INTO status_chains_statuses (status_chain_id, status_id, "order", customer_supplier)
-				VALUES ($1, $2, $3, $4)
-			`)
-			if err != nil {
-				return nil, fmt.Errorf("updateStatusChainStatuses: error preparing insert statement: %w", err)
-			}
-			_, err = insertStmt.Exec(statusChainID, statusUpdate.StatusID, statusUpdate.Order, statusUpdate.CustomerSupplier)
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO status_chains_statuses (status_chain_id, status_id, "order", customer_supplier, stage)
+				VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+			`, statusChainID, statusUpdate.StatusID, statusUpdate.Order, statusUpdate.CustomerSupplier, statusUpdate.Stage)
 			if err != nil {
-				insertStmt.Close()
-				log.Printf("updateStatusChainStatuses: Error executing INSERT statement for status_id %d: %v", statusUpdate.StatusID, err)
 				return nil, fmt.Errorf("updateStatusChainStatuses: error inserting status chain status (status_id: %d): %w", statusUpdate.StatusID, err)
 			}
-			insertStmt.Close()
-			log.Printf("updateStatusChainStatuses: INSERT statement executed for status_id %d", statusUpdate.StatusID)
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("updateStatusChainStatuses: Error committing transaction: %v", err)
-		return nil, fmt.Errorf("updateStatusChainStatuses: error committing transaction: %w", err)
-	}
-
 	// After successful update, retrieve and return the updated statuses for the chain
-	updatedStatuses, err := getStatusChainStatuses(db, statusChainID)
+	updatedStatuses, err := getStatusChainStatuses(ctx, tx, statusChainID)
 	if err != nil {
-		log.Printf("updateStatusChainStatuses: Error retrieving updated status chain statuses: %v", err) // Log error on retrieval
 		return nil, fmt.Errorf("error retrieving updated status chain statuses: %w", err)
 	}
-	log.Println("updateStatusChainStatuses: Status chain statuses updated successfully") // Log success
 
 	return updatedStatuses, nil
 }
@@ -464,56 +588,52 @@ func updateStatusChainStatuses(db *sql.DB, statusChainID int64, statusesUpdates
 // DeleteStatusChainStatusHandler handles DELETE requests to remove a status from a status chain
 func DeleteStatusChainStatusHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("DeleteStatusChainStatusHandler: Starting") // Log entry
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		statusChainIDStr, ok := vars["statusChainId"]
 		if !ok {
-			http.Error(w, "Status Chain ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status Chain ID is required")
 			return
 		}
 		statusChainID, err := strconv.ParseInt(statusChainIDStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status chain ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status chain ID")
 			return
 		}
 
 		statusIDStr, ok := vars["statusId"]
 		if !ok {
-			http.Error(w, "Status ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status ID is required")
 			return
 		}
 		statusID, err := strconv.ParseInt(statusIDStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status ID")
 			return
 		}
 
-		err = deleteStatusChainStatus(db, statusChainID, statusID) // Call deleteStatusChainStatus DB function
+		err = auditStatusChainChange(db, r, auditUpdate, statusChainID, func(tx *sql.Tx) error {
+			return deleteStatusChainStatus(ctx, tx, statusChainID, statusID) // Call deleteStatusChainStatus DB function
+		})
 		if err != nil {
-			log.Printf("DeleteStatusChainStatusHandler: Failed to delete status %d from chain %d: %v", statusID, statusChainID, err)
-			http.Error(w, "Failed to delete status from status chain", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to delete status from status chain")
 			return
 		}
 
-		log.Printf("DeleteStatusChainStatusHandler: Successfully deleted status %d from chain %d", statusID, statusChainID)
 		w.WriteHeader(http.StatusOK) // Respond with 200 OK
-		json.NewEncoder(w).Encode(map[string]string{"message": "Status deleted from status chain"})
-		log.Println("DeleteStatusChainStatusHandler: Finished successfully") // Log exit
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Status deleted from status chain"})
 	}
 }
 
 // deleteStatusChainStatus removes a status from a status chain in the database
-func deleteStatusChainStatus(db *sql.DB, statusChainID int64, statusID int64) error {
-	log.Printf("deleteStatusChainStatus: Starting, StatusChainID: %d, StatusID: %d", statusChainID, statusID) // Log entry
+func deleteStatusChainStatus(ctx context.Context, db dbtx, statusChainID int64, statusID int64) error {
 	sqlStatement := `
 		DELETE FROM status_chains_statuses
 		WHERE status_chain_id = $1 AND status_id = $2
 	`
-	_, err := db.Exec(sqlStatement, statusChainID, statusID)
+	err := execAffectingRows(ctx, db, sqlStatement, statusChainID, statusID)
 	if err != nil {
-		log.Printf("deleteStatusChainStatus: Error executing DELETE query: %v", err) // Log query error
 		return fmt.Errorf("deleteStatusChainStatus: error deleting status from chain: %w", err)
 	}
-	log.Println("deleteStatusChainStatus: Status chain status deleted successfully") // Log success
 	return nil
 }
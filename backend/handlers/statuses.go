@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
@@ -14,11 +15,28 @@ import (
 // GetStatusesHandler returns a handler for GET /api/statuses
 func GetStatusesHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		statuses, err := getStatuses(db)
+		ctx := r.Context()
+		lq, err := parseListQuery(r, statusListSpec)
 		if err != nil {
-			http.Error(w, "Failed to fetch statuses", http.StatusInternalServerError)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
 			return
 		}
+		statuses, err := getStatuses(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch statuses")
+			return
+		}
+		total, err := countRows(ctx, db, statusesFrom, lq, statusListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch statuses")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, statusesFrom, lq, statusListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch statuses")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(statuses)
 	}
@@ -27,16 +45,28 @@ func GetStatusesHandler(db *sql.DB) http.HandlerFunc {
 // CreateStatusHandler returns a handler for POST /api/statuses
 func CreateStatusHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		var status models.Status
 		err := json.NewDecoder(r.Body).Decode(&status)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateStatus(&status); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 
-		newStatus, err := createStatus(db, status)
+		var newStatus *models.Status
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if newStatus, err = createStatus(ctx, tx, status); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditCreate, "status", newStatus.StatusID, nil, newStatus)
+		})
 		if err != nil {
-			http.Error(w, "Failed to create status", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to create status")
 			return
 		}
 
@@ -49,25 +79,22 @@ func CreateStatusHandler(db *sql.DB) http.HandlerFunc {
 // GetStatusHandler returns a handler for GET /api/statuses/{id}
 func GetStatusHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Status ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status ID")
 			return
 		}
 
-		status, err := getStatusByID(db, id)
+		status, err := getStatusByID(ctx, db, id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				http.NotFound(w, r)
-			} else {
-				http.Error(w, "Failed to fetch status", http.StatusInternalServerError)
-			}
+			writeDBError(w, r, err, "Failed to fetch status")
 			return
 		}
 
@@ -79,29 +106,44 @@ func GetStatusHandler(db *sql.DB) http.HandlerFunc {
 // UpdateStatusHandler returns a handler for PUT/PATCH /api/statuses/{id}
 func UpdateStatusHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Status ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status ID")
 			return
 		}
 
 		var statusUpdates models.Status
 		err = json.NewDecoder(r.Body).Decode(&statusUpdates)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateStatus(&statusUpdates); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
 			return
 		}
 		statusUpdates.StatusID = id // Ensure ID from URL is used
 
-		updatedStatus, err := updateStatus(db, statusUpdates)
+		var updatedStatus *models.Status
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousStatus, err := getStatusByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if updatedStatus, err = updateStatus(ctx, tx, statusUpdates); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditUpdate, "status", id, previousStatus, updatedStatus)
+		})
 		if err != nil {
-			http.Error(w, "Failed to update status", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to update status")
 			return
 		}
 
@@ -110,60 +152,123 @@ func UpdateStatusHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// DeleteStatusHandler returns a handler for DELETE /api/statuses/{id}
+// DeleteStatusHandler returns a handler for DELETE /api/statuses/{id}, which archives the status
 func DeleteStatusHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
 		if !ok {
-			http.Error(w, "Status ID is required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Status ID is required")
 			return
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid status ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status ID")
 			return
 		}
 
-		err = deleteStatus(db, id)
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousStatus, err := getStatusByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := archiveStatus(ctx, tx, id); err != nil {
+				return err
+			}
+			archivedStatus, err := getStatusByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditArchive, "status", id, previousStatus, archivedStatus)
+		})
 		if err != nil {
-			http.Error(w, "Failed to delete status", http.StatusInternalServerError)
+			writeDBError(w, r, err, "Failed to archive status")
 			return
 		}
 
 		w.WriteHeader(http.StatusOK) // Respond with 200 OK
-		json.NewEncoder(w).Encode(map[string]string{"message": "Status deleted"})
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Status archived"})
+	}
+}
+
+// RestoreStatusHandler returns a handler for POST /api/statuses/{id}/restore
+func RestoreStatusHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid status ID")
+			return
+		}
+
+		var status *models.Status
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousStatus, err := getStatusByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if status, err = restoreStatus(ctx, tx, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditRestore, "status", id, previousStatus, status)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to restore status")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
 	}
 }
 
 // Database interaction functions (private)
 
-func getStatuses(db *sql.DB) ([]models.Status, error) {
-	rows, err := db.Query("SELECT status_id, name, color FROM statuses")
+// statusListSpec defines what GET /api/statuses can sort, filter and search on
+var statusListSpec = listSpec{
+	Fields: map[string]listField{
+		"status_id": {Column: "status_id", Kind: fieldInt},
+		"name":      {Column: "name", Kind: fieldText},
+		"color":     {Column: "color", Kind: fieldText},
+	},
+	Search:      []string{"name"},
+	DefaultSort: "name",
+	TieBreaker:  "status_id",
+	Archived:    "archived_at",
+}
+
+const statusesFrom = `FROM statuses WHERE TRUE`
+
+func getStatuses(ctx context.Context, db dbtx, lq *listQuery) ([]models.Status, error) {
+	var args []interface{}
+	query := "SELECT status_id, name, color, archived_at " + statusesFrom +
+		lq.where(statusListSpec, &args) + lq.after(statusListSpec, &args) + lq.orderBy(statusListSpec) + lq.page()
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var statuses []models.Status
+	statuses := []models.Status{}
 	for rows.Next() {
 		var status models.Status
-		if err := rows.Scan(&status.StatusID, &status.Name, &status.Color); err != nil {
+		if err := rows.Scan(&status.StatusID, &status.Name, &status.Color, &status.ArchivedAt); err != nil {
 			return nil, err
 		}
 		statuses = append(statuses, status)
 	}
-	return statuses, nil
+	return statuses, rows.Err()
 }
 
-func createStatus(db *sql.DB, status models.Status) (*models.Status, error) {
+func createStatus(ctx context.Context, db dbtx, status models.Status) (*models.Status, error) {
 	sqlStatement := `
 		INSERT INTO statuses (name, color)
 		VALUES ($1, $2)
-		RETURNING status_id, name, color`
+		RETURNING status_id, name, color, archived_at`
 	var newStatus models.Status
-	err := db.QueryRow(sqlStatement, status.Name, status.Color).Scan(
-		&newStatus.StatusID, &newStatus.Name, &newStatus.Color,
+	err := db.QueryRowContext(ctx, sqlStatement, status.Name, status.Color).Scan(
+		&newStatus.StatusID, &newStatus.Name, &newStatus.Color, &newStatus.ArchivedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -171,25 +276,25 @@ func createStatus(db *sql.DB, status models.Status) (*models.Status, error) {
 	return &newStatus, nil
 }
 
-func getStatusByID(db *sql.DB, id int64) (*models.Status, error) {
-	sqlStatement := `SELECT status_id, name, color FROM statuses WHERE status_id = $1`
+func getStatusByID(ctx context.Context, db dbtx, id int64) (*models.Status, error) {
+	sqlStatement := `SELECT status_id, name, color, archived_at FROM statuses WHERE status_id = $1`
 	var status models.Status
-	err := db.QueryRow(sqlStatement, id).Scan(&status.StatusID, &status.Name, &status.Color)
+	err := db.QueryRowContext(ctx, sqlStatement, id).Scan(&status.StatusID, &status.Name, &status.Color, &status.ArchivedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &status, nil
 }
 
-func updateStatus(db *sql.DB, status models.Status) (*models.Status, error) {
+func updateStatus(ctx context.Context, db dbtx, status models.Status) (*models.Status, error) {
 	sqlStatement := `
 		UPDATE statuses
 		SET name = $2, color = $3
 		WHERE status_id = $1
-		RETURNING status_id, name, color`
+		RETURNING status_id, name, color, archived_at`
 	var updatedStatus models.Status
-	err := db.QueryRow(sqlStatement, status.StatusID, status.Name, status.Color).Scan(
-		&updatedStatus.StatusID, &updatedStatus.Name, &updatedStatus.Color,
+	err := db.QueryRowContext(ctx, sqlStatement, status.StatusID, status.Name, status.Color).Scan(
+		&updatedStatus.StatusID, &updatedStatus.Name, &updatedStatus.Color, &updatedStatus.ArchivedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -197,8 +302,22 @@ func updateStatus(db *sql.DB, status models.Status) (*models.Status, error) {
 	return &updatedStatus, nil
 }
 
-func deleteStatus(db *sql.DB, id int64) error {
-	sqlStatement := `DELETE FROM statuses WHERE status_id = $1`
-	_, err := db.Exec(sqlStatement, id)
-	return err
+// archiveStatus archives a status, refusing while status chains that are not archived use it.
+// Run it in a transaction, which a refusal must roll back.
+func archiveStatus(ctx context.Context, tx dbtx, id int64) error {
+	if err := archiveRow(ctx, tx, "statuses", "status_id", id); err != nil {
+		return err
+	}
+	return refuseIfAny(ctx, tx, "The status is used by %d status chains that are not archived", `
+		SELECT COUNT(*)
+		FROM status_chains_statuses scs
+		JOIN status_chains sc ON sc.status_chain_id = scs.status_chain_id
+		WHERE scs.status_id = $1 AND sc.archived_at IS NULL`, id)
+}
+
+func restoreStatus(ctx context.Context, db dbtx, id int64) (*models.Status, error) {
+	if err := restoreRow(ctx, db, "statuses", "status_id", id); err != nil {
+		return nil, err
+	}
+	return getStatusByID(ctx, db, id)
 }
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Tabular formats supported by the import/export endpoints
+const (
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	maxImportSize   = 10 << 20 // 10 MB
+)
+
+// table is a header row plus data rows, keyed by lower-cased column name
+type table struct {
+	Header []string
+	Rows   [][]string
+	Lines  []int // 1-based line number in the source file of each row, for error reporting
+}
+
+// value returns the trimmed cell for the given column, or "" if the column or cell is missing
+func (t *table) value(row []string, column string) string {
+	for i, name := range t.Header {
+		if name == column {
+			if i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// hasColumn reports whether the header contains the given column
+func (t *table) hasColumn(column string) bool {
+	for _, name := range t.Header {
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// exportFormat reads the ?format= query parameter, defaulting to CSV
+func exportFormat(r *http.Request) (string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	switch format {
+	case "", formatCSV:
+		return formatCSV, nil
+	case formatXLSX:
+		return formatXLSX, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, use csv or xlsx", format)
+}
+
+// readUploadedTable reads a CSV or XLSX table either from a multipart "file" field or from the raw body.
+// The format is taken from ?format=, then the file extension, then the Content-Type.
+func readUploadedTable(w http.ResponseWriter, r *http.Request) (*table, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var data []byte
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	contentType := r.Header.Get("Content-Type")
+
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("missing \"file\" field in multipart upload: %w", err)
+		}
+		defer file.Close()
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+		}
+		data, err = io.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uploaded file: %w", err)
+		}
+	} else {
+		var err error
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		if format == "" && strings.HasPrefix(contentType, xlsxContentType) {
+			format = formatXLSX
+		}
+	}
+
+	var rows [][]string
+	var lines []int
+	var err error
+	switch format {
+	case "", formatCSV:
+		rows, lines, err = parseCSV(data)
+	case formatXLSX:
+		rows, lines, err = parseXLSX(data)
+	default:
+		return nil, fmt.Errorf("unsupported format %q, use csv or xlsx", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The header is the first row with a cell, blank rows above it are skipped
+	first := 0
+	for first < len(rows) && isBlankRow(rows[first]) {
+		first++
+	}
+	if first == len(rows) {
+		return nil, fmt.Errorf("file is empty, a header row is required")
+	}
+
+	header := make([]string, len(rows[first]))
+	for i, name := range rows[first] {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff") // Excel writes a BOM in front of UTF-8 CSV files
+
+	t := &table{Header: header}
+	for i := first + 1; i < len(rows); i++ {
+		if isBlankRow(rows[i]) {
+			continue
+		}
+		for j, cell := range rows[i] {
+			rows[i][j] = unescapeCell(cell)
+		}
+		t.Rows = append(t.Rows, rows[i])
+		t.Lines = append(t.Lines, lines[i])
+	}
+	return t, nil
+}
+
+// parseCSV returns the records of a CSV file with the line each starts on, which differs from its index when
+// the reader skips empty lines or a quoted field spans several lines
+func parseCSV(data []byte) ([][]string, []int, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1 // Tolerate ragged rows, missing cells are read as empty
+	reader.TrimLeadingSpace = true
+	// Files exported from a European Excel use ';' as separator
+	if firstLine, _, _ := bytes.Cut(data, []byte("\n")); bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+	var rows [][]string
+	var lines []int
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV file: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+	return rows, lines, nil
+}
+
+// parseXLSX returns the rows of the first sheet with their row numbers. GetRows keeps blank rows in place, so
+// the row number is the index plus one.
+func parseXLSX(data []byte) ([][]string, []int, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid XLSX file: %w", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, nil, fmt.Errorf("XLSX file has no sheets")
+	}
+	rows, err := f.GetRows(sheets[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read sheet %q: %w", sheets[0], err)
+	}
+	lines := make([]int, len(rows))
+	for i := range rows {
+		lines[i] = i + 1
+	}
+	return rows, lines, nil
+}
+
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// formulaPrefixes are the first characters that make a spreadsheet run a cell as a formula
+const formulaPrefixes = "=+-@\t\r"
+
+// escapeCell quotes a cell a spreadsheet would run as a formula, and a cell already starting with a quote so
+// that unescapeCell can tell the two apart
+func escapeCell(cell string) string {
+	if cell != "" && strings.ContainsRune(formulaPrefixes+"'", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// unescapeCell removes the quote escapeCell put in front of an exported cell
+func unescapeCell(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune(formulaPrefixes+"'", rune(cell[1])) {
+		return cell[1:]
+	}
+	return cell
+}
+
+// writeTable streams a table to the client as a CSV or XLSX attachment named <name>.<format>.
+// Cells that would run as formulas are escaped with a leading quote, which the import removes.
+func writeTable(w http.ResponseWriter, format string, name string, t *table) error {
+	rows := make([][]string, len(t.Rows))
+	for i, row := range t.Rows {
+		rows[i] = make([]string, len(row))
+		for j, cell := range row {
+			rows[i][j] = escapeCell(cell)
+		}
+	}
+	filename := fmt.Sprintf("%s.%s", name, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == formatXLSX {
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		for i, row := range append([][]string{t.Header}, rows...) {
+			cell, err := excelize.CoordinatesToCellName(1, i+1)
+			if err != nil {
+				return err
+			}
+			values := make([]interface{}, len(row))
+			for j, v := range row {
+				values[j] = v
+			}
+			if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+				return err
+			}
+		}
+		w.Header().Set("Content-Type", xlsxContentType)
+		return f.Write(w)
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.Header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadUploadedTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		header  []string
+		rows    [][]string
+		lines   []int
+		wantErr string
+	}{
+		{
+			name:   "blank rows above the header and between rows",
+			body:   "\n,,\nName,VAT_Number\nAcme,IT01234567897\n,\nBeta,\n",
+			header: []string{"name", "vat_number"},
+			rows:   [][]string{{"Acme", "IT01234567897"}, {"Beta", ""}},
+			lines:  []int{4, 6},
+		},
+		{
+			name:   "quoted field over several lines",
+			body:   "name,address\n\"Acme\",\"Via Roma 1\nMilano\"\nBeta,Torino\n",
+			header: []string{"name", "address"},
+			rows:   [][]string{{"Acme", "Via Roma 1\nMilano"}, {"Beta", "Torino"}},
+			lines:  []int{2, 4},
+		},
+		{
+			name:   "semicolon separator and byte order mark",
+			body:   "\ufeffname;address\nAcme;Via Roma 1, Milano\n",
+			header: []string{"name", "address"},
+			rows:   [][]string{{"Acme", "Via Roma 1, Milano"}},
+			lines:  []int{2},
+		},
+		{
+			name:   "escaped formula",
+			body:   "name\n'=1+1\n'-3\n'abc\n",
+			header: []string{"name"},
+			rows:   [][]string{{"=1+1"}, {"-3"}, {"'abc"}},
+			lines:  []int{2, 3, 4},
+		},
+		{name: "empty file", body: "", wantErr: "a header row is required"},
+		{name: "only blank rows", body: "\n,,\n , \n", wantErr: "a header row is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/accounts/import?format=csv", strings.NewReader(tt.body))
+			table, err := readUploadedTable(httptest.NewRecorder(), r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(table.Header, tt.header) {
+				t.Errorf("header = %q, want %q", table.Header, tt.header)
+			}
+			if !reflect.DeepEqual(table.Rows, tt.rows) {
+				t.Errorf("rows = %q, want %q", table.Rows, tt.rows)
+			}
+			if !reflect.DeepEqual(table.Lines, tt.lines) {
+				t.Errorf("lines = %v, want %v", table.Lines, tt.lines)
+			}
+		})
+	}
+}
+
+func TestEscapeCell(t *testing.T) {
+	tests := []struct {
+		cell, escaped string
+	}{
+		{"", ""},
+		{"Acme", "Acme"},
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"+39 02 1234", "'+39 02 1234"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tTab", "'\tTab"},
+		{"'quoted", "''quoted"},
+		{"'=x", "''=x"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		if got := escapeCell(tt.cell); got != tt.escaped {
+			t.Errorf("escapeCell(%q) = %q, want %q", tt.cell, got, tt.escaped)
+		}
+		if got := unescapeCell(escapeCell(tt.cell)); got != tt.cell {
+			t.Errorf("unescapeCell(escapeCell(%q)) = %q, want it back", tt.cell, got)
+		}
+	}
+}
+
+func TestWriteTableRoundTrip(t *testing.T) {
+	for _, format := range []string{formatCSV, formatXLSX} {
+		t.Run(format, func(t *testing.T) {
+			want := &table{
+				Header: []string{"name", "tipo_contenitore"},
+				Rows:   [][]string{{"=cmd|' /C calc'!A0", "-box"}, {"'Acme", "@crate"}, {"Beta", "KLT"}},
+			}
+			w := httptest.NewRecorder()
+			if err := writeTable(w, format, "export", want); err != nil {
+				t.Fatal(err)
+			}
+			if format == formatCSV && strings.Contains(w.Body.String(), "\n=") {
+				t.Errorf("CSV export has a cell starting with a formula:\n%s", w.Body.String())
+			}
+
+			r := httptest.NewRequest("POST", "/api/products/import?format="+format, w.Body)
+			got, err := readUploadedTable(httptest.NewRecorder(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Rows, want.Rows) {
+				t.Errorf("rows = %q, want %q", got.Rows, want.Rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"strings"
+
+	"electronic_kanban_backend/utils"
+)
+
+// TenantHeader names the tenant of the user making a request. It is set by the authenticating proxy in front
+// of the API, and only read when the configuration trusts it, since clients could send any tenant.
+const TenantHeader = "X-Tenant"
+
+// tenantlessPaths are served without a tenant: probes and metrics are for the operators, not the tenants
+var tenantlessPaths = map[string]bool{
+	"/healthz":          true,
+	"/readyz":           true,
+	"/metrics":          true,
+	"/api/openapi.json": true,
+}
+
+// TenantMiddleware finds the tenant of every request and puts it in the request context, where the database
+// connections take it from, so the handlers only ever see the rows of that tenant. The tenant is the subdomain
+// of cfg.BaseDomain the request is sent to, or the tenant of the user in TenantHeader, or the default tenant;
+// a user of one tenant cannot use the subdomain of another. It must run inside LoggingMiddleware.
+func TenantMiddleware(db *sql.DB, cfg utils.TenancyConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tenantlessPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			slug := subdomainTenant(r.Host, cfg.BaseDomain)
+			if cfg.TrustHeader {
+				if user := strings.ToLower(strings.TrimSpace(r.Header.Get(TenantHeader))); user != "" {
+					if slug != "" && slug != user {
+						writeError(w, r, http.StatusForbidden, codeForbidden, "Your account belongs to another tenant than "+slug)
+						return
+					}
+					slug = user
+				}
+			}
+			if slug == "" {
+				slug = cfg.DefaultTenant
+			}
+			if slug == "" {
+				writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "The request names no tenant, use the address of your company")
+				return
+			}
+
+			tenant, err := getTenantBySlug(r.Context(), db, slug)
+			if err == sql.ErrNoRows {
+				writeError(w, r, http.StatusNotFound, codeNotFound, "No tenant named "+slug)
+				return
+			} else if err != nil {
+				writeDBError(w, r, err, "Failed to find the tenant")
+				return
+			}
+			ctx := utils.WithTenant(r.Context(), *tenant)
+			ctx = context.WithValue(ctx, loggerKey{}, requestLogger(r).With("tenant", tenant.Slug))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// subdomainTenant returns the tenant named by the subdomain of baseDomain in host, or "" when host isn't one
+func subdomainTenant(host string, baseDomain string) string {
+	if baseDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	label, ok := strings.CutSuffix(host, "."+strings.ToLower(baseDomain))
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// tenantFromRequest returns the tenant TenantMiddleware found for r
+func tenantFromRequest(r *http.Request) utils.Tenant {
+	tenant, _ := utils.TenantFromContext(r.Context())
+	return tenant
+}
+
+// Database interaction functions (private)
+
+// getTenantBySlug reads a tenant; the tenants table has no row-level security, it is the list of tenants
+func getTenantBySlug(ctx context.Context, db dbtx, slug string) (*utils.Tenant, error) {
+	var tenant utils.Tenant
+	err := db.QueryRowContext(ctx, `SELECT id, slug FROM tenants WHERE slug = $1`, slug).Scan(&tenant.ID, &tenant.Slug)
+	if err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
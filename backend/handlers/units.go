@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"electronic_kanban_backend/models"
+)
+
+// Units of measure of products and of the quantities of kanban chains
+const (
+	unitPieces = "pcs"
+	unitKg     = "kg"
+)
+
+// Dimensions a unit of measure can measure
+const (
+	dimensionCount  = "count"
+	dimensionMass   = "mass"
+	dimensionLength = "length"
+	dimensionVolume = "volume"
+)
+
+// unitOfMeasure is a unit and its size in the base unit of its dimension: pcs, kg, m or l
+type unitOfMeasure struct {
+	dimension string
+	factor    float64
+}
+
+// unitsOfMeasure are the units a product or a kanban chain can use
+var unitsOfMeasure = map[string]unitOfMeasure{
+	unitPieces: {dimensionCount, 1},
+	unitKg:     {dimensionMass, 1},
+	"g":        {dimensionMass, 0.001},
+	"t":        {dimensionMass, 1000},
+	"lb":       {dimensionMass, 0.45359237},
+	"m":        {dimensionLength, 1},
+	"l":        {dimensionVolume, 1},
+}
+
+// unitNames lists the units of measure, for error messages
+func unitNames() string {
+	names := make([]string, 0, len(unitsOfMeasure))
+	for name := range unitsOfMeasure {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// convertQuantity converts quantity of product from one unit to another. Units of the same dimension convert by
+// their sizes; a mass converts to and from the product's unit of measure through its net weight. It returns false
+// when the units don't convert for the product, such as kilograms of a product without a net weight.
+func convertQuantity(quantity float64, from, to string, product models.Product) (float64, bool) {
+	if from == to {
+		return quantity, true
+	}
+	f, okFrom := unitsOfMeasure[from]
+	t, okTo := unitsOfMeasure[to]
+	if !okFrom || !okTo {
+		return 0, false
+	}
+	if f.dimension == t.dimension {
+		return quantity * f.factor / t.factor, true
+	}
+
+	// Through the product's unit of measure, whose net weight makes it a mass
+	p, ok := unitsOfMeasure[product.UnitOfMeasure]
+	if !ok || product.NetWeightKg == nil || *product.NetWeightKg <= 0 {
+		return 0, false
+	}
+	var inProductUnit float64
+	switch f.dimension {
+	case p.dimension:
+		inProductUnit = quantity * f.factor / p.factor
+	case dimensionMass:
+		inProductUnit = quantity * f.factor / *product.NetWeightKg
+	default:
+		return 0, false
+	}
+	switch t.dimension {
+	case p.dimension:
+		return inProductUnit * p.factor / t.factor, true
+	case dimensionMass:
+		return inProductUnit * *product.NetWeightKg / t.factor, true
+	}
+	return 0, false
+}
+
+// dashboardQuantity returns a quantity of a kanban chain in unit, and unit, for a dashboard. A quantity shows in
+// the product's unit of measure when unit is empty or doesn't convert for the product, so the quantities of a
+// product add up whatever the units of its chains; in the chain's unit when even that fails.
+func dashboardQuantity(quantity float64, chainUnit string, product models.Product, unit string) (float64, string) {
+	inProductUnit, ok := convertQuantity(quantity, chainUnit, product.UnitOfMeasure, product)
+	if !ok {
+		return quantity, chainUnit
+	}
+	if unit != "" {
+		if converted, ok := convertQuantity(inProductUnit, product.UnitOfMeasure, unit, product); ok {
+			return roundQuantity(converted), unit
+		}
+	}
+	return roundQuantity(inProductUnit), product.UnitOfMeasure
+}
+
+// roundQuantity rounds a converted quantity to three decimals, dropping the noise of the conversion
+func roundQuantity(quantity float64) float64 {
+	return math.Round(quantity*1000) / 1000
+}
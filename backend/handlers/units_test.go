@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+
+	"electronic_kanban_backend/models"
+)
+
+func TestConvertQuantity(t *testing.T) {
+	netWeight, noWeight := 0.25, 0.0
+	pieces := models.Product{UnitOfMeasure: unitPieces, NetWeightKg: &netWeight}
+	unweighed := models.Product{UnitOfMeasure: unitPieces}
+	zeroWeight := models.Product{UnitOfMeasure: unitPieces, NetWeightKg: &noWeight}
+	litres := models.Product{UnitOfMeasure: "l", NetWeightKg: &netWeight}
+	tests := []struct {
+		name     string
+		quantity float64
+		from, to string
+		product  models.Product
+		want     float64
+		wantOK   bool
+	}{
+		{"same unit", 12, "pcs", "pcs", unweighed, 12, true},
+		{"same unknown unit", 12, "box", "box", unweighed, 12, true},
+		{"within mass", 1500, "g", "kg", unweighed, 1.5, true},
+		{"within mass, down", 2, "t", "g", unweighed, 2000000, true},
+		{"pounds", 10, "lb", "kg", unweighed, 4.5359237, true},
+		{"pieces to mass through net weight", 8, "pcs", "kg", pieces, 2, true},
+		{"mass to pieces through net weight", 500, "g", "pcs", pieces, 2, true},
+		{"litres to mass", 4, "l", "t", litres, 0.001, true},
+		{"unknown from unit", 1, "box", "pcs", pieces, 0, false},
+		{"unknown to unit", 1, "pcs", "box", pieces, 0, false},
+		{"mass of a product without net weight", 8, "pcs", "kg", unweighed, 0, false},
+		{"mass of a product with zero net weight", 8, "pcs", "kg", zeroWeight, 0, false},
+		{"mass of a product of unknown unit", 8, "pcs", "kg", models.Product{UnitOfMeasure: "box", NetWeightKg: &netWeight}, 0, false},
+		{"length to volume", 1, "m", "l", litres, 0, false},
+		{"pieces of a product measured in litres", 1, "pcs", "kg", litres, 0, false},
+		{"mass to a dimension the product isn't in", 1, "kg", "m", pieces, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := convertQuantity(tt.quantity, tt.from, tt.to, tt.product)
+			if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("convertQuantity(%v, %q, %q) = %v, %v, want %v, %v", tt.quantity, tt.from, tt.to, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDashboardQuantity(t *testing.T) {
+	netWeight := 0.3
+	pieces := models.Product{UnitOfMeasure: unitPieces, NetWeightKg: &netWeight}
+	unweighed := models.Product{UnitOfMeasure: unitPieces}
+	kilograms := models.Product{UnitOfMeasure: unitKg}
+	tests := []struct {
+		name      string
+		quantity  float64
+		chainUnit string
+		product   models.Product
+		unit      string
+		want      float64
+		wantUnit  string
+	}{
+		{"product unit by default", 500, "g", kilograms, "", 0.5, "kg"},
+		{"asked unit", 10, "pcs", pieces, "kg", 3, "kg"},
+		{"rounded to three decimals", 1, "pcs", pieces, "lb", 0.661, "lb"},
+		{"asked unit that doesn't convert", 10, "pcs", unweighed, "kg", 10, "pcs"},
+		{"asked unit of another dimension", 2, "kg", kilograms, "l", 2, "kg"},
+		{"chain unit that doesn't convert to the product's", 5, "kg", unweighed, "", 5, "kg"},
+		{"chain unit that doesn't convert, asked unit ignored", 5, "kg", unweighed, "g", 5, "kg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unit := dashboardQuantity(tt.quantity, tt.chainUnit, tt.product, tt.unit)
+			if got != tt.want || unit != tt.wantUnit {
+				t.Errorf("dashboardQuantity(%v, %q, %q) = %v %s, want %v %s", tt.quantity, tt.chainUnit, tt.unit, got, unit, tt.want, tt.wantUnit)
+			}
+		})
+	}
+}
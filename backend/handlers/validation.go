@@ -0,0 +1,573 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"electronic_kanban_backend/models"
+)
+
+// Validation of create and update payloads. The validate* functions trim and normalise the values
+// in place (so what is stored is what was checked) and return one FieldError per invalid field,
+// named after its JSON key. The ones taking a dbtx also check the records the payload references.
+
+const maxNameLength = 255
+
+// fieldErrorList collects the invalid fields of a payload
+type fieldErrorList []models.FieldError
+
+func (l *fieldErrorList) add(field string, format string, args ...interface{}) {
+	*l = append(*l, models.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// requireName checks a mandatory, length-limited text field
+func (l *fieldErrorList) requireName(field string, value string) {
+	switch {
+	case value == "":
+		l.add(field, "%s is required", field)
+	case len(value) > maxNameLength:
+		l.add(field, "%s must be at most %d characters", field, maxNameLength)
+	}
+}
+
+func validateAccount(account *models.Account) []models.FieldError {
+	var errs fieldErrorList
+	account.Name = strings.TrimSpace(account.Name)
+	account.VATNumber = normalizeVATNumber(account.VATNumber)
+	account.Address = strings.TrimSpace(account.Address)
+
+	errs.requireName("name", account.Name)
+	if account.VATNumber != "" {
+		if msg := vatNumberError(account.VATNumber); msg != "" {
+			errs.add("vat_number", "%s", msg)
+		}
+	}
+	return errs
+}
+
+// validateSite checks a site and that its account exists and is not archived
+func validateSite(ctx context.Context, db dbtx, site *models.Site) ([]models.FieldError, error) {
+	var errs fieldErrorList
+	site.Name = strings.TrimSpace(site.Name)
+	site.Address = strings.TrimSpace(site.Address)
+
+	errs.requireName("name", site.Name)
+	if site.AccountID <= 0 {
+		errs.add("account_id", "account_id is required")
+		return errs, nil
+	}
+	found, err := rowExists(ctx, db, `SELECT 1 FROM accounts WHERE id = $1 AND archived_at IS NULL`, site.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		errs.add("account_id", "account %d does not exist or is archived", site.AccountID)
+	}
+	return errs, nil
+}
+
+func validateWorkCentre(workCentre *models.WorkCentre) []models.FieldError {
+	var errs fieldErrorList
+	workCentre.Code = strings.TrimSpace(workCentre.Code)
+	workCentre.Name = strings.TrimSpace(workCentre.Name)
+
+	errs.requireName("code", workCentre.Code)
+	errs.requireName("name", workCentre.Name)
+	return errs
+}
+
+// validateContact checks a contact and normalises its notification preferences: no channel is none, and each
+// event is listed once
+func validateContact(contact *models.Contact) []models.FieldError {
+	var errs fieldErrorList
+	contact.Name = strings.TrimSpace(contact.Name)
+	contact.Email = strings.TrimSpace(contact.Email)
+	contact.Phone = strings.TrimSpace(contact.Phone)
+	contact.Role = strings.TrimSpace(contact.Role)
+	contact.Notifications.Channel = strings.TrimSpace(contact.Notifications.Channel)
+
+	errs.requireName("name", contact.Name)
+	if contact.Email != "" {
+		if address, err := mail.ParseAddress(contact.Email); err != nil || address.Address != contact.Email {
+			errs.add("email", "email must be a plain address like name@example.com, got %q", contact.Email)
+		}
+	}
+	if contact.Phone != "" && !validPhone(contact.Phone) {
+		errs.add("phone", "phone must be 6 to 15 digits, optionally with a leading + and spaces, dashes, dots or brackets, got %q", contact.Phone)
+	}
+	switch contact.Role {
+	case "":
+		errs.add("role", "role is required")
+	case contactRolePlanner, contactRoleLogistics, contactRoleQuality:
+	default:
+		errs.add("role", "role must be %s, %s or %s", contactRolePlanner, contactRoleLogistics, contactRoleQuality)
+	}
+
+	switch contact.Notifications.Channel {
+	case "", notifyChannelNone:
+		contact.Notifications.Channel = notifyChannelNone
+	case notifyChannelEmail:
+		if contact.Email == "" {
+			errs.add("notifications.channel", "notifications by email need an email")
+		}
+	case notifyChannelSMS:
+		if contact.Phone == "" {
+			errs.add("notifications.channel", "notifications by sms need a phone")
+		}
+	default:
+		errs.add("notifications.channel", "notifications.channel must be %s, %s or %s", notifyChannelNone, notifyChannelEmail, notifyChannelSMS)
+	}
+	events := []string{}
+	seen := map[string]bool{}
+	for i, event := range contact.Notifications.Events {
+		event = strings.TrimSpace(event)
+		switch event {
+		case notifyEventOrder, notifyEventOverdue, notifyEventStockOutRisk:
+		default:
+			errs.add(fmt.Sprintf("notifications.events[%d]", i), "event must be %s, %s or %s, got %q",
+				notifyEventOrder, notifyEventOverdue, notifyEventStockOutRisk, event)
+			continue
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	contact.Notifications.Events = events
+	return errs
+}
+
+func validateProduct(product *models.Product) []models.FieldError {
+	var errs fieldErrorList
+	product.ProductID = strings.TrimSpace(product.ProductID)
+	product.Name = strings.TrimSpace(product.Name)
+
+	product.UnitOfMeasure = strings.TrimSpace(product.UnitOfMeasure)
+	product.CustomerPartNumber = strings.TrimSpace(product.CustomerPartNumber)
+	product.SupplierPartNumber = strings.TrimSpace(product.SupplierPartNumber)
+	product.DefaultContainerType = strings.TrimSpace(product.DefaultContainerType)
+
+	errs.requireName("product_id", product.ProductID)
+	errs.requireName("name", product.Name)
+	if product.UnitOfMeasure == "" {
+		product.UnitOfMeasure = unitPieces
+	} else if _, ok := unitsOfMeasure[product.UnitOfMeasure]; !ok {
+		errs.add("unit_of_measure", "unit_of_measure must be one of %s, got %q", unitNames(), product.UnitOfMeasure)
+	}
+	if product.NetWeightKg != nil && *product.NetWeightKg <= 0 {
+		errs.add("net_weight_kg", "net_weight_kg must be a positive number")
+	}
+	if product.GrossWeightKg != nil {
+		switch {
+		case *product.GrossWeightKg <= 0:
+			errs.add("gross_weight_kg", "gross_weight_kg must be a positive number")
+		case product.NetWeightKg != nil && *product.GrossWeightKg < *product.NetWeightKg:
+			errs.add("gross_weight_kg", "gross_weight_kg cannot be less than net_weight_kg")
+		}
+	}
+	for _, f := range []struct {
+		field string
+		value string
+	}{
+		{"customer_part_number", product.CustomerPartNumber},
+		{"supplier_part_number", product.SupplierPartNumber},
+		{"default_container_type", product.DefaultContainerType},
+	} {
+		if len(f.value) > maxNameLength {
+			errs.add(f.field, "%s must be at most %d characters", f.field, maxNameLength)
+		}
+	}
+	if product.UnitsPerContainer != nil && *product.UnitsPerContainer <= 0 {
+		errs.add("units_per_container", "units_per_container must be a positive number")
+	}
+	return errs
+}
+
+func validateStatus(status *models.Status) []models.FieldError {
+	var errs fieldErrorList
+	status.Name = strings.TrimSpace(status.Name)
+	status.Color = strings.TrimSpace(status.Color)
+
+	errs.requireName("name", status.Name)
+	if status.Color == "" {
+		errs.add("color", "color is required")
+	} else if !validColor(status.Color) {
+		errs.add("color", "color must be a hex colour like #1e90ff or #19f, or a CSS colour name, got %q", status.Color)
+	}
+	return errs
+}
+
+func validateStatusChain(statusChain *models.StatusChain) []models.FieldError {
+	var errs fieldErrorList
+	statusChain.Name = strings.TrimSpace(statusChain.Name)
+
+	errs.requireName("name", statusChain.Name)
+	return errs
+}
+
+// validateStatusChainStatuses checks the statuses linked into a chain: each status exists and appears once,
+// orders are positive and distinct, each status is owned by the supplier (1) or the customer (2), and the stage,
+// when set, is a stage of the kanban loop.
+// Fields are reported as statuses[i].field.
+func validateStatusChainStatuses(ctx context.Context, db dbtx, statuses []models.StatusChainStatus) ([]models.FieldError, error) {
+	var errs fieldErrorList
+	seenStatus := map[int64]bool{}
+	seenOrder := map[int64]bool{}
+	for i, s := range statuses {
+		prefix := fmt.Sprintf("statuses[%d].", i)
+		if s.StatusID <= 0 {
+			errs.add(prefix+"status_id", "status_id is required")
+		} else if seenStatus[s.StatusID] {
+			errs.add(prefix+"status_id", "status %d appears more than once in the chain", s.StatusID)
+		} else {
+			seenStatus[s.StatusID] = true
+			found, err := rowExists(ctx, db, `SELECT 1 FROM statuses WHERE status_id = $1 AND archived_at IS NULL`, s.StatusID)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				errs.add(prefix+"status_id", "status %d does not exist or is archived", s.StatusID)
+			}
+		}
+		if s.Order <= 0 {
+			errs.add(prefix+"order", "order must be a positive number")
+		} else if seenOrder[s.Order] {
+			errs.add(prefix+"order", "order %d is used more than once in the chain", s.Order)
+		}
+		seenOrder[s.Order] = true
+		if s.CustomerSupplier != 1 && s.CustomerSupplier != 2 {
+			errs.add(prefix+"customer_supplier", "customer_supplier must be 1 (supplier) or 2 (customer)")
+		}
+		if s.Stage != "" && !validStage(s.Stage) {
+			errs.add(prefix+"stage", "stage must be %s, %s, %s or %s", stageToProduce, stageInProduction, stageInTransit, stageAtCustomer)
+		}
+	}
+	return errs, nil
+}
+
+// applyProductDefaults fills in the container, unit and quantity a kanban chain leaves empty from the packaging of
+// its product, and checks that the chain's unit converts to the product's unit of measure
+func applyProductDefaults(kc *models.KanbanChain, product models.Product) fieldErrorList {
+	var errs fieldErrorList
+	if kc.TipoContenitore == "" {
+		kc.TipoContenitore = product.DefaultContainerType
+	}
+	if kc.QuantityUnit == "" {
+		kc.QuantityUnit = product.UnitOfMeasure
+	}
+	if _, ok := unitsOfMeasure[kc.QuantityUnit]; !ok {
+		errs.add("quantity_unit", "quantity_unit must be one of %s, got %q", unitNames(), kc.QuantityUnit)
+		return errs
+	}
+	if _, ok := convertQuantity(1, kc.QuantityUnit, product.UnitOfMeasure, product); !ok {
+		errs.add("quantity_unit", "quantity_unit %s does not convert to %s, the unit of measure of product %q; give the product a net weight",
+			kc.QuantityUnit, product.UnitOfMeasure, product.ProductID)
+		return errs
+	}
+	if kc.Quantity == 0 && product.UnitsPerContainer != nil {
+		quantity, _ := convertQuantity(*product.UnitsPerContainer, product.UnitOfMeasure, kc.QuantityUnit, product)
+		kc.Quantity = roundQuantity(quantity)
+	}
+	return errs
+}
+
+// validateKanbanChain checks a kanban chain and that its customer, supplier, product and status chain exist
+// and are not archived.
+// The customer and the supplier are each either an account or an internal work centre, never both.
+// The status chain must have at least one status, since the chain's cards start in its first one.
+// The customer and supplier sites are optional; when set, they must be sites of the chain's customer
+// and supplier accounts that are not archived.
+// The kanban type defaults to withdrawal. A production kanban orders parts from a work centre, and only
+// a production kanban may loop within a single work centre.
+// A chain without a container, quantity unit or quantity takes them from the packaging of its product.
+func validateKanbanChain(ctx context.Context, db dbtx, kc *models.KanbanChain) ([]models.FieldError, error) {
+	var errs fieldErrorList
+	kc.ProdottoCodice = strings.TrimSpace(kc.ProdottoCodice)
+	kc.TipoContenitore = strings.TrimSpace(kc.TipoContenitore)
+	kc.QuantityUnit = strings.TrimSpace(kc.QuantityUnit)
+
+	if kc.LeadtimeDays <= 0 {
+		errs.add("leadtime_days", "leadtime_days must be a positive number of days")
+	}
+	if kc.ProdottoCodice == "" {
+		errs.add("prodotto_codice", "prodotto_codice is required")
+	} else {
+		product, err := getProductByID(ctx, db, kc.ProdottoCodice)
+		switch {
+		case err == sql.ErrNoRows || (err == nil && product.ArchivedAt != nil):
+			errs.add("prodotto_codice", "product %q does not exist or is archived", kc.ProdottoCodice)
+		case err != nil:
+			return nil, err
+		default:
+			errs = append(errs, applyProductDefaults(kc, *product)...)
+		}
+	}
+	if kc.Quantity <= 0 {
+		errs.add("quantity", "quantity must be a positive number")
+	}
+	if len(kc.TipoContenitore) > maxNameLength {
+		errs.add("tipo_contenitore", "tipo_contenitore must be at most %d characters", maxNameLength)
+	}
+	if kc.NoOfActiveKanbans < 0 {
+		errs.add("no_of_active_kanbans", "no_of_active_kanbans cannot be negative")
+	}
+	if kc.KanbanType == "" {
+		kc.KanbanType = kanbanTypeWithdrawal
+	}
+	switch kc.KanbanType {
+	case kanbanTypeWithdrawal:
+		if kc.CustomerWorkCentreID != nil && kc.SupplierWorkCentreID != nil && *kc.CustomerWorkCentreID == *kc.SupplierWorkCentreID {
+			errs.add("kanban_type", "a withdrawal kanban moves parts between two different work centres")
+		}
+	case kanbanTypeProduction:
+		if kc.SupplierWorkCentreID == nil {
+			errs.add("kanban_type", "a production kanban needs a work centre as supplier")
+		}
+	default:
+		errs.add("kanban_type", "kanban_type must be %s or %s", kanbanTypeWithdrawal, kanbanTypeProduction)
+	}
+
+	endpoints := []struct {
+		role            string
+		accountField    string
+		accountID       int64
+		workCentreField string
+		workCentreID    *int64
+	}{
+		{"customer", "cliente_id", kc.ClienteID, "customer_work_centre_id", kc.CustomerWorkCentreID},
+		{"supplier", "fornitore_id", kc.FornitoreID, "supplier_work_centre_id", kc.SupplierWorkCentreID},
+	}
+	for _, e := range endpoints {
+		var found bool
+		var err error
+		switch {
+		case e.workCentreID != nil && e.accountID != 0:
+			errs.add(e.workCentreField, "set either %s or %s, not both", e.accountField, e.workCentreField)
+			continue
+		case e.workCentreID != nil:
+			if found, err = rowExists(ctx, db, `SELECT 1 FROM work_centres WHERE id = $1 AND archived_at IS NULL`, *e.workCentreID); err == nil && !found {
+				errs.add(e.workCentreField, "%s work centre %d does not exist or is archived", e.role, *e.workCentreID)
+			}
+		case e.accountID <= 0:
+			errs.add(e.accountField, "%s or %s is required", e.accountField, e.workCentreField)
+			continue
+		default:
+			if found, err = rowExists(ctx, db, `SELECT 1 FROM accounts WHERE id = $1 AND archived_at IS NULL`, e.accountID); err == nil && !found {
+				errs.add(e.accountField, "%s account %d does not exist or is archived", e.role, e.accountID)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if kc.StatusChainID <= 0 {
+		errs.add("status_chain_id", "status_chain_id is required")
+	} else {
+		found, err := rowExists(ctx, db, `
+			SELECT 1 FROM status_chains sc
+			JOIN status_chains_statuses scs ON scs.status_chain_id = sc.status_chain_id
+			WHERE sc.status_chain_id = $1 AND sc.archived_at IS NULL`, kc.StatusChainID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			errs.add("status_chain_id", "status chain %d does not exist, is archived or has no statuses", kc.StatusChainID)
+		}
+	}
+
+	sites := []struct {
+		field     string
+		siteID    *int64
+		accountID int64
+		role      string
+	}{
+		{"customer_site_id", kc.CustomerSiteID, kc.ClienteID, "customer"},
+		{"supplier_site_id", kc.SupplierSiteID, kc.FornitoreID, "supplier"},
+	}
+	for _, s := range sites {
+		if s.siteID == nil {
+			continue
+		}
+		if s.accountID == 0 {
+			errs.add(s.field, "only a %s account has sites", s.role)
+			continue
+		}
+		found, err := rowExists(ctx, db, `SELECT 1 FROM sites WHERE id = $1 AND account_id = $2 AND archived_at IS NULL`, *s.siteID, s.accountID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			errs.add(s.field, "site %d is not a site of the %s account or is archived", *s.siteID, s.role)
+		}
+	}
+	return errs, nil
+}
+
+// validateKanban checks a new kanban card against its kanban chain: the card must use the chain's status chain,
+// and its current status must belong to that status chain.
+func validateKanban(ctx context.Context, db dbtx, k *models.Kanban) ([]models.FieldError, error) {
+	var errs fieldErrorList
+	k.TipoContenitore = strings.TrimSpace(k.TipoContenitore)
+
+	if k.LeadtimeDays <= 0 {
+		errs.add("leadtime_days", "leadtime_days must be a positive number of days")
+	}
+	if k.Quantity <= 0 {
+		errs.add("quantity", "quantity must be a positive number")
+	}
+	if len(k.TipoContenitore) > maxNameLength {
+		errs.add("tipo_contenitore", "tipo_contenitore must be at most %d characters", maxNameLength)
+	}
+
+	if k.KanbanChainID <= 0 {
+		errs.add("kanban_chain_id", "kanban_chain_id is required")
+		return errs, nil
+	}
+	var chainStatusChainID int64
+	err := db.QueryRowContext(ctx, `SELECT status_chain_id FROM kanban_chains WHERE id = $1 AND archived_at IS NULL`, k.KanbanChainID).Scan(&chainStatusChainID)
+	if err == sql.ErrNoRows {
+		errs.add("kanban_chain_id", "kanban chain %d does not exist or is archived", k.KanbanChainID)
+		return errs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case k.StatusChainID <= 0:
+		errs.add("status_chain_id", "status_chain_id is required")
+	case k.StatusChainID != chainStatusChainID:
+		errs.add("status_chain_id", "kanban chain %d uses status chain %d, not %d", k.KanbanChainID, chainStatusChainID, k.StatusChainID)
+	case k.StatusCurrent <= 0:
+		errs.add("status_current", "status_current is required")
+	default:
+		found, err := rowExists(ctx, db, `SELECT 1 FROM status_chains_statuses WHERE status_chain_id = $1 AND status_id = $2`, k.StatusChainID, k.StatusCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			errs.add("status_current", "status %d is not part of status chain %d", k.StatusCurrent, k.StatusChainID)
+		}
+	}
+	return errs, nil
+}
+
+// validateKanbanEdit checks the fields sent to the kanban edit form
+func validateKanbanEdit(req *kanbanEditRequest) []models.FieldError {
+	var errs fieldErrorList
+	if req.LeadtimeDays != nil && *req.LeadtimeDays <= 0 {
+		errs.add("leadtime_days", "leadtime_days must be a positive number of days")
+	}
+	if req.Quantity != nil && *req.Quantity <= 0 {
+		errs.add("quantity", "quantity must be a positive number")
+	}
+	if req.TipoContenitore != nil {
+		trimmed := strings.TrimSpace(*req.TipoContenitore)
+		req.TipoContenitore = &trimmed
+		if len(trimmed) > maxNameLength {
+			errs.add("tipo_contenitore", "tipo_contenitore must be at most %d characters", maxNameLength)
+		}
+	}
+	return errs
+}
+
+// rowExists runs a query selecting a constant and tells whether it returned a row
+func rowExists(ctx context.Context, db dbtx, query string, args ...interface{}) (bool, error) {
+	var found bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (`+query+`)`, args...).Scan(&found)
+	return found, err
+}
+
+// normalizeVATNumber uppercases a VAT number and strips the spaces, dots and dashes people type in it
+func normalizeVATNumber(vatNumber string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(strings.TrimSpace(vatNumber)))
+}
+
+var (
+	italianVATPattern = regexp.MustCompile(`^(IT)?[0-9]{11}$`)
+	foreignVATPattern = regexp.MustCompile(`^[A-Z]{2}[0-9A-Z+*]{2,13}$`)
+)
+
+// vatNumberError returns why a normalised VAT number is invalid, or "" when it is valid.
+// Italian partite IVA (with or without the IT prefix) are checked digit by digit, other
+// VAT numbers need their two-letter country prefix.
+func vatNumberError(vatNumber string) string {
+	if italianVATPattern.MatchString(vatNumber) {
+		if !validPartitaIVA(strings.TrimPrefix(vatNumber, "IT")) {
+			return fmt.Sprintf("vat_number %q is not a valid partita IVA, the check digit doesn't match", vatNumber)
+		}
+		return ""
+	}
+	if strings.HasPrefix(vatNumber, "IT") || !foreignVATPattern.MatchString(vatNumber) {
+		return fmt.Sprintf("vat_number must be an 11-digit partita IVA or a VAT number with its country prefix (e.g. DE123456789), got %q", vatNumber)
+	}
+	return ""
+}
+
+// validPartitaIVA checks the last digit of an 11-digit Italian VAT number (Luhn algorithm)
+func validPartitaIVA(digits string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		d := int(digits[i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return (10-sum%10)%10 == int(digits[10]-'0')
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ()./-]+$`)
+
+// validPhone accepts phone numbers as people write them, in international or national form
+func validPhone(phone string) bool {
+	if !phonePattern.MatchString(phone) {
+		return false
+	}
+	digits := 0
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits >= 6 && digits <= 15
+}
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validColor accepts the colours the dashboards can paint a card with: #rgb, #rrggbb or a CSS colour name
+func validColor(color string) bool {
+	return hexColorPattern.MatchString(color) || cssColorNames[strings.ToLower(color)]
+}
+
+// cssColorNames are the named colours of CSS Color Module Level 4
+var cssColorNames = func() map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Fields(`
+		aliceblue antiquewhite aqua aquamarine azure beige bisque black blanchedalmond blue blueviolet brown
+		burlywood cadetblue chartreuse chocolate coral cornflowerblue cornsilk crimson cyan darkblue darkcyan
+		darkgoldenrod darkgray darkgreen darkgrey darkkhaki darkmagenta darkolivegreen darkorange darkorchid
+		darkred darksalmon darkseagreen darkslateblue darkslategray darkslategrey darkturquoise darkviolet
+		deeppink deepskyblue dimgray dimgrey dodgerblue firebrick floralwhite forestgreen fuchsia gainsboro
+		ghostwhite gold goldenrod gray green greenyellow grey honeydew hotpink indianred indigo ivory khaki
+		lavender lavenderblush lawngreen lemonchiffon lightblue lightcoral lightcyan lightgoldenrodyellow
+		lightgray lightgreen lightgrey lightpink lightsalmon lightseagreen lightskyblue lightslategray
+		lightslategrey lightsteelblue lightyellow lime limegreen linen magenta maroon mediumaquamarine
+		mediumblue mediumorchid mediumpurple mediumseagreen mediumslateblue mediumspringgreen mediumturquoise
+		mediumvioletred midnightblue mintcream mistyrose moccasin navajowhite navy oldlace olive olivedrab
+		orange orangered orchid palegoldenrod palegreen paleturquoise palevioletred papayawhip peachpuff peru
+		pink plum powderblue purple rebeccapurple red rosybrown royalblue saddlebrown salmon sandybrown
+		seagreen seashell sienna silver skyblue slateblue slategray slategrey snow springgreen steelblue tan
+		teal thistle tomato turquoise violet wheat white whitesmoke yellow yellowgreen`) {
+		names[name] = true
+	}
+	return names
+}()
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeVATNumber(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{" it 012.345.678-97 ", "IT01234567897"},
+		{"de 123 456 789", "DE123456789"},
+		{"01234567897", "01234567897"},
+	}
+	for _, tt := range tests {
+		if got := normalizeVATNumber(tt.in); got != tt.want {
+			t.Errorf("normalizeVATNumber(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVATNumberError(t *testing.T) {
+	tests := []struct {
+		vatNumber string
+		wantErr   string
+	}{
+		{"01234567897", ""},
+		{"IT01234567897", ""},
+		{"00000000000", ""},
+		{"01234567890", "the check digit doesn't match"},
+		{"IT01234567898", "the check digit doesn't match"},
+		{"0123456789", "must be an 11-digit partita IVA"},
+		{"012345678977", "must be an 11-digit partita IVA"},
+		{"IT0123456789A", "must be an 11-digit partita IVA"},
+		{"IT", "must be an 11-digit partita IVA"},
+		{"DE123456789", ""},
+		{"FRXX123456789", ""},
+		{"NL123456789B01", ""},
+		{"D123456789", "must be an 11-digit partita IVA"},
+		{"DE1", "must be an 11-digit partita IVA"},
+		{"DE1234567890123456", "must be an 11-digit partita IVA"},
+		{"de123456789", "must be an 11-digit partita IVA"},
+	}
+	for _, tt := range tests {
+		got := vatNumberError(tt.vatNumber)
+		if tt.wantErr == "" && got != "" {
+			t.Errorf("vatNumberError(%q) = %q, want it valid", tt.vatNumber, got)
+		}
+		if tt.wantErr != "" && !strings.Contains(got, tt.wantErr) {
+			t.Errorf("vatNumberError(%q) = %q, want an error containing %q", tt.vatNumber, got, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"electronic_kanban_backend/models"
+	"electronic_kanban_backend/utils"
+
+	"github.com/lib/pq"
+)
+
+//go:embed templates/wallboard.html
+var wallboardTemplates embed.FS
+
+// wallboardPage renders the wallboard for the shop-floor TVs
+var wallboardPage = template.Must(template.New("wallboard.html").
+	Funcs(template.FuncMap{"textColor": textColor}).
+	ParseFS(wallboardTemplates, "templates/wallboard.html"))
+
+// wallboardQueryParams documents the parameters of the wallboard routes
+var wallboardQueryParams = []apiParam{
+	{"token", "string", "Wallboard token, unless sent as a bearer token"},
+	{"customer_id", "integer", "Only the kanban chains of this customer, repeatable"},
+	{"supplier_id", "integer", "Only the kanban chains of this supplier, repeatable"},
+	{"customer_site_id", "integer", "Only the kanban chains shipping to this customer site, repeatable"},
+	{"supplier_site_id", "integer", "Only the kanban chains shipping from this supplier site, repeatable"},
+	{"customer_work_centre_id", "integer", "Only the kanban chains of this internal customer, repeatable; combines with customer_id"},
+	{"supplier_work_centre_id", "integer", "Only the kanban chains of this internal supplier, repeatable; combines with supplier_id"},
+}
+
+// WallboardAuth lets through the requests carrying one of the tokens of their tenant, in the token query parameter
+// (so a TV can open a bookmarked URL without logging in) or in an "Authorization: Bearer" header. tokens are
+// written tenant:token, or without the tenant for defaultTenant (see utils.WallboardToken).
+func WallboardAuth(tokens []string, defaultTenant string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		for _, entry := range tokens {
+			tenant, valid := utils.WallboardToken(entry, defaultTenant)
+			if tenant == tenantFromRequest(r).Slug && subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+				next(w, r)
+				return
+			}
+		}
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "A valid wallboard token is required")
+	}
+}
+
+// WallboardHandler returns a handler for GET /api/wallboard, the wallboard as JSON
+func WallboardHandler(db *sql.DB, cycle time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wallboard, ok := loadWallboard(w, r, db, cycle)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wallboard)
+	}
+}
+
+// WallboardPageHandler returns a handler for GET /wallboard, the wallboard as a page for large screens. It shows
+// one kanban chain at a time and reloads its content when the push channel announces a change.
+func WallboardPageHandler(db *sql.DB, cycle time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wallboard, ok := loadWallboard(w, r, db, cycle)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer") // The URL carries the token
+		if err := wallboardPage.Execute(w, wallboard); err != nil {
+			requestLogger(r).Error("rendering the wallboard failed", "error", err)
+		}
+	}
+}
+
+// WallboardEventsHandler returns a handler for GET /api/wallboard/events, the push channel of the wallboard
+// as Server-Sent Events
+func WallboardEventsHandler(hub *ChangeHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamChanges(w, r, hub)
+	}
+}
+
+// loadWallboard reads the wallboard filtered by the request. It writes the error response and returns false
+// when it can't.
+func loadWallboard(w http.ResponseWriter, r *http.Request, db *sql.DB, cycle time.Duration) (*models.WallboardResponse, bool) {
+	var filters [6][]int64 // Customers, suppliers, customer sites, supplier sites, customer and supplier work centres
+	for i, name := range []string{
+		"customer_id", "supplier_id", "customer_site_id", "supplier_site_id", "customer_work_centre_id", "supplier_work_centre_id",
+	} {
+		ids, err := parseIDSet(r.URL.Query(), name)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return nil, false
+		}
+		filters[i] = idList(ids)
+	}
+
+	chains, err := getWallboardChains(r.Context(), db, filters[0], filters[1], filters[2], filters[3], filters[4], filters[5])
+	if err != nil {
+		writeDBError(w, r, err, "Failed to fetch the wallboard")
+		return nil, false
+	}
+	return &models.WallboardResponse{GeneratedAt: time.Now(), CycleSeconds: int(cycle.Seconds()), Chains: chains}, true
+}
+
+// idList returns the IDs of set in ascending order, empty when set is nil
+func idList(set map[int64]bool) []int64 {
+	ids := []int64{}
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// textColor picks black or white text, whichever reads better on background, a CSS color. Colors other than
+// hex codes get white text.
+func textColor(background string) string {
+	hex := strings.TrimPrefix(background, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return "#ffffff"
+	}
+	r, g, b := float64(rgb>>16&0xff), float64(rgb>>8&0xff), float64(rgb&0xff)
+	if 0.299*r+0.587*g+0.114*b > 150 {
+		return "#000000"
+	}
+	return "#ffffff"
+}
+
+// Database interaction functions (private)
+
+// getWallboardChains reads the active cards of the kanban chains that are not archived, by product, customer and
+// supplier, the cards of each chain in the order of its status chain. Empty customer, supplier, site or work
+// centre lists don't filter; the account and work centre lists of a side add up.
+func getWallboardChains(ctx context.Context, db dbtx, customerIDs, supplierIDs, customerSiteIDs, supplierSiteIDs, customerWorkCentreIDs, supplierWorkCentreIDs []int64) ([]models.WallboardChain, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			kc.id, kc.prodotto_codice, p.name,
+			COALESCE(kc.cliente_id, 0), kc.customer_work_centre_id, COALESCE(ac.name, cw.name),
+			COALESCE(kc.fornitore_id, 0), kc.supplier_work_centre_id, COALESCE(af.name, sw.name), kc.kanban_type,
+			kc.customer_site_id, COALESCE(cs.name, ''), kc.supplier_site_id, COALESCE(ss.name, ''),
+			k.id, k.status_current, s.name, s.color, k.data_aggiornamento,
+			k.data_aggiornamento + k.leadtime_days * INTERVAL '1 day' < NOW()
+		FROM kanbans k
+		JOIN kanban_chains kc ON k.kanban_chain_id = kc.id
+		JOIN products p ON kc.prodotto_codice = p.product_id
+		LEFT JOIN accounts ac ON kc.cliente_id = ac.id
+		LEFT JOIN accounts af ON kc.fornitore_id = af.id
+		LEFT JOIN work_centres cw ON kc.customer_work_centre_id = cw.id
+		LEFT JOIN work_centres sw ON kc.supplier_work_centre_id = sw.id
+		LEFT JOIN sites cs ON kc.customer_site_id = cs.id
+		LEFT JOIN sites ss ON kc.supplier_site_id = ss.id
+		JOIN statuses s ON k.status_current = s.status_id
+		JOIN status_chains_statuses scs ON k.status_chain_id = scs.status_chain_id AND k.status_current = scs.status_id
+		WHERE k.is_active = true AND kc.archived_at IS NULL
+			AND (cardinality($1::bigint[]) + cardinality($5::bigint[]) = 0
+				OR kc.cliente_id = ANY($1) OR kc.customer_work_centre_id = ANY($5))
+			AND (cardinality($2::bigint[]) + cardinality($6::bigint[]) = 0
+				OR kc.fornitore_id = ANY($2) OR kc.supplier_work_centre_id = ANY($6))
+			AND (cardinality($3::bigint[]) = 0 OR kc.customer_site_id = ANY($3))
+			AND (cardinality($4::bigint[]) = 0 OR kc.supplier_site_id = ANY($4))
+		ORDER BY p.name, COALESCE(ac.name, cw.name), COALESCE(af.name, sw.name), kc.id, scs."order", k.id`,
+		pq.Array(customerIDs), pq.Array(supplierIDs), pq.Array(customerSiteIDs), pq.Array(supplierSiteIDs),
+		pq.Array(customerWorkCentreIDs), pq.Array(supplierWorkCentreIDs))
+	if err != nil {
+		return nil, fmt.Errorf("error querying the wallboard: %w", err)
+	}
+	defer rows.Close()
+
+	chains := []models.WallboardChain{}
+	for rows.Next() {
+		var chain models.WallboardChain
+		var card models.WallboardCard
+		if err := rows.Scan(
+			&chain.KanbanChainID, &chain.ProductID, &chain.ProductName,
+			&chain.CustomerID, &chain.CustomerWorkCentreID, &chain.CustomerName,
+			&chain.SupplierID, &chain.SupplierWorkCentreID, &chain.SupplierName, &chain.KanbanType,
+			&chain.CustomerSiteID, &chain.CustomerSiteName, &chain.SupplierSiteID, &chain.SupplierSiteName,
+			&card.KanbanID, &card.StatusID, &card.StatusName, &card.StatusColor, &card.UpdatedAt, &card.Overdue,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning the wallboard: %w", err)
+		}
+		if len(chains) == 0 || chains[len(chains)-1].KanbanChainID != chain.KanbanChainID {
+			chains = append(chains, chain)
+		}
+		c := &chains[len(chains)-1]
+		c.Kanbans = append(c.Kanbans, card)
+		c.Cards++
+		if card.Overdue {
+			c.Overdue++
+		}
+		if n := len(c.Statuses); n == 0 || c.Statuses[n-1].StatusID != card.StatusID {
+			c.Statuses = append(c.Statuses, models.WallboardStatusCount{StatusID: card.StatusID, Name: card.StatusName, Color: card.StatusColor})
+		}
+		c.Statuses[len(c.Statuses)-1].Count++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating the wallboard: %w", err)
+	}
+	return chains, nil
+}
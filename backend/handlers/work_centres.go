@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"electronic_kanban_backend/models"
+
+	"github.com/gorilla/mux"
+)
+
+// GetWorkCentresHandler returns a handler for GET /api/work-centres
+func GetWorkCentresHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		lq, err := parseListQuery(r, workCentreListSpec)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		workCentres, err := getWorkCentres(ctx, db, lq)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch work centres")
+			return
+		}
+		total, err := countRows(ctx, db, workCentresFrom, lq, workCentreListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch work centres")
+			return
+		}
+		cursor, err := nextCursor(ctx, db, workCentresFrom, lq, workCentreListSpec)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch work centres")
+			return
+		}
+		lq.writeListHeaders(w, r, total, cursor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(workCentres)
+	}
+}
+
+// CreateWorkCentreHandler returns a handler for POST /api/work-centres
+func CreateWorkCentreHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var workCentre models.WorkCentre
+		if err := json.NewDecoder(r.Body).Decode(&workCentre); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateWorkCentre(&workCentre); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
+			return
+		}
+
+		var newWorkCentre *models.WorkCentre
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if newWorkCentre, err = createWorkCentre(ctx, tx, workCentre); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditCreate, "work_centre", newWorkCentre.ID, nil, newWorkCentre)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to create work centre")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newWorkCentre)
+	}
+}
+
+// GetWorkCentreHandler returns a handler for GET /api/work-centres/{id}
+func GetWorkCentreHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid work centre ID")
+			return
+		}
+
+		workCentre, err := getWorkCentreByID(r.Context(), db, id)
+		if err != nil {
+			writeDBError(w, r, err, "Failed to fetch work centre")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(workCentre)
+	}
+}
+
+// UpdateWorkCentreHandler returns a handler for PUT/PATCH /api/work-centres/{id}
+func UpdateWorkCentreHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid work centre ID")
+			return
+		}
+
+		var workCentreUpdates models.WorkCentre
+		if err := json.NewDecoder(r.Body).Decode(&workCentreUpdates); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid request body")
+			return
+		}
+		if fieldErrs := validateWorkCentre(&workCentreUpdates); len(fieldErrs) > 0 {
+			writeValidationError(w, r, fieldErrs)
+			return
+		}
+		workCentreUpdates.ID = id // Ensure ID from URL is used
+
+		var updatedWorkCentre *models.WorkCentre
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousWorkCentre, err := getWorkCentreByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if updatedWorkCentre, err = updateWorkCentre(ctx, tx, workCentreUpdates); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditUpdate, "work_centre", id, previousWorkCentre, updatedWorkCentre)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to update work centre")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updatedWorkCentre)
+	}
+}
+
+// DeleteWorkCentreHandler returns a handler for DELETE /api/work-centres/{id}, which archives the work centre
+func DeleteWorkCentreHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid work centre ID")
+			return
+		}
+
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousWorkCentre, err := getWorkCentreByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := archiveWorkCentre(ctx, tx, id); err != nil {
+				return err
+			}
+			archivedWorkCentre, err := getWorkCentreByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditArchive, "work_centre", id, previousWorkCentre, archivedWorkCentre)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to archive work centre")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.MessageResponse{Message: "Work centre archived"})
+	}
+}
+
+// RestoreWorkCentreHandler returns a handler for POST /api/work-centres/{id}/restore
+func RestoreWorkCentreHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid work centre ID")
+			return
+		}
+
+		var workCentre *models.WorkCentre
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			previousWorkCentre, err := getWorkCentreByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := restoreRow(ctx, tx, "work_centres", "id", id); err != nil {
+				return err
+			}
+			if workCentre, err = getWorkCentreByID(ctx, tx, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, auditRestore, "work_centre", id, previousWorkCentre, workCentre)
+		})
+		if err != nil {
+			writeDBError(w, r, err, "Failed to restore work centre")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(workCentre)
+	}
+}
+
+// Database interaction functions (private)
+
+// workCentreListSpec defines what GET /api/work-centres can sort, filter and search on
+var workCentreListSpec = listSpec{
+	Fields: map[string]listField{
+		"id":   {Column: "id", Kind: fieldInt},
+		"code": {Column: "code", Kind: fieldText},
+		"name": {Column: "name", Kind: fieldText},
+	},
+	Search:      []string{"code", "name"},
+	DefaultSort: "code",
+	TieBreaker:  "id",
+	Archived:    "archived_at",
+}
+
+const workCentresFrom = `FROM work_centres WHERE TRUE`
+
+func getWorkCentres(ctx context.Context, db dbtx, lq *listQuery) ([]models.WorkCentre, error) {
+	var args []interface{}
+	query := "SELECT id, code, name, archived_at " + workCentresFrom +
+		lq.where(workCentreListSpec, &args) + lq.after(workCentreListSpec, &args) + lq.orderBy(workCentreListSpec) + lq.page()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workCentres := []models.WorkCentre{}
+	for rows.Next() {
+		var wc models.WorkCentre
+		if err := rows.Scan(&wc.ID, &wc.Code, &wc.Name, &wc.ArchivedAt); err != nil {
+			return nil, err
+		}
+		workCentres = append(workCentres, wc)
+	}
+	return workCentres, rows.Err()
+}
+
+func createWorkCentre(ctx context.Context, db dbtx, workCentre models.WorkCentre) (*models.WorkCentre, error) {
+	var wc models.WorkCentre
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO work_centres (code, name)
+		VALUES ($1, $2)
+		RETURNING id, code, name, archived_at`, workCentre.Code, workCentre.Name).Scan(&wc.ID, &wc.Code, &wc.Name, &wc.ArchivedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &wc, nil
+}
+
+func getWorkCentreByID(ctx context.Context, db dbtx, id int64) (*models.WorkCentre, error) {
+	var wc models.WorkCentre
+	err := db.QueryRowContext(ctx, `SELECT id, code, name, archived_at FROM work_centres WHERE id = $1`, id).Scan(
+		&wc.ID, &wc.Code, &wc.Name, &wc.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &wc, nil
+}
+
+func updateWorkCentre(ctx context.Context, db dbtx, workCentre models.WorkCentre) (*models.WorkCentre, error) {
+	var wc models.WorkCentre
+	err := db.QueryRowContext(ctx, `
+		UPDATE work_centres
+		SET code = $2, name = $3
+		WHERE id = $1
+		RETURNING id, code, name, archived_at`, workCentre.ID, workCentre.Code, workCentre.Name).Scan(&wc.ID, &wc.Code, &wc.Name, &wc.ArchivedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &wc, nil
+}
+
+// archiveWorkCentre archives a work centre, refusing while kanban chains that are not archived start or end at it.
+// Run it in a transaction, which a refusal must roll back.
+func archiveWorkCentre(ctx context.Context, tx dbtx, id int64) error {
+	if err := archiveRow(ctx, tx, "work_centres", "id", id); err != nil {
+		return err
+	}
+	return refuseIfAny(ctx, tx, "The work centre is used by %d kanban chains that are not archived",
+		`SELECT COUNT(*) FROM kanban_chains WHERE (customer_work_centre_id = $1 OR supplier_work_centre_id = $1) AND archived_at IS NULL`, id)
+}
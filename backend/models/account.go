@@ -1,9 +1,12 @@
 package models
 
+import "time"
+
 // Account model for accounts table
 type Account struct {
-	ID        int64  `json:"id"`
-	Name      string `json:"name"`
-	VATNumber string `json:"vat_number"`
-	Address   string `json:"address"`
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	VATNumber  string     `json:"vat_number"`
+	Address    string     `json:"address"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"` // Set while the account is archived
 }
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// AuditEntry model for audit_log table: one write operation through the API. Each entry carries the hash
+// of the previous one, so editing or removing a past entry breaks the chain from that point on.
+type AuditEntry struct {
+	ID         int64                  `json:"id"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Actor      string                 `json:"actor"` // X-Actor header of the request, "anonymous" without one
+	RequestID  string                 `json:"request_id"`
+	Action     string                 `json:"action"` // create, update, archive, restore, delete, move or import
+	Entity     string                 `json:"entity"` // account, product, status, status_chain, kanban_chain, kanban or config
+	EntityID   string                 `json:"entity_id"`
+	Before     interface{}            `json:"before"` // The record before the operation, null on create
+	After      interface{}            `json:"after"`  // The record after the operation
+	Changes    map[string]AuditChange `json:"changes"`
+	PrevHash   string                 `json:"prev_hash"`
+	Hash       string                 `json:"hash"`
+}
+
+// AuditChange is a field whose value differs between the before and after of an audit entry
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// AuditVerification is the result of checking the hash chain of the audit log
+type AuditVerification struct {
+	Valid      bool   `json:"valid"`
+	Checked    int64  `json:"checked"`                // Entries checked, from the first one
+	BrokenAtID *int64 `json:"broken_at_id,omitempty"` // First entry whose hash doesn't match, when not valid
+	Message    string `json:"message"`
+}
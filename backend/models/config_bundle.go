@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// ConfigBundleFormatVersion is the bundle format written by the export and the newest one the import accepts.
+// Version 2 added the sites of accounts and kanban chains, version 3 the work centres and kanban types, version 4
+// the units of measure, weights, part numbers and packaging of products and the quantity units of kanban chains.
+const ConfigBundleFormatVersion = 4
+
+// ConfigBundle is the complete plant configuration, exported from one environment and imported in another.
+// Entities reference each other by natural keys (status name, status chain name, account ref, work centre code,
+// product_id) instead of serial ids, which differ between databases.
+type ConfigBundle struct {
+	FormatVersion int                 `json:"format_version"`
+	ExportedAt    time.Time           `json:"exported_at"`
+	Statuses      []BundleStatus      `json:"statuses"`
+	StatusChains  []BundleStatusChain `json:"status_chains"`
+	Accounts      []BundleAccount     `json:"accounts"`
+	WorkCentres   []BundleWorkCentre  `json:"work_centres"`
+	Products      []Product           `json:"products"`
+	KanbanChains  []BundleKanbanChain `json:"kanban_chains"`
+}
+
+// BundleStatus is a status, referenced by its name
+type BundleStatus struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// BundleStatusChain is a status chain with its ordered statuses, referenced by its name
+type BundleStatusChain struct {
+	Name     string                    `json:"name"`
+	Statuses []BundleStatusChainStatus `json:"statuses"`
+}
+
+// BundleStatusChainStatus links a status (by name) into a status chain
+type BundleStatusChainStatus struct {
+	Status           string `json:"status"`
+	Order            int64  `json:"order"`
+	CustomerSupplier int    `json:"customer_supplier"` // 1=Supplier, 2=Customer
+	Stage            string `json:"stage,omitempty"`   // Set explicitly, empty when derived from the order
+}
+
+// BundleAccount is an account, referenced by Ref (its VAT number, or "name:<name>" when it has none)
+type BundleAccount struct {
+	Ref       string       `json:"ref"`
+	Name      string       `json:"name"`
+	VATNumber string       `json:"vat_number"`
+	Address   string       `json:"address"`
+	Sites     []BundleSite `json:"sites,omitempty"`
+}
+
+// BundleSite is a site of an account, referenced by its name within the account
+type BundleSite struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// BundleWorkCentre is a work centre, referenced by its code
+type BundleWorkCentre struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// BundleKanbanChain is a kanban chain, identified by its customer, product, supplier and sites. Each end is
+// either an account or a work centre.
+type BundleKanbanChain struct {
+	Customer           string  `json:"customer,omitempty"`             // BundleAccount.Ref
+	CustomerWorkCentre string  `json:"customer_work_centre,omitempty"` // BundleWorkCentre.Code, set instead of customer
+	CustomerSite       string  `json:"customer_site,omitempty"`        // BundleSite.Name of the customer, empty for no site
+	ProductID          string  `json:"product_id"`
+	Supplier           string  `json:"supplier,omitempty"`             // BundleAccount.Ref
+	SupplierWorkCentre string  `json:"supplier_work_centre,omitempty"` // BundleWorkCentre.Code, set instead of supplier
+	SupplierSite       string  `json:"supplier_site,omitempty"`        // BundleSite.Name of the supplier, empty for no site
+	KanbanType         string  `json:"kanban_type,omitempty"`          // withdrawal when empty
+	StatusChain        string  `json:"status_chain"`                   // BundleStatusChain.Name
+	LeadtimeDays       int64   `json:"leadtime_days"`
+	Quantity           float64 `json:"quantity"`
+	QuantityUnit       string  `json:"quantity_unit,omitempty"` // The product's unit of measure when empty
+	TipoContenitore    string  `json:"tipo_contenitore"`
+	NoOfActiveKanbans  int64   `json:"no_of_active_kanbans"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// Contact model for contacts table: a person of an account that alerts and orders go to
+type Contact struct {
+	ID            int64                `json:"id"`
+	AccountID     int64                `json:"account_id"`
+	Name          string               `json:"name"`
+	Email         string               `json:"email"`
+	Phone         string               `json:"phone"`
+	Role          string               `json:"role"` // planner, logistics or quality
+	Notifications ContactNotifications `json:"notifications"`
+}
+
+// ContactNotifications are the notification preferences of a contact: which events reach them, and how
+type ContactNotifications struct {
+	Channel string   `json:"channel"` // none, email or sms
+	Events  []string `json:"events"`  // order, overdue or stock_out_risk
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DashboardView model for dashboard_views table: a named set of dashboard query parameters saved by a user
+type DashboardView struct {
+	ID        int64     `json:"id"`
+	Owner     string    `json:"owner"`     // X-Actor header of the user who saved it
+	Dashboard string    `json:"dashboard"` // supplier or customer
+	Name      string    `json:"name"`
+	Query     string    `json:"query"` // Dashboard query parameters, URL-encoded, like "status_id=3&group_by=customer"
+	UpdatedAt time.Time `json:"updated_at"`
+}
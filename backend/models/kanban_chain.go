@@ -1,14 +1,43 @@
 package models
 
+import "time"
+
 // KanbanChain model for kanban_chains table
 type KanbanChain struct {
-	ID                int64   `json:"id"`
-	ClienteID         int64   `json:"cliente_id"`
-	ProdottoCodice    string  `json:"prodotto_codice"`
-	FornitoreID       int64   `json:"fornitore_id"`
-	LeadtimeDays      int64   `json:"leadtime_days"`
-	Quantity          float64 `json:"quantity"`
-	TipoContenitore   string  `json:"tipo_contenitore"`
-	StatusChainID     int64   `json:"status_chain_id"`
-	NoOfActiveKanbans int64   `json:"no_of_active_kanbans"`
+	ID                   int64      `json:"id"`
+	ClienteID            int64      `json:"cliente_id"` // 0 when the customer is a work centre
+	ProdottoCodice       string     `json:"prodotto_codice"`
+	FornitoreID          int64      `json:"fornitore_id"`            // 0 when the supplier is a work centre
+	CustomerSiteID       *int64     `json:"customer_site_id"`        // Ship-to site of the customer, if any
+	SupplierSiteID       *int64     `json:"supplier_site_id"`        // Ship-from site of the supplier, if any
+	CustomerWorkCentreID *int64     `json:"customer_work_centre_id"` // Set instead of cliente_id when the customer is internal
+	SupplierWorkCentreID *int64     `json:"supplier_work_centre_id"` // Set instead of fornitore_id when the supplier is internal
+	KanbanType           string     `json:"kanban_type"`             // withdrawal (default) or production
+	LeadtimeDays         int64      `json:"leadtime_days"`
+	Quantity             float64    `json:"quantity"`
+	QuantityUnit         string     `json:"quantity_unit"` // Unit of the quantity, the product's unit of measure by default
+	TipoContenitore      string     `json:"tipo_contenitore"`
+	StatusChainID        int64      `json:"status_chain_id"`
+	NoOfActiveKanbans    int64      `json:"no_of_active_kanbans"`
+	ArchivedAt           *time.Time `json:"archived_at,omitempty"` // Set while the kanban chain is archived
+}
+
+// KanbanChainChange model for kanban_chain_changes table: an edit of a kanban chain's card settings,
+// and how it was propagated to the chain's existing kanbans
+type KanbanChainChange struct {
+	ID                 int64     `json:"id"`
+	KanbanChainID      int64     `json:"kanban_chain_id"`
+	ChangedAt          time.Time `json:"changed_at"`
+	Propagation        string    `json:"propagation"` // immediate, on_return or none
+	OldLeadtimeDays    int64     `json:"old_leadtime_days"`
+	NewLeadtimeDays    int64     `json:"new_leadtime_days"`
+	OldQuantity        float64   `json:"old_quantity"`
+	NewQuantity        float64   `json:"new_quantity"`
+	OldTipoContenitore string    `json:"old_tipo_contenitore"`
+	NewTipoContenitore string    `json:"new_tipo_contenitore"`
+	OldStatusChainID   int64     `json:"old_status_chain_id"`
+	NewStatusChainID   int64     `json:"new_status_chain_id"`
+	CardsUpdated       int64     `json:"cards_updated"` // Cards that have taken the new values so far
+	CardsPending       int64     `json:"cards_pending"` // Cards that will take them when they return to the first status
+	RequestID          string    `json:"request_id"`
 }
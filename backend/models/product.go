@@ -1,7 +1,17 @@
 package models
 
+import "time"
+
 // Product model for products table
 type Product struct {
-	ProductID string `json:"product_id"`
-	Name      string `json:"name"`
+	ProductID            string     `json:"product_id"`
+	Name                 string     `json:"name"`
+	UnitOfMeasure        string     `json:"unit_of_measure"`        // pcs (default), kg, g, t, lb, m or l
+	NetWeightKg          *float64   `json:"net_weight_kg"`          // Weight of one unit of measure, without packaging
+	GrossWeightKg        *float64   `json:"gross_weight_kg"`        // Weight of one unit of measure, with packaging
+	CustomerPartNumber   string     `json:"customer_part_number"`   // The customer's code for the product, for their documents
+	SupplierPartNumber   string     `json:"supplier_part_number"`   // The supplier's code for the product, for their documents
+	DefaultContainerType string     `json:"default_container_type"` // Container of new kanban chains of the product
+	UnitsPerContainer    *float64   `json:"units_per_container"`    // Quantity of new kanban chains of the product, in its unit of measure
+	ArchivedAt           *time.Time `json:"archived_at,omitempty"`  // Set while the product is archived
 }
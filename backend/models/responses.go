@@ -0,0 +1,262 @@
+package models
+
+import "time"
+
+// Response models returned by the API.
+//
+// Fields named after Italian DB columns are kept for existing clients, and each one is paired
+// with its English name: data_aggiornamento/updated_at, tipo_contenitore/container_type,
+// cliente_id/customer_id, fornitore_id/supplier_id, prodotto_codice/product_id.
+// New clients should read the English names.
+
+// KanbanResponse is a kanban card as returned by the kanban endpoints
+type KanbanResponse struct {
+	ID                int64     `json:"id"`
+	DataAggiornamento time.Time `json:"data_aggiornamento"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	LeadtimeDays      int64     `json:"leadtime_days"`
+	IsActive          bool      `json:"is_active"`
+	KanbanChainID     int64     `json:"kanban_chain_id"`
+	StatusChainID     int64     `json:"status_chain_id"`
+	StatusCurrent     int64     `json:"status_current"`
+	TipoContenitore   string    `json:"tipo_contenitore"`
+	ContainerType     string    `json:"container_type"`
+	Quantity          float64   `json:"quantity"`
+}
+
+// NewKanbanResponse builds the response model of a kanban
+func NewKanbanResponse(k Kanban) KanbanResponse {
+	return KanbanResponse{
+		ID:                k.ID,
+		DataAggiornamento: k.DataAggiornamento,
+		UpdatedAt:         k.DataAggiornamento,
+		LeadtimeDays:      k.LeadtimeDays,
+		IsActive:          k.IsActive,
+		KanbanChainID:     k.KanbanChainID,
+		StatusChainID:     k.StatusChainID,
+		StatusCurrent:     k.StatusCurrent,
+		TipoContenitore:   k.TipoContenitore,
+		ContainerType:     k.TipoContenitore,
+		Quantity:          k.Quantity,
+	}
+}
+
+// KanbanListItem is a row of GET /api/kanbans
+type KanbanListItem struct {
+	KanbanResponse
+	ProductID    string `json:"product_id"`
+	ProductName  string `json:"product_name"`
+	QuantityUnit string `json:"quantity_unit"` // Of the quantity, as set on the kanban chain
+}
+
+// RetiredKanbanListItem is a row of GET /api/kanbans/retired
+type RetiredKanbanListItem struct {
+	KanbanListItem
+	RetiredAt           time.Time `json:"retired_at"`
+	KanbanChainArchived bool      `json:"kanban_chain_archived"` // The chain must be restored before the card can be reactivated
+}
+
+// KanbanChainResponse is a kanban chain as returned by the kanban chain endpoints
+type KanbanChainResponse struct {
+	ID                   int64      `json:"id"`
+	ClienteID            int64      `json:"cliente_id"`
+	CustomerID           int64      `json:"customer_id"`
+	ProdottoCodice       string     `json:"prodotto_codice"`
+	ProductID            string     `json:"product_id"`
+	FornitoreID          int64      `json:"fornitore_id"`
+	SupplierID           int64      `json:"supplier_id"`
+	CustomerSiteID       *int64     `json:"customer_site_id"`
+	SupplierSiteID       *int64     `json:"supplier_site_id"`
+	CustomerWorkCentreID *int64     `json:"customer_work_centre_id"`
+	SupplierWorkCentreID *int64     `json:"supplier_work_centre_id"`
+	KanbanType           string     `json:"kanban_type"`
+	LeadtimeDays         int64      `json:"leadtime_days"`
+	Quantity             float64    `json:"quantity"`
+	QuantityUnit         string     `json:"quantity_unit"`
+	TipoContenitore      string     `json:"tipo_contenitore"`
+	ContainerType        string     `json:"container_type"`
+	StatusChainID        int64      `json:"status_chain_id"`
+	NoOfActiveKanbans    int64      `json:"no_of_active_kanbans"`
+	ArchivedAt           *time.Time `json:"archived_at,omitempty"`
+}
+
+// NewKanbanChainResponse builds the response model of a kanban chain
+func NewKanbanChainResponse(kc KanbanChain) KanbanChainResponse {
+	return KanbanChainResponse{
+		ID:                   kc.ID,
+		ClienteID:            kc.ClienteID,
+		CustomerID:           kc.ClienteID,
+		ProdottoCodice:       kc.ProdottoCodice,
+		ProductID:            kc.ProdottoCodice,
+		FornitoreID:          kc.FornitoreID,
+		SupplierID:           kc.FornitoreID,
+		CustomerSiteID:       kc.CustomerSiteID,
+		SupplierSiteID:       kc.SupplierSiteID,
+		CustomerWorkCentreID: kc.CustomerWorkCentreID,
+		SupplierWorkCentreID: kc.SupplierWorkCentreID,
+		KanbanType:           kc.KanbanType,
+		LeadtimeDays:         kc.LeadtimeDays,
+		Quantity:             kc.Quantity,
+		QuantityUnit:         kc.QuantityUnit,
+		TipoContenitore:      kc.TipoContenitore,
+		ContainerType:        kc.TipoContenitore,
+		StatusChainID:        kc.StatusChainID,
+		NoOfActiveKanbans:    kc.NoOfActiveKanbans,
+		ArchivedAt:           kc.ArchivedAt,
+	}
+}
+
+// KanbanChainListItem is a row of GET /api/kanban-chains
+type KanbanChainListItem struct {
+	KanbanChainResponse
+	CustomerName     string `json:"customer_name"`      // Name of the customer account or work centre
+	CustomerSiteName string `json:"customer_site_name"` // Empty when the chain has no customer site
+	ProductName      string `json:"product_name"`
+	SupplierName     string `json:"supplier_name"`      // Name of the supplier account or work centre
+	SupplierSiteName string `json:"supplier_site_name"` // Empty when the chain has no supplier site
+}
+
+// StatusChainStatusItem is a status of a status chain, with the status details joined in
+type StatusChainStatusItem struct {
+	StatusID         int64  `json:"status_id"`
+	StatusName       string `json:"status_name"`
+	StatusColor      string `json:"status_color"`
+	Order            int64  `json:"order"`
+	CustomerSupplier int    `json:"customer_supplier"` // 1=Supplier, 2=Customer
+	Stage            string `json:"stage"`             // Stage of the kanban loop, as set or derived from the order
+}
+
+// DashboardKanban is a kanban card on the supplier or customer dashboard
+type DashboardKanban struct {
+	KanbanID             int64     `json:"kanban_id"`
+	ID                   int64     `json:"id"`
+	ProdottoCodice       string    `json:"prodotto_codice"`
+	ProductID            string    `json:"product_id"`
+	ProductName          string    `json:"product_name"`
+	TipoContenitore      string    `json:"tipo_contenitore"`
+	ContainerType        string    `json:"container_type"`
+	Quantity             float64   `json:"quantity"`
+	QuantityUnit         string    `json:"quantity_unit"` // As asked by the unit parameter, or the product's unit of measure
+	StatusName           string    `json:"status_name"`
+	StatusColor          string    `json:"status_color"`
+	CustomerSupplier     int       `json:"customer_supplier"` // Owner of the current status: 1=Supplier, 2=Customer
+	StatusCurrent        int64     `json:"status_current"`
+	CustomerID           int64     `json:"customer_id,omitempty"`             // Set on the supplier dashboard when the customer is an account
+	CustomerWorkCentreID *int64    `json:"customer_work_centre_id,omitempty"` // Set on the supplier dashboard when the customer is internal
+	CustomerName         string    `json:"customer_name,omitempty"`           // Set on the supplier dashboard: the account or work centre
+	SupplierID           int64     `json:"supplier_id,omitempty"`             // Set on the customer dashboard when the supplier is an account
+	SupplierWorkCentreID *int64    `json:"supplier_work_centre_id,omitempty"` // Set on the customer dashboard when the supplier is internal
+	SupplierName         string    `json:"supplier_name,omitempty"`           // Set on the customer dashboard: the account or work centre
+	KanbanType           string    `json:"kanban_type"`                       // withdrawal or production
+	CustomerSiteID       *int64    `json:"customer_site_id"`                  // Ship-to site of the kanban chain, if any
+	CustomerSiteName     string    `json:"customer_site_name"`                // Empty when the chain has no customer site
+	SupplierSiteID       *int64    `json:"supplier_site_id"`                  // Ship-from site of the kanban chain, if any
+	SupplierSiteName     string    `json:"supplier_site_name"`                // Empty when the chain has no supplier site
+	Stage                string    `json:"stage"`                             // Stage of the kanban loop of the current status
+	UpdatedAt            time.Time `json:"updated_at"`                        // Last move of the card
+	StatusChainID        int64     `json:"status_chain_id"`
+	LeadtimeDays         int64     `json:"leadtime_days"`
+	Overdue              bool      `json:"overdue"` // Has not moved for longer than its lead time
+}
+
+// DashboardGroup is a group of cards of a dashboard, as chosen by its group_by parameter
+type DashboardGroup struct {
+	Key     string            `json:"key"`  // Product ID, account ID, work_centre:<id>, status ID or site ID
+	Name    string            `json:"name"` // Product, account, work centre, status or site name
+	Kanbans []DashboardKanban `json:"kanbans"`
+}
+
+// SupplierDashboardResponse is the body of GET /api/dashboards/supplier/{supplierId} and
+// GET /api/dashboards/work-centres/{workCentreId}/supplier
+type SupplierDashboardResponse struct {
+	SupplierID       int64                        `json:"supplier_id,omitempty"`
+	WorkCentreID     int64                        `json:"work_centre_id,omitempty"` // On the dashboard of a work centre
+	Summary          []SupplierDashboardSummary   `json:"summary"`                  // One row per product and customer, most urgent first
+	GroupBy          string                       `json:"group_by"`
+	Groups           []DashboardGroup             `json:"groups"`
+	KanbansByProduct map[string][]DashboardKanban `json:"kanbans_by_product"`
+}
+
+// SupplierDashboardSummary sums up the cards of a product for one customer on the supplier dashboard
+type SupplierDashboardSummary struct {
+	Priority             int        `json:"priority"` // 1 is the most urgent: the customer with the fewest full containers left
+	ProductID            string     `json:"product_id"`
+	ProductName          string     `json:"product_name"`
+	CustomerID           int64      `json:"customer_id,omitempty"`
+	CustomerWorkCentreID *int64     `json:"customer_work_centre_id,omitempty"` // Set instead of customer_id for an internal customer
+	CustomerName         string     `json:"customer_name"`
+	ToProduce            int        `json:"to_produce"`    // Cards waiting to be produced
+	InProduction         int        `json:"in_production"` // Cards being produced
+	InTransit            int        `json:"in_transit"`    // Cards shipped, not yet at the customer
+	AtCustomer           int        `json:"at_customer"`   // Full containers the customer has left
+	QuantityToShip       float64    `json:"quantity_to_ship"`
+	QuantityUnit         string     `json:"quantity_unit"`        // Of the quantity to ship
+	OldestWaitingSince   *time.Time `json:"oldest_waiting_since"` // When the oldest card to produce arrived, null when none waits
+	OldestWaitingHours   float64    `json:"oldest_waiting_hours"` // Age of that card
+}
+
+// CustomerDashboardResponse is the body of GET /api/dashboards/customer/{customerId} and
+// GET /api/dashboards/work-centres/{workCentreId}/customer
+type CustomerDashboardResponse struct {
+	CustomerID       int64                        `json:"customer_id,omitempty"`
+	WorkCentreID     int64                        `json:"work_centre_id,omitempty"` // On the dashboard of a work centre
+	Coverage         []ProductCoverage            `json:"coverage"`                 // One row per product, most at risk first
+	GroupBy          string                       `json:"group_by"`
+	Groups           []DashboardGroup             `json:"groups"`
+	KanbansByProduct map[string][]DashboardKanban `json:"kanbans_by_product"`
+}
+
+// ProductCoverage is the stock a customer holds of a product and how long it will last
+type ProductCoverage struct {
+	ProductID        string           `json:"product_id"`
+	ProductName      string           `json:"product_name"`
+	FullContainers   int              `json:"full_containers"`   // Cards at the customer
+	QuantityOnHand   float64          `json:"quantity_on_hand"`  // Sum of their quantities
+	QuantityUnit     string           `json:"quantity_unit"`     // Of the quantities of the product, its consumption and its cards in transit
+	DailyConsumption *float64         `json:"daily_consumption"` // Quantity released per day over the measuring window, null when none was
+	CoverageDays     *float64         `json:"coverage_days"`     // QuantityOnHand / DailyConsumption, null without consumption
+	LeadtimeDays     int64            `json:"leadtime_days"`     // Longest lead time of the product's kanban chains
+	InTransit        []IncomingKanban `json:"in_transit"`        // Soonest arrival first
+	NextArrival      *time.Time       `json:"next_arrival"`      // Of the first card in transit, null when none is
+	Risk             string           `json:"risk"`              // red, amber or green, see the README
+}
+
+// IncomingKanban is a card in transit to the customer
+type IncomingKanban struct {
+	KanbanID             int64     `json:"kanban_id"`
+	SupplierID           int64     `json:"supplier_id,omitempty"`
+	SupplierWorkCentreID *int64    `json:"supplier_work_centre_id,omitempty"` // Set instead of supplier_id for an internal supplier
+	SupplierName         string    `json:"supplier_name"`
+	Quantity             float64   `json:"quantity"`
+	ExpectedArrival      time.Time `json:"expected_arrival"` // Release to the supplier plus the lead time
+}
+
+// MessageResponse is the body of endpoints that only confirm an action, such as deletes
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// HealthResponse is the body of the health and readiness probes
+type HealthResponse struct {
+	Status   string `json:"status"`             // ok, or unavailable when a dependency is down
+	Database string `json:"database,omitempty"` // Outcome of the database ping, on /readyz only
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error; Code is stable and meant for programs, Message for people
+type ErrorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id"` // Also sent in the X-Request-ID header and written to the server log
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// FieldError is a problem with a single field of the request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
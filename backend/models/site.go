@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Site model for sites table: a plant, warehouse or delivery point of an account, the ship-to site of a
+// customer or the ship-from site of a supplier
+type Site struct {
+	ID         int64      `json:"id"`
+	AccountID  int64      `json:"account_id"`
+	Name       string     `json:"name"`
+	Address    string     `json:"address"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"` // Set while the site is archived
+}
@@ -1,8 +1,11 @@
 package models
 
+import "time"
+
 // Status model for statuses table
 type Status struct {
-	StatusID int64  `json:"status_id"`
-	Name     string `json:"name"`
-	Color    string `json:"color"`
+	StatusID   int64      `json:"status_id"`
+	Name       string     `json:"name"`
+	Color      string     `json:"color"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"` // Set while the status is archived
 }
@@ -1,7 +1,10 @@
 package models
 
+import "time"
+
 // StatusChain model for status_chains table
 type StatusChain struct {
-	StatusChainID int64  `json:"status_chain_id"`
-	Name          string `json:"name"`
+	StatusChainID int64      `json:"status_chain_id"`
+	Name          string     `json:"name"`
+	ArchivedAt    *time.Time `json:"archived_at,omitempty"` // Set while the status chain is archived
 }
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// WallboardResponse is the body of GET /api/wallboard: the kanban chains shown on the shop-floor TVs
+type WallboardResponse struct {
+	GeneratedAt  time.Time        `json:"generated_at"`
+	CycleSeconds int              `json:"cycle_seconds"` // How long the wallboard page shows each chain
+	Chains       []WallboardChain `json:"chains"`
+}
+
+// WallboardChain is a kanban chain on the wallboard, with its active cards in the order of their status chain
+type WallboardChain struct {
+	KanbanChainID        int64                  `json:"kanban_chain_id"`
+	ProductID            string                 `json:"product_id"`
+	ProductName          string                 `json:"product_name"`
+	CustomerID           int64                  `json:"customer_id"` // 0 when the customer is a work centre
+	CustomerWorkCentreID *int64                 `json:"customer_work_centre_id"`
+	CustomerName         string                 `json:"customer_name"` // Name of the customer account or work centre
+	SupplierID           int64                  `json:"supplier_id"`   // 0 when the supplier is a work centre
+	SupplierWorkCentreID *int64                 `json:"supplier_work_centre_id"`
+	SupplierName         string                 `json:"supplier_name"` // Name of the supplier account or work centre
+	KanbanType           string                 `json:"kanban_type"`
+	CustomerSiteID       *int64                 `json:"customer_site_id"`
+	CustomerSiteName     string                 `json:"customer_site_name"` // Empty when the chain has no customer site
+	SupplierSiteID       *int64                 `json:"supplier_site_id"`
+	SupplierSiteName     string                 `json:"supplier_site_name"` // Empty when the chain has no supplier site
+	Cards                int                    `json:"cards"`
+	Overdue              int                    `json:"overdue"`  // Cards that have not moved for longer than their lead time
+	Statuses             []WallboardStatusCount `json:"statuses"` // Statuses holding cards, in the order of the status chain
+	Kanbans              []WallboardCard        `json:"kanbans"`
+}
+
+// WallboardStatusCount is the number of cards of a chain in one status
+type WallboardStatusCount struct {
+	StatusID int64  `json:"status_id"`
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Count    int    `json:"count"`
+}
+
+// WallboardCard is a card on the wallboard
+type WallboardCard struct {
+	KanbanID    int64     `json:"kanban_id"`
+	StatusID    int64     `json:"status_id"`
+	StatusName  string    `json:"status_name"`
+	StatusColor string    `json:"status_color"`
+	Overdue     bool      `json:"overdue"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ChangeEvent tells the clients of the push channel that a record changed, so they refresh what shows it
+type ChangeEvent struct {
+	Action   string `json:"action"` // An audit log action, or resync after the server missed events
+	Entity   string `json:"entity"`
+	EntityID string `json:"entity_id"`
+}
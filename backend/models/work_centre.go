@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// WorkCentre model for work_centres table: an internal production department, like the press shop or
+// assembly, that can be the customer or the supplier of a kanban chain instead of an account
+type WorkCentre struct {
+	ID         int64      `json:"id"`
+	Code       string     `json:"code"`
+	Name       string     `json:"name"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"` // Set while the work centre is archived
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"listening on :8080", "listening on :8080"},
+		{"http://example.com:8080/path", "http://example.com:8080/path"},
+		{"postgres://app:s3cret@db:5432/kanban?sslmode=disable", "postgres://app:[REDACTED]@db:5432/kanban?sslmode=disable"},
+		{"postgres://app:@db/kanban", "postgres://app:[REDACTED]@db/kanban"},
+		{"postgres://app@db/kanban", "postgres://app@db/kanban"},
+		{`dial "postgres://app:p:w@db/kanban": refused`, `dial "postgres://app:[REDACTED]@db/kanban": refused`},
+		{"host=db user=app password=s3cret dbname=kanban", "host=db user=app password=[REDACTED] dbname=kanban"},
+		{"host=db PASSWORD = s3cret", "host=db PASSWORD = [REDACTED]"},
+		{`host=db password='with \' quote and space' dbname=kanban`, "host=db password=[REDACTED] dbname=kanban"},
+	}
+	for _, tt := range tests {
+		if got := RedactSecrets(tt.in); got != tt.want {
+			t.Errorf("RedactSecrets(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewLoggerRedacts(t *testing.T) {
+	for _, format := range []string{LogFormatText, LogFormatJSON} {
+		var buf bytes.Buffer
+		logger, err := NewLogger(&buf, "debug", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info("connecting",
+			"dsn", "postgres://app:s3cret@db/kanban",
+			"db_password", "s3cret",
+			"error", errors.New("password=s3cret: authentication failed"),
+		)
+		if out := buf.String(); strings.Contains(out, "s3cret") || !strings.Contains(out, "[REDACTED]") {
+			t.Errorf("%s log has the password: %s", format, out)
+		}
+	}
+}
+
+func TestNewLoggerRejectsUnknownSettings(t *testing.T) {
+	if _, err := NewLogger(&bytes.Buffer{}, "verbose", ""); err == nil {
+		t.Error("unknown level accepted")
+	}
+	if _, err := NewLogger(&bytes.Buffer{}, "", "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+import "context"
+
+// Tenant is a company hosted by the server. The database only shows a query the rows of the tenant in its context.
+type Tenant struct {
+	ID   int64
+	Slug string
+}
+
+// tenantScope is the context value of WithTenant and WithAllTenants; the innermost one wins
+type tenantScope struct {
+	tenant Tenant
+	all    bool
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx whose queries see the rows of tenant only
+func WithTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantScope{tenant: tenant})
+}
+
+// WithAllTenants returns a copy of ctx whose queries see the rows of every tenant, for the migrations and the
+// server's own jobs. Never use it for a request.
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantScope{all: true})
+}
+
+// TenantFromContext returns the tenant set on ctx by WithTenant, and whether there is one
+func TenantFromContext(ctx context.Context) (Tenant, bool) {
+	scope, ok := ctx.Value(tenantKey{}).(tenantScope)
+	return scope.tenant, ok && !scope.all
+}
+
+// AllTenants reports whether ctx was made by WithAllTenants
+func AllTenants(ctx context.Context) bool {
+	scope, _ := ctx.Value(tenantKey{}).(tenantScope)
+	return scope.all
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Workers runs the background goroutines of the server, so shutdown can stop them and wait until
+// they are done before closing the database
+type Workers struct {
+	wg sync.WaitGroup
+}
+
+// Go runs fn in a goroutine. fn must return soon after ctx is cancelled.
+func (w *Workers) Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		slog.Debug("background worker started", "worker", name)
+		fn(ctx)
+		slog.Debug("background worker stopped", "worker", name)
+	}()
+}
+
+// Wait waits for every worker to return, for at most timeout, and reports whether they all did
+func (w *Workers) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
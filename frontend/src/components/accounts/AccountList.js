@@ -53,7 +53,7 @@ const AccountList = () => {
                             <td>{account.address}</td>
                             <td>
                               <Link to={`/accounts/${account.id}/edit`}>Edit</Link>
-                              <button onClick={() => handleDelete(account.id)}>Delete</button>
+                              <button onClick={() => handleDelete(account.id)}>Archive</button>
                             </td>
                         </tr>
                     ))}
@@ -62,7 +62,7 @@ const KanbanChainList = () => {
                     <td>{chain.no_of_active_kanbans}</td>
                     <td>
                        <Link to={`/kanban-chains/${chain.id}/edit`}>Edit</Link>
-                      <button onClick={() => handleDelete(chain.id)}>Delete</button>
+                      <button onClick={() => handleDelete(chain.id)}>Archive</button>
                    </td>
                   </tr>
                 ))}
@@ -2,8 +2,12 @@ import React, { useState, useEffect } from 'react';
 import { Link } from 'react-router-dom';
 import api from '../../services/api';
 
+const PAGE_SIZE = 50;
+
 const KanbanList = () => {
     const [kanbans, setKanbans] = useState([]);
+    const [totalKanbans, setTotalKanbans] = useState(0);
+    const [nextCursor, setNextCursor] = useState(null);
     const [products, setProducts] = useState([]);
     const [selectedProductIds, setSelectedProductIds] = useState([]);
 
@@ -12,13 +16,21 @@ const KanbanList = () => {
         fetchKanbans(); // Fetch all kanbans initially
     }, []);
 
-    const fetchKanbans = async () => {
+    // fetchKanbans loads the first page, or appends the page at cursor when given
+    const fetchKanbans = async (cursor = null) => {
         try {
             const params = new URLSearchParams();
             selectedProductIds.forEach(id => params.append("product_id", id)); // Append selected product IDs as query params
+            params.append("limit", PAGE_SIZE);
+            params.append("sort", "-id");
+            if (cursor) {
+                params.append("cursor", cursor);
+            }
 
             const response = await api.get('/kanbans?' + params.toString());
-            setKanbans(response.data); // Data is now an array of maps
+            setKanbans(previous => cursor ? [...previous, ...response.data] : response.data); // Data is now an array of maps
+            setTotalKanbans(parseInt(response.headers['x-total-count'], 10) || 0);
+            setNextCursor(response.headers['x-next-cursor'] || null);
         } catch (error) {
             console.error('Error fetching kanbans:', error);
         }
@@ -48,6 +60,7 @@ const KanbanList = () => {
             try {
                 await api.delete(`/kanbans/${id}`);
                 setKanbans(kanbans.filter(kanban => kanban.id !== id));
+                setTotalKanbans(total => total - 1);
             } catch (error) {
                 console.error('Error deleting kanban:', error);
             }
@@ -109,6 +122,8 @@ const KanbanList = () => {
                     ))}
                 </tbody>
             </table>
+            <p>Showing {kanbans.length} of {totalKanbans} kanbans</p>
+            {nextCursor && <button onClick={() => fetchKanbans(nextCursor)}>Load more</button>}
         </div>
     );
 };
@@ -48,7 +48,7 @@ const ProductList = () => {
                           <td>{product.name}</td>
                         <td>
                            <Link to={`/products/${product.product_id}/edit`}>Edit</Link>
-                           <button onClick={() => handleDelete(product.product_id)}>Delete</button>
+                           <button onClick={() => handleDelete(product.product_id)}>Archive</button>
                          </td>
                       </tr>
                   ))}
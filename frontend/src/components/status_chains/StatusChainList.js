@@ -49,7 +49,7 @@ const StatusChainList = () => {
                     <td>{chain.name}</td>
                     <td>
                         <Link to={`/status-chains/${chain.status_chain_id}/edit`}>Edit</Link>
-                        <button onClick={() => handleDelete(chain.status_chain_id)}>Delete</button>
+                        <button onClick={() => handleDelete(chain.status_chain_id)}>Archive</button>
                     </td>
                   </tr>
                 ))}
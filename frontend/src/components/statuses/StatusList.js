@@ -50,7 +50,7 @@ const StatusList = () => {
                       <td>{status.color}</td>
                       <td>
                         <Link to={`/statuses/${status.status_id}/edit`}>Edit</Link>
-                        <button onClick={() => handleDelete(status.status_id)}>Delete</button>
+                        <button onClick={() => handleDelete(status.status_id)}>Archive</button>
                       </td>
                   </tr>
                 ))}